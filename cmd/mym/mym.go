@@ -2,29 +2,82 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/auth"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/backfill"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/client"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/dedupe"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/reparse"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/scraper"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/storage"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	defaultBrowserTimeout = 60 * time.Second
+	defaultBrowserTimeout = auth.DefaultTimeout
+	defaultProbeURL       = "https://guide.michelin.com/en/restaurants/3-stars-michelin"
 	helpLongFlag          = "--help"
 	helpShortFlag         = "-h"
+
+	// exitSessionExpired is the exit code of scrape once every session has
+	// expired, and of session status when one needs a new login, so the
+	// entrypoint re-logs in on either.
+	exitSessionExpired = 2
+)
+
+const (
+	commandBackfill   = "backfill"
+	commandCache      = "cache"
+	commandConflicts  = "conflicts"
+	commandDuplicates = "duplicates"
+	commandScrape     = "scrape"
+	commandLogin      = "login"
+	commandMerge      = "merge"
+	commandOverride   = "override"
+	commandQueue      = "queue"
+	commandReparse    = "reparse"
+	commandSession    = "session"
+	commandVersion    = "version"
+)
+
+const (
+	queueStatus      = "status"
+	queueList        = "list"
+	queueClear       = "clear"
+	queueRetryFailed = "retry-failed"
+	queueAdd         = "add"
 )
 
 const (
-	commandBackfill = "backfill"
-	commandScrape   = "scrape"
-	commandLogin    = "login"
-	commandVersion  = "version"
+	overrideSet    = "set"
+	overrideList   = "list"
+	overrideRemove = "remove"
+)
+
+const (
+	sessionStatus = "status"
+)
+
+const (
+	cacheStats = "stats"
+	cachePrune = "prune"
+	cachePurge = "purge"
 )
 
 // run contains the main application logic of the CLI tool
@@ -57,6 +110,22 @@ func handleCommand(arg []string) error {
 		return handleBackfill(arg[2:])
 	case commandLogin:
 		return handleLogin(arg[2:])
+	case commandQueue:
+		return handleQueue(arg[2:])
+	case commandCache:
+		return handleCache(arg[2:])
+	case commandReparse:
+		return handleReparse(arg[2:])
+	case commandConflicts:
+		return handleConflicts(arg[2:])
+	case commandOverride:
+		return handleOverride(arg[2:])
+	case commandSession:
+		return handleSession(arg[2:])
+	case commandDuplicates:
+		return handleDuplicates(arg[2:])
+	case commandMerge:
+		return handleMerge(arg[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: \"%s\"\n\n", command)
 		printUsage()
@@ -87,7 +156,15 @@ func printUsage() {
 	fmt.Println("<command>")
 	fmt.Println("  scrape     scrape latest restaurant data or a single restaurant if <url> is provided")
 	fmt.Println("  backfill   backfill restaurant data or a single restaurant if <url> is provided")
-	fmt.Println("  login      login and store session cookies in sqlite storage")
+	fmt.Println("  login      login, or import a cookies file with -cookies-file, and store session cookies in the encrypted cookie store")
+	fmt.Println("  session    check the encrypted session cookies and whether they still work (status)")
+	fmt.Println("  cache      inspect and maintain the response cache (stats, prune, purge <url>)")
+	fmt.Println("  reparse    re-run extraction over archived pages, a single restaurant if <url> is provided, or WARC files with -warc")
+	fmt.Println("  conflicts  list award disagreements between live scrapes and web archives, and how they were settled")
+	fmt.Println("  duplicates list restaurants stored more than once under different urls")
+	fmt.Println("  merge      fold duplicate restaurants into a canonical one, keeping their urls as aliases")
+	fmt.Println("  override   pin award fields for a restaurant and year over scraped data (set, list, remove)")
+	fmt.Println("  queue      inspect and manage the crawl queue (status, list, clear, retry-failed -run <run>, add <url>)")
 	fmt.Println("  version    show version")
 	fmt.Println("")
 	fmt.Println("[options]")
@@ -117,6 +194,7 @@ func handleScrape(args []string) error {
 	scrapeCmd := flag.NewFlagSet(commandScrape, flag.ExitOnError)
 	logLevel := scrapeCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
 	ignoreCache := scrapeCmd.Bool("no-cache", false, "skip using scrape cache")
+	discovery := scrapeCmd.String("discovery", scraper.DiscoveryListing, "how to find restaurant pages ("+strings.Join(scraper.DiscoveryModes, ", ")+"); sitemap falls back to listing")
 
 	if err := scrapeCmd.Parse(args); err != nil {
 		return err
@@ -128,10 +206,11 @@ func handleScrape(args []string) error {
 
 	urlArg := scrapeCmd.Arg(0)
 
-	app, err := scraper.New(*ignoreCache)
+	app, err := scraper.New(*ignoreCache, *discovery)
 	if err != nil {
 		return fmt.Errorf("failed to create live scraper: %w", err)
 	}
+	defer closeLogged(app, "scraper")
 
 	log.Info("running scrape command")
 	ctx := context.Background()
@@ -146,6 +225,17 @@ func handleBackfill(args []string) error {
 	backfillCmd := flag.NewFlagSet(commandBackfill, flag.ExitOnError)
 	logLevel := backfillCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
 	ignoreCache := backfillCmd.Bool("no-cache", false, "skip using wayback cache")
+	latestPerYear := backfillCmd.Bool("latest-per-year", false, "only process the latest distinct snapshot of each year")
+	from := backfillCmd.String("from", "", "only process snapshots captured at or after this timestamp (yyyy[MM[dd[hhmmss]]])")
+	to := backfillCmd.String("to", "", "only process snapshots captured at or before this timestamp (yyyy[MM[dd[hhmmss]]])")
+	years := backfillCmd.String("years", "", "only process snapshots captured in these years, e.g. 2019-2021,2023")
+	location := backfillCmd.String("location", "", "only backfill restaurants whose location contains this text")
+	distinction := backfillCmd.String("distinction", "", "only backfill restaurants that have held one of these comma-separated distinctions")
+	staleOnly := backfillCmd.Bool("stale-only", false, "only backfill restaurants without an award for the latest guide year")
+	sources := backfillCmd.String("sources", webarchive.Wayback, "comma-separated web archives to backfill from ("+strings.Join(webarchive.Names(), ", ")+")")
+	full := backfillCmd.Bool("full", false, "ignore per-restaurant checkpoints and reprocess every snapshot")
+	discover := backfillCmd.Bool("discover", false, "find and backfill restaurants in the wayback machine that are missing from the database")
+	prefix := backfillCmd.String("prefix", backfill.DefaultDiscoveryPrefix, "url prefix to search with -discover, e.g. guide.michelin.com/sg/")
 
 	if err := backfillCmd.Parse(args); err != nil {
 		return err
@@ -157,19 +247,382 @@ func handleBackfill(args []string) error {
 
 	urlArg := backfillCmd.Arg(0)
 
-	app, err := backfill.New(*ignoreCache)
+	opts := backfill.Options{
+		Sources:       splitList(*sources),
+		From:          *from,
+		To:            *to,
+		LatestPerYear: *latestPerYear,
+		Location:      *location,
+		StaleOnly:     *staleOnly,
+		Full:          *full,
+	}
+	if *years != "" {
+		parsed, err := backfill.ParseYears(*years)
+		if err != nil {
+			return err
+		}
+		opts.Years = parsed
+	}
+	opts.Distinctions = splitList(*distinction)
+
+	app, err := backfill.New(*ignoreCache, opts)
 	if err != nil {
 		return fmt.Errorf("failed to create backfill scraper: %w", err)
 	}
+	defer closeLogged(app, "backfill scraper")
 
 	log.Info("running backfill command")
 	ctx := context.Background()
+	if *discover {
+		return app.Discover(ctx, *prefix)
+	}
+	if urlArg != "" {
+		return app.Run(ctx, urlArg)
+	}
+	return app.RunAll(ctx)
+}
+
+// closeLogged closes what a command opened once it is done, logging rather
+// than returning the error so it does not mask the command's own.
+func closeLogged(c io.Closer, name string) {
+	if err := c.Close(); err != nil {
+		log.WithError(err).WithField("component", name).Warn("failed to close")
+	}
+}
+
+// openRepository opens the database with the merge policy selected by
+// MYM_MERGE_POLICY, which merges and overrides roll awards up with.
+func openRepository() (*storage.SQLiteRepository, error) {
+	policy, err := storage.MergePolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewSQLiteRepository(client.DefaultDataPath, policy)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// handleReparse handles the 'reparse' subcommand
+func handleReparse(args []string) error {
+	reparseCmd := flag.NewFlagSet(commandReparse, flag.ExitOnError)
+	logLevel := reparseCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	warcInput := reparseCmd.Bool("warc", false, "treat the arguments as WARC files to reparse instead of the archive")
+
+	if err := reparseCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	urlArg := reparseCmd.Arg(0)
+
+	app, err := reparse.New()
+	if err != nil {
+		return fmt.Errorf("failed to create reparser: %w", err)
+	}
+	defer closeLogged(app, "reparser")
+
+	log.Info("running reparse command")
+	ctx := context.Background()
+	if *warcInput {
+		if reparseCmd.NArg() == 0 {
+			return fmt.Errorf("usage: mym reparse -warc <file.warc.gz>...")
+		}
+		return app.RunWARC(ctx, reparseCmd.Args())
+	}
 	if urlArg != "" {
 		return app.Run(ctx, urlArg)
 	}
 	return app.RunAll(ctx)
 }
 
+// handleConflicts handles the 'conflicts' subcommand
+func handleConflicts(args []string) error {
+	conflictsCmd := flag.NewFlagSet(commandConflicts, flag.ExitOnError)
+	logLevel := conflictsCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	year := conflictsCmd.Int("year", 0, "only list conflicts for this award year")
+	decisions := []string{models.ConflictOverwritten, models.ConflictUpgraded, models.ConflictKept}
+	decision := conflictsCmd.String("decision", "", "only list conflicts settled this way ("+strings.Join(decisions, ", ")+")")
+	distinctionOnly := conflictsCmd.Bool("distinction-only", false, "only list conflicts where the distinction differs")
+	limit := conflictsCmd.Int("limit", 50, "maximum number of conflicts to list (0 for all)")
+
+	if err := conflictsCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	if *decision != "" && !slices.Contains(decisions, *decision) {
+		return fmt.Errorf("invalid decision %q: want one of %s", *decision, strings.Join(decisions, ", "))
+	}
+
+	repo, err := openRepository()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer closeLogged(repo, "database")
+
+	conflicts, err := repo.ListConflicts(context.Background(), storage.ConflictFilter{
+		Year:            *year,
+		Decision:        *decision,
+		URL:             conflictsCmd.Arg(0),
+		DistinctionOnly: *distinctionOnly,
+		Limit:           *limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "YEAR\tRESTAURANT\tEXISTING\tINCOMING\tDECISION\tLAST SEEN\tURL")
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "%d\t%s\t%s (%s)\t%s (%s)\t%s\t%s\t%s\n",
+			c.Year, c.Restaurant.Name,
+			formatAward(c.ExistingDistinction, c.ExistingPrice, c.ExistingGreenStar), c.ExistingSource,
+			formatAward(c.IncomingDistinction, c.IncomingPrice, c.IncomingGreenStar), c.IncomingSource,
+			c.Decision, c.UpdatedAt.UTC().Format(time.RFC3339), c.Restaurant.URL)
+	}
+	return w.Flush()
+}
+
+// handleDuplicates handles the 'duplicates' subcommand
+func handleDuplicates(args []string) error {
+	duplicatesCmd := flag.NewFlagSet(commandDuplicates, flag.ExitOnError)
+	logLevel := duplicatesCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	distance := duplicatesCmd.Float64("distance", dedupe.DefaultMaxDistance, "maximum distance in meters for coordinates to match")
+
+	if err := duplicatesCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	repo, err := openRepository()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer closeLogged(repo, "database")
+	restaurants, err := repo.ListRestaurants(context.Background())
+	if err != nil {
+		return err
+	}
+
+	groups := dedupe.Find(restaurants, *distance)
+	for _, g := range groups {
+		fmt.Printf("%s (%s)\n", g.Canonical.Name, strings.Join(g.Reasons, ", "))
+		fmt.Printf("  keep:  %s\n", g.Canonical.URL)
+		urls := []string{g.Canonical.URL}
+		for _, d := range g.Duplicates {
+			fmt.Printf("  merge: %s\n", d.URL)
+			urls = append(urls, d.URL)
+		}
+		fmt.Printf("  %s %s %s\n\n", os.Args[0], commandMerge, strings.Join(urls, " "))
+	}
+	fmt.Printf("%d duplicate groups\n", len(groups))
+	return nil
+}
+
+// handleMerge handles the 'merge' subcommand
+func handleMerge(args []string) error {
+	mergeCmd := flag.NewFlagSet(commandMerge, flag.ExitOnError)
+	logLevel := mergeCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	all := mergeCmd.Bool("all", false, "merge every group listed by the duplicates command")
+	distance := mergeCmd.Float64("distance", dedupe.DefaultMaxDistance, "maximum distance in meters for coordinates to match, with -all")
+
+	if err := mergeCmd.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	if *all == (mergeCmd.NArg() > 0) {
+		return fmt.Errorf("usage: merge <canonical-url> <duplicate-url>... or merge -all")
+	}
+
+	repo, err := openRepository()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer closeLogged(repo, "database")
+	ctx := context.Background()
+
+	if *all {
+		restaurants, err := repo.ListRestaurants(ctx)
+		if err != nil {
+			return err
+		}
+		groups := dedupe.Find(restaurants, *distance)
+		for _, g := range groups {
+			var ids []uint
+			for _, d := range g.Duplicates {
+				ids = append(ids, d.ID)
+			}
+			if err := repo.MergeRestaurants(ctx, g.Canonical.ID, ids); err != nil {
+				return fmt.Errorf("failed to merge into %s: %w", g.Canonical.URL, err)
+			}
+		}
+		log.WithField("groups", len(groups)).Info("merged duplicate restaurants")
+		return nil
+	}
+
+	if mergeCmd.NArg() < 2 {
+		return fmt.Errorf("usage: merge <canonical-url> <duplicate-url>...")
+	}
+	var restaurants []*models.Restaurant
+	for _, u := range mergeCmd.Args() {
+		restaurant, err := repo.FindRestaurantByURL(ctx, parsers.CanonicalURL(u))
+		if err != nil {
+			return fmt.Errorf("failed to find restaurant %s: %w", u, err)
+		}
+		restaurants = append(restaurants, restaurant)
+	}
+	var ids []uint
+	for _, d := range restaurants[1:] {
+		if d.ID == restaurants[0].ID {
+			return fmt.Errorf("%s is already %s", d.URL, restaurants[0].URL)
+		}
+		ids = append(ids, d.ID)
+	}
+	return repo.MergeRestaurants(ctx, restaurants[0].ID, ids)
+}
+
+// handleOverride handles the 'override' subcommand and its actions
+func handleOverride(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: override <%s|%s|%s> [options]", overrideSet, overrideList, overrideRemove)
+	}
+	action := args[0]
+
+	overrideCmd := flag.NewFlagSet(commandOverride+" "+action, flag.ExitOnError)
+	logLevel := overrideCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	distinction := overrideCmd.String("distinction", "", "distinction to pin ("+strings.Join(models.Distinctions, ", ")+")")
+	price := overrideCmd.String("price", "", "price to pin")
+	greenStar := overrideCmd.String("green-star", "", "green star to pin (true or false)")
+	reason := overrideCmd.String("reason", "", "why the override is needed")
+
+	if err := overrideCmd.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	repo, err := openRepository()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer closeLogged(repo, "database")
+	ctx := context.Background()
+
+	// set and remove address a single award by restaurant URL and year.
+	target := func() (*models.Restaurant, int, error) {
+		if overrideCmd.NArg() != 2 {
+			return nil, 0, fmt.Errorf("usage: override %s [options] <url> <year>", action)
+		}
+		year, err := strconv.Atoi(overrideCmd.Arg(1))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid year %q: %w", overrideCmd.Arg(1), err)
+		}
+		restaurant, err := repo.FindRestaurantByURL(ctx, parsers.CanonicalURL(overrideCmd.Arg(0)))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to find restaurant %s: %w", overrideCmd.Arg(0), err)
+		}
+		return restaurant, year, nil
+	}
+
+	switch action {
+	case overrideSet:
+		restaurant, year, err := target()
+		if err != nil {
+			return err
+		}
+		override := &models.AwardOverride{
+			RestaurantID: restaurant.ID,
+			Year:         year,
+			Distinction:  *distinction,
+			Price:        *price,
+			Reason:       *reason,
+		}
+		if *greenStar != "" {
+			pinned, err := strconv.ParseBool(*greenStar)
+			if err != nil {
+				return fmt.Errorf("invalid green star %q: %w", *greenStar, err)
+			}
+			override.GreenStar = &pinned
+		}
+		if err := repo.SaveOverride(ctx, override); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"url":  restaurant.URL,
+			"year": year,
+		}).Info("award override set")
+	case overrideList:
+		overrides, err := repo.ListOverrides(ctx, overrideCmd.Arg(0))
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "YEAR\tRESTAURANT\tDISTINCTION\tPRICE\tGREEN STAR\tREASON\tUPDATED\tURL")
+		for _, o := range overrides {
+			green := ""
+			if o.GreenStar != nil {
+				green = strconv.FormatBool(*o.GreenStar)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				o.Year, o.Restaurant.Name, o.Distinction, o.Price, green, o.Reason,
+				o.UpdatedAt.UTC().Format(time.RFC3339), o.Restaurant.URL)
+		}
+		return w.Flush()
+	case overrideRemove:
+		restaurant, year, err := target()
+		if err != nil {
+			return err
+		}
+		removed, err := repo.DeleteOverride(ctx, restaurant.ID, year)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("no override for %s in %d", restaurant.URL, year)
+		}
+		log.WithFields(log.Fields{
+			"url":  restaurant.URL,
+			"year": year,
+		}).Info("award override removed")
+	default:
+		return fmt.Errorf("unknown override action: %s", action)
+	}
+	return nil
+}
+
+// formatAward renders an award as e.g. "1 Star, $$, green star"
+func formatAward(distinction, price string, greenStar bool) string {
+	s := distinction + ", " + price
+	if greenStar {
+		s += ", green star"
+	}
+	return s
+}
+
 // handleLogin handles the 'login' subcommand
 func handleLogin(args []string) error {
 	loginCmd := flag.NewFlagSet("login", flag.ExitOnError)
@@ -179,6 +632,8 @@ func handleLogin(args []string) error {
 	headless := loginCmd.Bool("headless", true, "run browser headless")
 	timeout := loginCmd.Duration("timeout", defaultBrowserTimeout, "login flow timeout")
 	ignoreCache := loginCmd.Bool("no-cache", false, "skip using wayback cache")
+	cookiesFile := loginCmd.String("cookies-file", os.Getenv("MYM_COOKIES_FILE"), "import session cookies from a Netscape cookies.txt or JSON export instead of logging in with a browser (falls back to MYM_COOKIES_FILE env var)")
+	accountsFile := loginCmd.String("accounts", os.Getenv("MYM_ACCOUNTS_FILE"), "JSON file of accounts to log in, one pooled session each (falls back to MYM_ACCOUNTS_FILE env var)")
 
 	if err := loginCmd.Parse(args); err != nil {
 		return err
@@ -190,22 +645,333 @@ func handleLogin(args []string) error {
 
 	ctx := context.Background()
 	log.Info("running login command")
-	cookies, err := auth.Login(ctx, *email, *password, *headless, *timeout)
-	if err != nil {
-		return err
+	accounts := []auth.Account{{
+		Name:        cookiestore.DefaultSession,
+		Email:       *email,
+		Password:    *password,
+		CookiesFile: *cookiesFile,
+	}}
+	if *accountsFile != "" {
+		var err error
+		accounts, err = auth.LoadAccounts(*accountsFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	sessions := make(map[string][]*http.Cookie, len(accounts))
+	for _, a := range accounts {
+		cookies, err := a.Cookies(ctx, *headless, *timeout)
+		if err != nil {
+			if len(accounts) == 1 {
+				return err
+			}
+			log.WithError(err).WithField("session", a.Name).Error("failed to log in account")
+			continue
+		}
+		sessions[a.Name] = cookies
+		log.WithFields(log.Fields{
+			"cookie_count": len(cookies),
+			"session":      a.Name,
+		}).Info("session stored")
 	}
-	app, err := scraper.New(*ignoreCache)
+	if len(sessions) == 0 {
+		return errors.New("no account logged in")
+	}
+
+	app, err := scraper.New(*ignoreCache, "")
 	if err != nil {
 		return fmt.Errorf("failed to create scraper: %w", err)
 	}
-	if err := app.InitCookies(cookies); err != nil {
+	defer closeLogged(app, "scraper")
+	if err := app.InitSessions(sessions); err != nil {
 		return fmt.Errorf("failed to persist session cookies: %w", err)
 	}
-	log.WithField("cookie_count", len(cookies)).Info("session stored")
 	log.Info("login command completed")
 	return nil
 }
 
+// handleQueue handles the 'queue' subcommand and its actions
+func handleQueue(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: queue <%s|%s|%s|%s|%s> [options]", queueStatus, queueList, queueClear, queueRetryFailed, queueAdd)
+	}
+	action := args[0]
+
+	queueCmd := flag.NewFlagSet(commandQueue+" "+action, flag.ExitOnError)
+	logLevel := queueCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	limit := queueCmd.Int("limit", 20, "maximum number of queued requests to list (0 for all)")
+	location := queueCmd.String("location", "", "location to attach to the request added to the queue")
+	run := queueCmd.String("run", "", fmt.Sprintf("run whose failed requests to re-enqueue (%s)", strings.Join(client.Runs, ", ")))
+
+	if err := queueCmd.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	cl, err := client.New(&client.Config{
+		StoragePath: client.DefaultStoragePath,
+		ThreadCount: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open crawl storage: %w", err)
+	}
+	defer closeLogged(cl, "crawl storage")
+
+	switch action {
+	case queueStatus:
+		stats, err := cl.QueueStats()
+		if err != nil {
+			return fmt.Errorf("failed to read queue status: %w", err)
+		}
+		fmt.Printf("pending: %d\n", stats.Pending)
+		fmt.Printf("visited: %d\n", stats.Visited)
+		fmt.Printf("failed:  %d\n", stats.Failed)
+	case queueList:
+		items, err := cl.ListQueue(*limit)
+		if err != nil {
+			return fmt.Errorf("failed to list queue: %w", err)
+		}
+		for _, item := range items {
+			fmt.Printf("%d\t%s\t%s\t%s\n", item.ID, item.Method, item.URL, item.Location)
+		}
+	case queueClear:
+		n, err := cl.ClearQueue()
+		if err != nil {
+			return fmt.Errorf("failed to clear queue: %w", err)
+		}
+		log.WithField("removed", n).Info("queue cleared")
+	case queueRetryFailed:
+		if *run == "" {
+			return fmt.Errorf("usage: queue %s -run <%s>", queueRetryFailed, strings.Join(client.Runs, "|"))
+		}
+		n, err := cl.RetryFailed(*run)
+		if err != nil {
+			return fmt.Errorf("failed to retry failed requests after %d: %w", n, err)
+		}
+		log.WithFields(log.Fields{
+			"requeued": n,
+			"run":      *run,
+		}).Info("failed requests re-enqueued")
+	case queueAdd:
+		urlArg := queueCmd.Arg(0)
+		if urlArg == "" {
+			return fmt.Errorf("usage: queue %s [options] <url>", queueAdd)
+		}
+		if err := cl.ForgetVisited(urlArg); err != nil {
+			return fmt.Errorf("failed to reset visited state: %w", err)
+		}
+		if err := cl.EnqueueURLWithContext(urlArg, *location); err != nil {
+			return fmt.Errorf("failed to enqueue url: %w", err)
+		}
+		log.WithField("url", urlArg).Info("url enqueued")
+	default:
+		return fmt.Errorf("unknown queue action: %s", action)
+	}
+	return nil
+}
+
+// handleSession handles the 'session' subcommand and its actions. status
+// returns scraper.ErrSessionExpired, which main maps to exitSessionExpired,
+// when any stored session needs a new login.
+func handleSession(args []string) error {
+	if len(args) < 1 || args[0] != sessionStatus {
+		return fmt.Errorf("usage: session <%s> [options]", sessionStatus)
+	}
+
+	sessionCmd := flag.NewFlagSet(commandSession+" "+args[0], flag.ExitOnError)
+	logLevel := sessionCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	probeURL := sessionCmd.String("url", defaultProbeURL, "page to request with the session to confirm it works")
+	noProbe := sessionCmd.Bool("no-probe", false, "only inspect the stored cookies, without a request")
+	minTTL := sessionCmd.Duration("min-ttl", 0, "treat the session as expired when a cookie expires within this duration")
+
+	if err := sessionCmd.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	cookieKey, err := cookiestore.KeyFromEnv()
+	if err != nil {
+		return err
+	}
+	store, err := cookiestore.New(client.DefaultCookiePath, cookieKey)
+	if err != nil {
+		return err
+	}
+	names, err := store.Sessions()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Println("session: expired (no session cookies stored)")
+		return fmt.Errorf("no session cookies stored: %w", scraper.ErrSessionExpired)
+	}
+
+	expired := 0
+	for i, name := range names {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("[%s]\n", name)
+		problems, err := checkSession(name, cookieKey, *probeURL, !*noProbe, *minTTL)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			expired++
+			fmt.Printf("\nsession: expired (%s)\n", strings.Join(problems, "; "))
+		} else {
+			fmt.Println("\nsession: valid")
+		}
+	}
+
+	if expired > 0 {
+		return fmt.Errorf("%d of %d sessions need a new login: %w", expired, len(names), scraper.ErrSessionExpired)
+	}
+	return nil
+}
+
+// checkSession prints the cookies of a stored session, probes it, and returns
+// the reasons it needs a new login, if any.
+func checkSession(name string, cookieKey []byte, probeURL string, probe bool, minTTL time.Duration) ([]string, error) {
+	cl, err := client.New(&client.Config{
+		AllowedDomains: []string{"guide.michelin.com"},
+		CookiePath:     client.DefaultCookiePath,
+		CookieKey:      cookieKey,
+		Session:        name,
+		StoragePath:    client.DefaultStoragePath,
+		ThreadCount:    1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer closeLogged(cl, "crawl storage")
+
+	now := time.Now()
+	cookies, err := cl.SessionCookies(name)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	if len(cookies) == 0 {
+		problems = append(problems, "no session cookies stored")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDOMAIN\tEXPIRES\tIN")
+	for _, c := range cookies {
+		if c.Expires.IsZero() {
+			fmt.Fprintf(tw, "%s\t%s\tsession\t-\n", c.Name, c.Domain)
+			continue
+		}
+		left := c.Expires.Sub(now)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Domain, c.Expires.UTC().Format(time.RFC3339), left.Truncate(time.Minute))
+		if left <= minTTL {
+			problems = append(problems, fmt.Sprintf("cookie %s expires in %s", c.Name, left.Truncate(time.Minute)))
+		}
+	}
+	tw.Flush()
+
+	if probe && len(cookies) > 0 {
+		status, err := cl.Probe(probeURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe session %s: %w", name, err)
+		}
+		fmt.Printf("\nprobe: %s %d %s\n", probeURL, status, http.StatusText(status))
+		if status != http.StatusOK {
+			problems = append(problems, fmt.Sprintf("probe returned %d", status))
+		}
+	}
+	return problems, nil
+}
+
+// handleCache handles the 'cache' subcommand and its actions
+func handleCache(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: cache <%s|%s|%s> [options]", cacheStats, cachePrune, cachePurge)
+	}
+	action := args[0]
+
+	cacheCmd := flag.NewFlagSet(commandCache+" "+action, flag.ExitOnError)
+	logLevel := cacheCmd.String("log", log.InfoLevel.String(), "log level (debug, info, warning, error, fatal, panic)")
+	backend := cacheCmd.String("backend", os.Getenv("MYM_CACHE_BACKEND"), "cache backend, file or sqlite (falls back to MYM_CACHE_BACKEND env var)")
+
+	if err := cacheCmd.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if err := setupLogging(*logLevel); err != nil {
+		return err
+	}
+
+	caches := make([]client.Cache, len(client.CacheNamespaces))
+	for i, ns := range client.CacheNamespaces {
+		cache, err := client.OpenCache(*backend, ns.Path, ns.TTL)
+		if err != nil {
+			return fmt.Errorf("failed to open %s cache: %w", ns.Name, err)
+		}
+		defer cache.Close()
+		caches[i] = cache
+	}
+
+	switch action {
+	case cacheStats:
+		for i, ns := range client.CacheNamespaces {
+			stats, err := caches[i].Stats()
+			if err != nil {
+				return fmt.Errorf("failed to read %s cache: %w", ns.Name, err)
+			}
+			fmt.Printf("%s (%s, ttl %s)\n", ns.Name, ns.Path, formatTTL(ns.TTL))
+			fmt.Printf("  entries: %d\n", stats.Entries)
+			fmt.Printf("  expired: %d\n", stats.Expired)
+			fmt.Printf("  size:    %d bytes\n", stats.Bytes)
+			if stats.Entries > 0 {
+				fmt.Printf("  oldest:  %s\n", stats.Oldest.UTC().Format(time.RFC3339))
+				fmt.Printf("  newest:  %s\n", stats.Newest.UTC().Format(time.RFC3339))
+			}
+		}
+	case cachePrune:
+		for i, ns := range client.CacheNamespaces {
+			removed, err := caches[i].Prune()
+			if err != nil {
+				return fmt.Errorf("failed to prune %s cache: %w", ns.Name, err)
+			}
+			log.WithFields(log.Fields{
+				"namespace": ns.Name,
+				"removed":   removed,
+			}).Info("cache pruned")
+		}
+	case cachePurge:
+		urlArg := cacheCmd.Arg(0)
+		if urlArg == "" {
+			return fmt.Errorf("usage: cache %s [options] <url>", cachePurge)
+		}
+		for i, ns := range client.CacheNamespaces {
+			if err := caches[i].Delete(urlArg); err != nil {
+				return fmt.Errorf("failed to purge %s from %s cache: %w", urlArg, ns.Name, err)
+			}
+		}
+		log.WithField("url", urlArg).Info("cache entry purged")
+	default:
+		return fmt.Errorf("unknown cache action: %s", action)
+	}
+	return nil
+}
+
+// formatTTL renders a cache TTL, where zero means entries never expire
+func formatTTL(ttl time.Duration) string {
+	if ttl <= 0 {
+		return "never expires"
+	}
+	return ttl.String()
+}
+
 // main is the entry point for the mym CLI tool
 func main() {
 	if err := os.Setenv("TZ", time.UTC.String()); err != nil {
@@ -215,6 +981,9 @@ func main() {
 
 	if err := run(); err != nil {
 		log.Error(err)
+		if errors.Is(err, scraper.ErrSessionExpired) {
+			os.Exit(exitSessionExpired)
+		}
 		os.Exit(1)
 	}
 }
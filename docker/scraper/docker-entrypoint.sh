@@ -27,8 +27,14 @@ check_environment() {
     check_env_var "MINIO_BUCKET"
     check_env_var "MINIO_ENDPOINT"
     check_env_var "MINIO_SECRET_KEY"
-    check_env_var "MYM_EMAIL"
-    check_env_var "MYM_PASSWORD"
+    if [ -z "${MYM_COOKIE_KEY:-}" ] && [ -z "${MYM_COOKIE_KEY_FILE:-}" ]; then
+        echo "error: MYM_COOKIE_KEY or MYM_COOKIE_KEY_FILE must be set to encrypt session cookies."
+        exit 1
+    fi
+    if [ -z "${MYM_COOKIES_FILE:-}" ] && [ -z "${MYM_ACCOUNTS_FILE:-}" ]; then
+        check_env_var "MYM_EMAIL"
+        check_env_var "MYM_PASSWORD"
+    fi
     check_env_var "RAILWAY_API_TOKEN"
 }
 
@@ -99,8 +105,13 @@ run_mym() {
     echo "run mym"
     echo "database will be created at $DB_FILE"
 
-    rm -rf cache/
-    mym login
+    mym cache prune
+    if mym session status -log warn -min-ttl 1h; then
+        echo "stored session is still valid, skip login"
+    else
+        echo "stored session is missing or expiring, logging in"
+        mym login
+    fi
 
     while true; do
         mym scrape -log warn
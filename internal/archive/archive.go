@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+)
+
+// Page is the raw HTML of a restaurant detail page as it was fetched.
+type Page struct {
+	Hash       string // sha256 of Body
+	URL        string
+	WaybackURL string // "" for live scraping
+	Location   string // location carried over from the listing page, if any
+	FetchedAt  time.Time
+	Body       []byte
+}
+
+// PageFromResponse captures a fetched detail page for archival.
+func PageFromResponse(r *colly.Response) *Page {
+	url, waybackURL := parsers.ParseRequestURL(r.Request.URL.String())
+	return &Page{
+		URL:        url,
+		WaybackURL: waybackURL,
+		Location:   r.Ctx.Get("location"),
+		FetchedAt:  time.Now().UTC(),
+		Body:       r.Body,
+	}
+}
+
+// RequestURL returns the URL the page was fetched from.
+func (p *Page) RequestURL() string {
+	if p.WaybackURL != "" {
+		return p.WaybackURL
+	}
+	return p.URL
+}
+
+// Store keeps raw pages content-addressed in a single sqlite file: each
+// distinct body is stored once, gzip-compressed, and every fetch of it is
+// recorded with its source URL and fetch time.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the archive at path.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	statements := []string{
+		"PRAGMA journal_mode = WAL;",
+		`CREATE TABLE IF NOT EXISTS blobs (
+			hash TEXT PRIMARY KEY,
+			body BLOB NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS pages (
+			id INTEGER PRIMARY KEY,
+			hash TEXT NOT NULL REFERENCES blobs(hash),
+			url TEXT NOT NULL,
+			wayback_url TEXT NOT NULL DEFAULT '',
+			location TEXT NOT NULL DEFAULT '',
+			fetched_at DATETIME NOT NULL,
+			UNIQUE (hash, url, wayback_url)
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_pages_url ON pages (url)",
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// Save stores a page. Re-saving an identical body for the same URL keeps the
+// first fetch time.
+func (s *Store) Save(p *Page) error {
+	sum := sha256.Sum256(p.Body)
+	p.Hash = hex.EncodeToString(sum[:])
+	if p.FetchedAt.IsZero() {
+		p.FetchedAt = time.Now().UTC()
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(p.Body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT OR IGNORE INTO blobs (hash, body) VALUES (?, ?)", p.Hash, buf.Bytes()); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT OR IGNORE INTO pages (hash, url, wayback_url, location, fetched_at) VALUES (?, ?, ?, ?, ?)",
+		p.Hash, p.URL, p.WaybackURL, p.Location, p.FetchedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Each calls fn for every archived page in fetch order, so that replaying the
+// archive applies captures in the order they were originally seen. When url is
+// non-empty only pages of that restaurant are visited.
+func (s *Store) Each(url string, fn func(*Page) error) error {
+	query := "SELECT id FROM pages"
+	var args []any
+	if url != "" {
+		query += " WHERE url = ?"
+		args = append(args, url)
+	}
+	query += " ORDER BY fetched_at, id"
+
+	// Collect ids first: fn may take a while per page and the single
+	// connection would otherwise be held by the open cursor.
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		p, err := s.page(id)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) page(id int64) (*Page, error) {
+	var (
+		p          Page
+		compressed []byte
+	)
+	err := s.db.QueryRow(
+		`SELECT p.hash, p.url, p.wayback_url, p.location, p.fetched_at, b.body
+		FROM pages p JOIN blobs b ON b.hash = p.hash WHERE p.id = ?`, id,
+	).Scan(&p.Hash, &p.URL, &p.WaybackURL, &p.Location, &p.FetchedAt, &compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	if p.Body, err = io.ReadAll(zr); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "archive.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStoreDeduplicatesAndReplaysInFetchOrder(t *testing.T) {
+	store := newTestStore(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pages := []*Page{
+		{URL: "https://guide.michelin.com/a", WaybackURL: "https://web.archive.org/web/20230101000000id_/https://guide.michelin.com/a", FetchedAt: base.Add(2 * time.Hour), Body: []byte("<html>2023</html>")},
+		{URL: "https://guide.michelin.com/a", FetchedAt: base.Add(time.Hour), Body: []byte("<html>live</html>"), Location: "Singapore"},
+		// same body and URL again: should not create a second page row
+		{URL: "https://guide.michelin.com/a", FetchedAt: base.Add(3 * time.Hour), Body: []byte("<html>live</html>")},
+		{URL: "https://guide.michelin.com/b", FetchedAt: base, Body: []byte("<html>live</html>")},
+	}
+	for _, p := range pages {
+		if err := store.Save(p); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if pages[1].Hash != pages[3].Hash || pages[1].Hash == "" {
+		t.Fatalf("identical bodies should share a hash: %q vs %q", pages[1].Hash, pages[3].Hash)
+	}
+
+	var blobs int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM blobs").Scan(&blobs); err != nil {
+		t.Fatalf("count blobs: %v", err)
+	}
+	if blobs != 2 {
+		t.Errorf("blobs = %d, want 2", blobs)
+	}
+
+	var got []*Page
+	if err := store.Each("", func(p *Page) error {
+		got = append(got, p)
+		return nil
+	}); err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Each visited %d pages, want 3", len(got))
+	}
+	if got[0].URL != "https://guide.michelin.com/b" || got[1].Location != "Singapore" || got[2].WaybackURL == "" {
+		t.Errorf("unexpected replay order: %+v, %+v, %+v", got[0], got[1], got[2])
+	}
+	if string(got[1].Body) != "<html>live</html>" {
+		t.Errorf("Body = %q", got[1].Body)
+	}
+	if got[2].RequestURL() != pages[0].WaybackURL {
+		t.Errorf("RequestURL = %q; want wayback url", got[2].RequestURL())
+	}
+
+	var filtered int
+	if err := store.Each("https://guide.michelin.com/a", func(p *Page) error {
+		filtered++
+		return nil
+	}); err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	if filtered != 2 {
+		t.Errorf("Each(url) visited %d pages, want 2", filtered)
+	}
+}
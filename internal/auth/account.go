@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Account is one Michelin Guide login in a session pool, either credentials
+// for the browser login or a cookies file to import.
+type Account struct {
+	Name        string `json:"name"`
+	Email       string `json:"email,omitempty"`
+	Password    string `json:"password,omitempty"`
+	CookiesFile string `json:"cookies_file,omitempty"`
+}
+
+// LoadAccounts reads a JSON array of accounts, e.g.
+//
+//	[{"name": "a", "email": "a@example.com", "password": "..."},
+//	 {"name": "b", "cookies_file": "/run/secrets/b.txt"}]
+func LoadAccounts(path string) ([]Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts file: %w", err)
+	}
+	var accounts []Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts file: %w", err)
+	}
+	if len(accounts) == 0 {
+		return nil, errors.New("accounts file lists no accounts")
+	}
+
+	seen := make(map[string]bool, len(accounts))
+	for i, a := range accounts {
+		if a.Name == "" {
+			return nil, fmt.Errorf("account %d has no name", i+1)
+		}
+		if seen[a.Name] {
+			return nil, fmt.Errorf("duplicate account %q", a.Name)
+		}
+		seen[a.Name] = true
+		if a.CookiesFile == "" && (a.Email == "" || a.Password == "") {
+			return nil, fmt.Errorf("account %q needs email and password, or cookies_file", a.Name)
+		}
+	}
+	return accounts, nil
+}
+
+// Cookies returns fresh session cookies for the account, importing its
+// cookies file if set and logging in via browser otherwise.
+func (a Account) Cookies(ctx context.Context, headless bool, timeout time.Duration) ([]*http.Cookie, error) {
+	if a.CookiesFile != "" {
+		return LoadCookiesFile(a.CookiesFile)
+	}
+	return Login(ctx, a.Email, a.Password, headless, timeout)
+}
+
+// Refresher returns a function that gets fresh cookies for the named account,
+// for refreshing pooled sessions in the background.
+func Refresher(accounts []Account, headless bool, timeout time.Duration) func(ctx context.Context, name string) ([]*http.Cookie, error) {
+	byName := make(map[string]Account, len(accounts))
+	for _, a := range accounts {
+		byName[a.Name] = a
+	}
+	return func(ctx context.Context, name string) ([]*http.Cookie, error) {
+		a, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("no account for session %q", name)
+		}
+		return a.Cookies(ctx, headless, timeout)
+	}
+}
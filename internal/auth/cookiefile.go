@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// netscapeHTTPOnlyPrefix marks HttpOnly cookies in cookies.txt files written
+// by curl and most browser extensions.
+const netscapeHTTPOnlyPrefix = "#HttpOnly_"
+
+// jsonCookie covers the fields used by browser extension exports
+// (expirationDate) as well as Playwright and Puppeteer (expires).
+type jsonCookie struct {
+	Name           string   `json:"name"`
+	Value          string   `json:"value"`
+	Domain         string   `json:"domain"`
+	Path           string   `json:"path"`
+	Secure         bool     `json:"secure"`
+	HTTPOnly       bool     `json:"httpOnly"`
+	ExpirationDate *float64 `json:"expirationDate"`
+	Expires        *float64 `json:"expires"`
+}
+
+// LoadCookiesFile reads session cookies from a Netscape cookies.txt file or a
+// JSON cookie export, keeping only michelin.com cookies as Login does.
+func LoadCookiesFile(path string) ([]*http.Cookie, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookies file: %w", err)
+	}
+
+	var cookies []*http.Cookie
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		cookies, err = parseJSONCookies(trimmed)
+	} else {
+		cookies, err = parseNetscapeCookies(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := filterCookies(cookies)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no michelin.com cookies in %s", path)
+	}
+	return out, nil
+}
+
+// parseNetscapeCookies parses the tab separated cookies.txt format:
+// domain, include subdomains, path, secure, expiry, name, value
+func parseNetscapeCookies(data []byte) ([]*http.Cookie, error) {
+	var out []*http.Cookie
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, netscapeHTTPOnlyPrefix)
+		line = strings.TrimPrefix(line, netscapeHTTPOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 tab separated fields, got %d", n, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies.txt line %d: invalid expiry %q", n, fields[4])
+		}
+
+		c := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0).UTC()
+		}
+		out = append(out, c)
+	}
+	return out, scanner.Err()
+}
+
+// parseJSONCookies parses a JSON array of cookies, or an object holding one
+// under "cookies" as in Playwright's storage state.
+func parseJSONCookies(data []byte) ([]*http.Cookie, error) {
+	var raw []jsonCookie
+	if data[0] == '{' {
+		var state struct {
+			Cookies []jsonCookie `json:"cookies"`
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to parse cookies JSON: %w", err)
+		}
+		raw = state.Cookies
+	} else if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse cookies JSON: %w", err)
+	}
+
+	out := make([]*http.Cookie, 0, len(raw))
+	for _, jc := range raw {
+		if jc.Name == "" {
+			return nil, errors.New("cookie without a name in cookies JSON")
+		}
+		c := &http.Cookie{
+			Name:     jc.Name,
+			Value:    jc.Value,
+			Domain:   jc.Domain,
+			Path:     jc.Path,
+			Secure:   jc.Secure,
+			HttpOnly: jc.HTTPOnly,
+		}
+		expires := jc.ExpirationDate
+		if expires == nil {
+			expires = jc.Expires
+		}
+		// Session cookies have no expiry, or -1 in Playwright and Puppeteer.
+		if expires != nil && *expires > 0 {
+			sec, frac := math.Modf(*expires)
+			c.Expires = time.Unix(int64(sec), int64(frac*1e9)).UTC().Truncate(time.Second)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
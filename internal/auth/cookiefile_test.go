@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCookiesFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write cookies file: %v", err)
+	}
+	return path
+}
+
+func TestLoadCookiesFile(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "netscape",
+			file: "cookies.txt",
+			content: "# Netscape HTTP Cookie File\n" +
+				"\n" +
+				"#HttpOnly_.guide.michelin.com\tTRUE\t/\tTRUE\t1893553445\tsession_id\t\"abc\"\n" +
+				"guide.michelin.com\tFALSE\t/\tFALSE\t0\tlocale\ten\n" +
+				".example.com\tTRUE\t/\tFALSE\t0\ttracker\tx\n",
+		},
+		{
+			name: "browser extension export",
+			file: "cookies.json",
+			content: `[
+				{"domain": ".guide.michelin.com", "name": "session_id", "value": "\"abc\"", "path": "/", "secure": true, "httpOnly": true, "expirationDate": 1893553445.25},
+				{"domain": "guide.michelin.com", "name": "locale", "value": "en", "path": "/", "session": true},
+				{"domain": ".example.com", "name": "tracker", "value": "x", "path": "/"}
+			]`,
+		},
+		{
+			name: "playwright storage state",
+			file: "state.json",
+			content: `{"cookies": [
+				{"domain": ".guide.michelin.com", "name": "session_id", "value": "abc", "path": "/", "secure": true, "httpOnly": true, "expires": 1893553445},
+				{"domain": "guide.michelin.com", "name": "locale", "value": "en", "path": "/", "expires": -1},
+				{"domain": ".example.com", "name": "tracker", "value": "x", "path": "/", "expires": -1}
+			], "origins": []}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := LoadCookiesFile(writeCookiesFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("LoadCookiesFile: %v", err)
+			}
+			if len(cookies) != 2 {
+				t.Fatalf("expected 2 michelin.com cookies, got %+v", cookies)
+			}
+
+			session, locale := cookies[0], cookies[1]
+			if session.Name != "session_id" || session.Value != "abc" || !session.Secure || !session.HttpOnly {
+				t.Errorf("unexpected session cookie: %+v", session)
+			}
+			if !session.Expires.Equal(expires) {
+				t.Errorf("expected expiry %v, got %v", expires, session.Expires)
+			}
+			if locale.Name != "locale" || !locale.Expires.IsZero() || locale.HttpOnly {
+				t.Errorf("unexpected session-only cookie: %+v", locale)
+			}
+		})
+	}
+}
+
+func TestLoadCookiesFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"no michelin cookies", ".example.com\tTRUE\t/\tFALSE\t0\ttracker\tx\n"},
+		{"malformed line", "guide.michelin.com\tFALSE\t/\n"},
+		{"invalid expiry", "guide.michelin.com\tFALSE\t/\tFALSE\tsoon\tlocale\ten\n"},
+		{"invalid json", `[{"name": "locale",}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadCookiesFile(writeCookiesFile(t, "cookies", tt.content)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
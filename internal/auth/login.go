@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,16 +16,52 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultTimeout bounds the browser login flow.
+const DefaultTimeout = 60 * time.Second
+
 const (
 	michelinURL    = "https://guide.michelin.com/sg/en"
 	michelinDomain = "michelin.com"
 
-	xPathProfileIcon = "//img[contains(@class,'js-img-profile-menu')]"
-	xPathLoginLink   = "//a[contains(text(), 'Sign In')]"
-	xPathEmailInput  = "//input[@id='emailId']"
-	xPathContinueBtn = "//button[contains(text(), 'Continue')]"
-	xPathPassword    = "//input[@name='password' and @type='password']"
-	xPathSignInBtn   = "//button[contains(text(), 'Sign In')]"
+	// defaultDebugDir receives a screenshot and HTML dump of the page when a
+	// login step fails; MYM_LOGIN_DEBUG_DIR overrides it.
+	defaultDebugDir = "data/login-debug"
+	debugTimeout    = 10 * time.Second
+)
+
+// Selectors for each login step, tried in order: the first one present on
+// the page wins, so the current markup goes first and older or more generic
+// variants follow.
+var (
+	xPathProfileIcon = []string{
+		"//img[contains(@class,'js-img-profile-menu')]",
+		"//*[contains(@class,'profile-menu')]//img",
+		"//button[contains(@aria-label,'Account') or contains(@aria-label,'Profile')]",
+	}
+	xPathLoginLink = []string{
+		"//a[contains(text(), 'Sign In')]",
+		"//a[contains(text(), 'Log In') or contains(text(), 'Login')]",
+		"//a[contains(@href,'login') or contains(@href,'signin')]",
+	}
+	xPathEmailInput = []string{
+		"//input[@id='emailId']",
+		"//input[@type='email']",
+		"//input[@name='email' or @name='username']",
+	}
+	xPathContinueBtn = []string{
+		"//button[contains(text(), 'Continue')]",
+		"//button[contains(text(), 'Next')]",
+		"//button[@type='submit']",
+	}
+	xPathPassword = []string{
+		"//input[@name='password' and @type='password']",
+		"//input[@type='password']",
+	}
+	xPathSignInBtn = []string{
+		"//button[contains(text(), 'Sign In')]",
+		"//button[contains(text(), 'Log In') or contains(text(), 'Login')]",
+		"//button[@type='submit']",
+	}
 )
 
 // Login logs in via browser and returns the resulting michelin.com session cookies
@@ -44,7 +81,11 @@ func Login(ctx context.Context, email, password string, headless bool, timeout t
 		return nil, fmt.Errorf("failed to open page: %w", err)
 	}
 
-	if err := performLogin(page.Timeout(timeout), email, password); err != nil {
+	debugDir := os.Getenv("MYM_LOGIN_DEBUG_DIR")
+	if debugDir == "" {
+		debugDir = defaultDebugDir
+	}
+	if err := performLogin(page.Timeout(timeout), email, password, debugDir); err != nil {
 		log.WithError(err).Error("login flow failed")
 		return nil, err
 	}
@@ -89,8 +130,9 @@ func launchBrowser(headless bool) (*rod.Browser, func(), error) {
 	}, nil
 }
 
-// performLogin drives the multi-step login flow
-func performLogin(page *rod.Page, email, password string) error {
+// performLogin drives the multi-step login flow. When a step fails, a
+// screenshot and the page HTML are saved to debugDir.
+func performLogin(page *rod.Page, email, password, debugDir string) error {
 	steps := []struct {
 		name string
 		fn   func() error
@@ -121,6 +163,13 @@ func performLogin(page *rod.Page, email, password string) error {
 	for _, step := range steps {
 		log.WithField("step", step.name).Debug("executing login step")
 		if err := step.fn(); err != nil {
+			if debugDir != "" {
+				if files, derr := saveDebugArtifacts(page, debugDir, step.name); derr != nil {
+					log.WithError(derr).Warn("failed to save login debug artifacts")
+				} else {
+					log.WithField("files", files).Info("saved login debug artifacts")
+				}
+			}
 			return fmt.Errorf("login step %q failed: %w", step.name, err)
 		}
 		log.WithField("step", step.name).Debug("login step completed")
@@ -128,36 +177,85 @@ func performLogin(page *rod.Page, email, password string) error {
 	return nil
 }
 
+// findElement returns the first element matching one of xpaths, preferring
+// earlier ones, waiting until one appears or the page times out.
+func findElement(page *rod.Page, xpaths []string) (*rod.Element, error) {
+	race := page.Race()
+	for _, xpath := range xpaths {
+		race = race.ElementX(xpath).Handle(func(*rod.Element) error {
+			log.WithField("xpath", xpath).Debug("matched selector")
+			return nil
+		})
+	}
+	el, err := race.Do()
+	if err != nil {
+		return nil, fmt.Errorf("none of %d selectors matched (%s): %w", len(xpaths), strings.Join(xpaths, " | "), err)
+	}
+	return el, nil
+}
+
 // clickElement finds an element by XPath, waits for it to be visible, then clicks it.
-func clickElement(page *rod.Page, xpath string) error {
-	el, err := page.ElementX(xpath)
+func clickElement(page *rod.Page, xpaths []string) error {
+	el, err := findElement(page, xpaths)
 	if err != nil {
-		return fmt.Errorf("element not found (%s): %w", xpath, err)
+		return fmt.Errorf("element not found: %w", err)
 	}
 	if err := el.WaitVisible(); err != nil {
-		return fmt.Errorf("element not visible (%s): %w", xpath, err)
+		return fmt.Errorf("element not visible: %w", err)
 	}
 	if err := el.Click(proto.InputMouseButtonLeft, 1); err != nil {
-		return fmt.Errorf("click failed (%s): %w", xpath, err)
+		return fmt.Errorf("click failed: %w", err)
 	}
 	return nil
 }
 
 // fillInput finds an input by XPath, waits for it to be visible, then types the value.
-func fillInput(page *rod.Page, xpath, value string) error {
-	el, err := page.ElementX(xpath)
+func fillInput(page *rod.Page, xpaths []string, value string) error {
+	el, err := findElement(page, xpaths)
 	if err != nil {
-		return fmt.Errorf("input not found (%s): %w", xpath, err)
+		return fmt.Errorf("input not found: %w", err)
 	}
 	if err := el.WaitVisible(); err != nil {
-		return fmt.Errorf("input not visible (%s): %w", xpath, err)
+		return fmt.Errorf("input not visible: %w", err)
 	}
 	if err := el.Input(value); err != nil {
-		return fmt.Errorf("input failed (%s): %w", xpath, err)
+		return fmt.Errorf("input failed: %w", err)
 	}
 	return nil
 }
 
+// saveDebugArtifacts writes a full page screenshot and the page HTML to dir,
+// named after the time and the failed step. It runs on a fresh timeout since
+// the login timeout may be what failed the step.
+func saveDebugArtifacts(page *rod.Page, dir, step string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	page = page.Context(context.Background()).Timeout(debugTimeout)
+	defer page.CancelTimeout()
+
+	base := filepath.Join(dir, time.Now().UTC().Format("20060102T150405")+"-"+strings.ReplaceAll(step, " ", "-"))
+	var files []string
+
+	html, err := page.HTML()
+	if err != nil {
+		return files, fmt.Errorf("failed to read page HTML: %w", err)
+	}
+	if err := os.WriteFile(base+".html", []byte(html), 0o644); err != nil {
+		return files, err
+	}
+	files = append(files, base+".html")
+
+	png, err := page.Screenshot(true, nil)
+	if err != nil {
+		return files, fmt.Errorf("failed to take screenshot: %w", err)
+	}
+	if err := os.WriteFile(base+".png", png, 0o644); err != nil {
+		return files, err
+	}
+	return append(files, base+".png"), nil
+}
+
 // extractCookies fetches cookies from the page and filters to michelin.com
 func extractCookies(page *rod.Page) ([]*http.Cookie, error) {
 	info, err := page.Info()
@@ -175,16 +273,11 @@ func extractCookies(page *rod.Page) ([]*http.Cookie, error) {
 		return nil, fmt.Errorf("failed to retrieve cookies: %w", err)
 	}
 
-	out := make([]*http.Cookie, 0, len(rawCookies))
+	all := make([]*http.Cookie, 0, len(rawCookies))
 	for _, c := range rawCookies {
-		normalizedDomain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c.Domain)), ".")
-		if normalizedDomain != michelinDomain && !strings.HasSuffix(normalizedDomain, "."+michelinDomain) {
-			continue
-		}
-
 		hc := &http.Cookie{
 			Name:     c.Name,
-			Value:    strings.ReplaceAll(c.Value, `"`, ""), // strip invalid quote chars
+			Value:    c.Value,
 			Domain:   c.Domain,
 			Path:     c.Path,
 			Secure:   c.Secure,
@@ -193,11 +286,27 @@ func extractCookies(page *rod.Page) ([]*http.Cookie, error) {
 		if c.Expires != 0 {
 			hc.Expires = time.Unix(int64(c.Expires), 0).UTC()
 		}
-		out = append(out, hc)
+		all = append(all, hc)
 	}
 
+	out := filterCookies(all)
 	if len(out) == 0 {
 		return nil, errors.New("no michelin.com cookies after login: credentials may be wrong or site structure changed")
 	}
 	return out, nil
 }
+
+// filterCookies keeps the cookies set for michelin.com or one of its
+// subdomains, stripping quote characters that net/http rejects in values
+func filterCookies(cookies []*http.Cookie) []*http.Cookie {
+	out := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		normalizedDomain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c.Domain)), ".")
+		if normalizedDomain != michelinDomain && !strings.HasSuffix(normalizedDomain, "."+michelinDomain) {
+			continue
+		}
+		c.Value = strings.ReplaceAll(c.Value, `"`, "") // strip invalid quote chars
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// fixtureServer serves the login page fixture and accepts one account.
+func fixtureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(filepath.Join("testdata", "login"))))
+	mux.HandleFunc("POST /session", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("email") != "user@example.com" || r.FormValue("password") != "hunter2" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fixtureBrowser launches a headless browser, skipping the test when none is
+// installed.
+func fixtureBrowser(t *testing.T) *rod.Browser {
+	t.Helper()
+	if os.Getenv("MYM_BROWSER_BIN") == "" {
+		bin, ok := launcher.LookPath()
+		if !ok {
+			t.Skip("no browser installed; set MYM_BROWSER_BIN to run")
+		}
+		t.Setenv("MYM_BROWSER_BIN", bin)
+	}
+	if os.Geteuid() == 0 {
+		t.Setenv("MYM_NO_SANDBOX", "1")
+	}
+	browser, cleanup, err := launchBrowser(true)
+	if err != nil {
+		t.Fatalf("launchBrowser: %v", err)
+	}
+	t.Cleanup(cleanup)
+	return browser
+}
+
+func TestPerformLoginAgainstFixture(t *testing.T) {
+	srv := fixtureServer(t)
+	browser := fixtureBrowser(t)
+
+	t.Run("logs in with fallback selectors", func(t *testing.T) {
+		page, err := browser.Page(proto.TargetCreateTarget{URL: srv.URL + "/index.html"})
+		if err != nil {
+			t.Fatalf("open page: %v", err)
+		}
+		defer page.Close()
+
+		debugDir := t.TempDir()
+		if err := performLogin(page.Timeout(30*time.Second), "user@example.com", "hunter2", debugDir); err != nil {
+			t.Fatalf("performLogin: %v", err)
+		}
+
+		cookies, err := page.Cookies([]string{srv.URL})
+		if err != nil {
+			t.Fatalf("read cookies: %v", err)
+		}
+		found := false
+		for _, c := range cookies {
+			found = found || (c.Name == "session" && c.Value == "ok")
+		}
+		if !found {
+			t.Errorf("expected the session cookie after login, got %+v", cookies)
+		}
+		if entries, _ := os.ReadDir(debugDir); len(entries) != 0 {
+			t.Errorf("expected no debug artifacts after a successful login, got %d", len(entries))
+		}
+	})
+
+	t.Run("saves a screenshot and HTML dump when a step fails", func(t *testing.T) {
+		// The sign in page has no profile icon, so the first step fails.
+		page, err := browser.Page(proto.TargetCreateTarget{URL: srv.URL + "/login.html"})
+		if err != nil {
+			t.Fatalf("open page: %v", err)
+		}
+		defer page.Close()
+
+		debugDir := t.TempDir()
+		err = performLogin(page.Timeout(2*time.Second), "user@example.com", "hunter2", debugDir)
+		if err == nil || !strings.Contains(err.Error(), "click profile icon") {
+			t.Fatalf("expected the profile icon step to fail, got %v", err)
+		}
+
+		html, _ := filepath.Glob(filepath.Join(debugDir, "*-click-profile-icon.html"))
+		png, _ := filepath.Glob(filepath.Join(debugDir, "*-click-profile-icon.png"))
+		if len(html) != 1 || len(png) != 1 {
+			t.Fatalf("expected one HTML dump and one screenshot, got %v %v", html, png)
+		}
+		if data, _ := os.ReadFile(html[0]); !strings.Contains(string(data), "js-continue") {
+			t.Errorf("expected the HTML dump of the sign in page")
+		}
+	})
+}
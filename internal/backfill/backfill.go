@@ -2,30 +2,46 @@ package backfill
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"os"
+	"slices"
 	"sync/atomic"
 	"time"
 
 	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/archive"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/client"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/handlers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/storage"
-	"github.com/ngshiheng/michelin-my-maps/v4/internal/utils"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/warc"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
 	log "github.com/sirupsen/logrus"
 )
 
 const xPathDetailRoot = "html"
 
-// defaultConfig returns a default config for Wayback backfill
+// cdxCacheMaxAge bounds how long a CDX API response is served from cache.
+// Snapshots are immutable, but the CDX listing grows as new captures are made.
+const cdxCacheMaxAge = 24 * time.Hour
+
+// defaultConfig returns a default config for Wayback backfill. Hosts of other
+// archives are added to AllowedDomains when they are used as sources.
 func defaultConfig() *client.Config {
 	return &client.Config{
 		AllowedDomains: []string{"web.archive.org"},
+		ArchivePath:    client.DefaultArchivePath,
+		CacheBackend:   os.Getenv("MYM_CACHE_BACKEND"),
 		CachePath:      client.DefaultCacheWayback,
+		CacheTTL:       client.DefaultCacheWaybackTTL,
 		DatabasePath:   client.DefaultDataPath,
+		Proxies:        os.Getenv("MYM_PROXIES"),
+		ProxyMode:      os.Getenv("MYM_PROXY_MODE"),
 		StoragePath:    client.DefaultStoragePath,
+		WARCPath:       os.Getenv("MYM_WARC_DIR"),
+		WARCMaxSize:    warc.DefaultMaxSize,
 		// Wayback CDX guidance is < 60 requests/minute. 1.0-1.5s pacing
 		// yields ~40-60 req/minute with jitter while remaining conservative.
 		Delay:          1 * time.Second,
@@ -36,72 +52,148 @@ func defaultConfig() *client.Config {
 	}
 }
 
+// clientConfig returns the config of the backfill's client.
+func clientConfig(cfg *client.Config, ignoreCache bool) *client.Config {
+	clientCfg := &client.Config{
+		AllowedDomains: cfg.AllowedDomains,
+		CacheBackend:   cfg.CacheBackend,
+		CachePath:      cfg.CachePath,
+		CacheTTL:       cfg.CacheTTL,
+		StoragePath:    cfg.StoragePath,
+		Delay:          cfg.Delay,
+		RequestTimeout: cfg.RequestTimeout,
+		MaxRetry:       cfg.MaxRetry,
+		Proxies:        cfg.Proxies,
+		ProxyMode:      cfg.ProxyMode,
+		RandomDelay:    cfg.RandomDelay,
+		Run:            client.RunBackfill,
+		ThreadCount:    cfg.ThreadCount,
+	}
+	if ignoreCache {
+		log.Debug("running with no cache")
+		clientCfg.CachePath = ""
+	}
+	return clientCfg
+}
+
 // Scraper orchestrates the Wayback backfill process
 type Scraper struct {
+	archive    *archive.Store
 	client     *client.Colly
 	config     *client.Config
+	options    Options
 	repository storage.RestaurantRepository
+	sources    []Source
 	scraped    atomic.Int64
+	warc       *warc.Writer // nil unless WARC output is enabled
 }
 
 // New creates a new Scraper with default config and repository
-func New(ignoreCache bool) (*Scraper, error) {
+func New(ignoreCache bool, opts Options) (*Scraper, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
 	cfg := defaultConfig()
 
-	repo, err := storage.NewSQLiteRepository(cfg.DatabasePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create repository: %w", err)
+	names := opts.Sources
+	if len(names) == 0 {
+		names = []string{webarchive.Wayback}
+	}
+	var sources []Source
+	for _, name := range names {
+		src, err := newSource(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+		if host := src.Archive().Host(); !slices.Contains(cfg.AllowedDomains, host) {
+			cfg.AllowedDomains = append(cfg.AllowedDomains, host)
+		}
 	}
 
-	clientCfg := &client.Config{
-		AllowedDomains: cfg.AllowedDomains,
-		CachePath:      cfg.CachePath,
-		StoragePath:    cfg.StoragePath,
-		Delay:          cfg.Delay,
-		RequestTimeout: cfg.RequestTimeout,
-		MaxRetry:       cfg.MaxRetry,
-		RandomDelay:    cfg.RandomDelay,
-		ThreadCount:    cfg.ThreadCount,
+	policy, err := storage.MergePolicyFromEnv()
+	if err != nil {
+		return nil, err
 	}
-	if ignoreCache {
-		log.Debug("running with no cache")
-		clientCfg.CachePath = ""
+	repo, err := storage.NewSQLiteRepository(cfg.DatabasePath, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
 	}
 
-	cl, err := client.New(clientCfg)
+	cl, err := client.New(clientConfig(cfg, ignoreCache))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
+	store, err := archive.Open(cfg.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
 	s := &Scraper{
+		archive:    store,
 		client:     cl,
 		config:     cfg,
+		options:    opts,
 		repository: repo,
+		sources:    sources,
+	}
+
+	if cfg.WARCPath != "" {
+		s.warc, err = warc.NewWriter(cfg.WARCPath, "backfill", cfg.WARCMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create WARC writer: %w", err)
+		}
 	}
 	return s, nil
 }
 
+// Close releases the client, repository and archive opened by New and closes
+// the current WARC file, if any.
+func (s *Scraper) Close() error {
+	var err error
+	if s.warc != nil {
+		err = s.warc.Close()
+	}
+	return errors.Join(err, s.archive.Close(), s.repository.Close(), s.client.Close())
+}
+
 // RunAll runs the backfill workflow for all restaurants
 func (s *Scraper) RunAll(ctx context.Context) error {
 	restaurants, err := s.repository.ListRestaurants(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to list restaurants: %w", err)
 	}
+	selected := s.options.selectRestaurants(restaurants)
 
 	log.WithFields(log.Fields{
-		"count": len(restaurants),
+		"count":    len(selected),
+		"listed":   len(restaurants),
+		"from":     s.options.From,
+		"to":       s.options.To,
+		"years":    s.options.Years,
+		"location": s.options.Location,
 	}).Info("running backfill for restaurants")
 
 	collector := s.client.GetCollector()
 	detailCollector := s.client.GetDetailCollector()
 
-	s.setupHandlers(collector, detailCollector)
+	s.setupHandlers(ctx, collector, detailCollector)
 	s.setupDetailHandlers(ctx, detailCollector)
 
-	for _, r := range restaurants {
-		api := "https://web.archive.org/cdx/search/cdx?url=" + r.URL + "&output=json&fl=timestamp,original"
-		if err := s.client.EnqueueURL(api); err != nil {
-			return err
+	for _, r := range selected {
+		// Older URL forms of a restaurant have captures of their own.
+		urls := []string{r.URL}
+		for _, alias := range r.Aliases {
+			urls = append(urls, alias.URL)
+		}
+		for _, u := range urls {
+			for _, src := range s.sources {
+				if err := s.client.EnqueueURL(s.query(ctx, src, u)); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
@@ -111,6 +203,7 @@ func (s *Scraper) RunAll(ctx context.Context) error {
 
 	// TODO: add summary of results
 	log.WithField("scraped", s.scraped.Load()).Info("completed backfill")
+	s.client.LogProxyUsage()
 	return nil
 }
 
@@ -121,24 +214,29 @@ func (s *Scraper) Run(ctx context.Context, url string) error {
 	collector := s.client.GetCollector()
 	detailCollector := s.client.GetDetailCollector()
 
-	s.setupHandlers(collector, detailCollector)
+	s.setupHandlers(ctx, collector, detailCollector)
 	s.setupDetailHandlers(ctx, detailCollector)
 
-	api := "https://web.archive.org/cdx/search/cdx?url=" + url + "&output=json&fl=timestamp,original"
-	if err := collector.Visit(api); err != nil {
-		log.WithError(err).WithField("url", url).Error("failed to visit restaurant URL")
-		return err
+	for _, src := range s.sources {
+		if err := collector.Visit(s.query(ctx, src, url)); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"archive": src.Archive().Name,
+				"url":     url,
+			}).Error("failed to visit restaurant URL")
+			return err
+		}
 	}
 
 	log.WithField("url", url).Debug("completed backfill for one restaurant")
 	return nil
 }
 
-func (s *Scraper) setupHandlers(collector *colly.Collector, detailCollector *colly.Collector) {
+func (s *Scraper) setupHandlers(ctx context.Context, collector *colly.Collector, detailCollector *colly.Collector) {
 	collector.OnError(s.createErrorHandler())
 
 	collector.OnRequest(func(r *colly.Request) {
 		r.Headers.Set("Accept-Language", "en-SG,en;q=0.9")
+		r.Headers.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cdxCacheMaxAge.Seconds())))
 
 		attempt := r.Ctx.GetAny("attempt")
 		if attempt == nil {
@@ -153,69 +251,95 @@ func (s *Scraper) setupHandlers(collector *colly.Collector, detailCollector *col
 			"attempt":   attempt,
 			"cache_hit": cacheHit,
 			"url":       r.URL,
-		}).Debug("requesting cdx api")
+		}).Debug("requesting archive captures")
 	})
 
 	collector.OnResponse(func(r *colly.Response) {
-		url := r.Request.URL.Query().Get("url")
-
-		var rows [][]string
-		if err := json.Unmarshal(r.Body, &rows); err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"url":         url,
-				"status_code": r.StatusCode,
-				"cdx_api":     r.Request.URL,
-			}).Warn("failed to parse cdx api response")
+		src, url, ok := s.sourceFor(r.Request.URL)
+		if !ok {
+			log.WithField("query", r.Request.URL).Warn("response to an unknown archive query")
 			return
 		}
+		fields := log.Fields{
+			"archive":     src.Archive().Name,
+			"query":       r.Request.URL,
+			"status_code": r.StatusCode,
+			"url":         url,
+		}
 
-		if len(rows) <= 1 {
-			log.WithFields(log.Fields{
-				"url":         url,
-				"rows":        rows,
-				"status_code": r.StatusCode,
-				"cdx_api":     r.Request.URL,
-			}).Debug("no snapshots found")
-			// FIXME: this is currently cached because CDX api returns 200
+		captures, err := src.Parse(r.Body)
+		if err != nil {
+			log.WithError(err).WithFields(fields).Warn("failed to parse archive query response")
 			return
 		}
 
-		minTimestampLen := 14
-		snapshot := 0
-		for i, row := range rows {
-			if i == 0 || len(row) == 0 {
-				continue // skip header or malformed
+		if len(captures) == 0 {
+			log.WithFields(fields).Debug("no snapshots found")
+			// Archives return 200 with an empty result, so drop it from cache
+			// to look again on the next run.
+			if err := s.client.ClearCache(r.Request); err != nil {
+				log.WithError(err).WithFields(fields).Warn("failed to clear cache")
 			}
-			ts := row[0]
+			s.saveState(ctx, src, url, "", models.BackfillNoSnapshots)
+			return
+		}
 
-			// The CDX API may return malformed or incomplete rows.
-			// A valid timestamp must be at least 14 characters (yyyyMMddhhmmss), e.g. "20220101123456".
-			// Example of a malformed row: [] or [""] or ["2022"].
-			if len(ts) < minTimestampLen {
-				continue
+		from, to := s.options.cdxRange()
+		checkpoint := s.checkpoint(ctx, src, url)
+		snapshots := afterCheckpoint(inRange(captures, from, to), checkpoint)
+		unique := dedupeSnapshots(s.options.filterYears(snapshots), s.options.LatestPerYear)
+
+		var failed []string
+		snapshot := 0
+		for _, snap := range unique {
+			snapshotURL := snap.URI
+			if snapshotURL == "" {
+				snapshotURL = src.Archive().RawURL(snap.Timestamp, url)
 			}
-			snapshotURL := fmt.Sprintf("https://web.archive.org/web/%sid_/%s", ts, url)
-			err := detailCollector.Visit(snapshotURL)
+			done, err := visitSnapshot(detailCollector, snapshotURL)
 			if err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"url":         url,
-					"wayback_url": snapshotURL,
-				}).Debug("failed to visit snapshot URL")
+				log.WithError(err).WithFields(fields).WithField("wayback_url", snapshotURL).Debug("failed to visit snapshot URL")
+			}
+			if !done {
+				failed = append(failed, snap.Timestamp)
 				continue
 			}
 			snapshot++
 		}
 
-		log.WithFields(log.Fields{
-			"cache_hit":   r.Ctx.GetAny("cache_hit"),
-			"cdx_api":     r.Request.URL,
-			"snapshot":    snapshot,
-			"status_code": r.StatusCode,
-			"url":         url,
-		}).Debug("processing cdx api")
+		outcome := models.BackfillComplete
+		switch {
+		case len(failed) > 0:
+			outcome = models.BackfillIncomplete
+		case len(unique) == 0:
+			outcome = models.BackfillNoSnapshots
+		}
+		s.saveState(ctx, src, url, advanceCheckpoint(checkpoint, snapshots, failed), outcome)
+
+		log.WithFields(fields).WithFields(log.Fields{
+			"cache_hit":  r.Ctx.GetAny("cache_hit"),
+			"captures":   len(captures),
+			"checkpoint": checkpoint,
+			"failed":     len(failed),
+			"snapshot":   snapshot,
+		}).Debug("processed archive captures")
 	})
 }
 
+// writeWARC records a snapshot in the WARC output, if enabled. Cache replays
+// are skipped so every record reflects an actual fetch.
+func (s *Scraper) writeWARC(r *colly.Response) {
+	if s.warc == nil {
+		return
+	}
+	if cacheHit, _ := r.Ctx.GetAny("cache_hit").(bool); cacheHit {
+		return
+	}
+	if err := s.warc.WriteResponse(r); err != nil {
+		log.WithError(err).WithField("url", r.Request.URL).Warn("failed to write WARC record")
+	}
+}
+
 func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *colly.Collector) {
 	detailCollector.OnError(s.createErrorHandler())
 
@@ -237,6 +361,22 @@ func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *coll
 		}).Debug("requesting wayback snapshot")
 	})
 
+	// Outcome flags read by visitSnapshot. Retries share the request context,
+	// so a later successful attempt sets "fetched".
+	detailCollector.OnError(func(r *colly.Response, err error) {
+		r.Ctx.Put("status_code", r.StatusCode)
+	})
+
+	detailCollector.OnResponse(func(r *colly.Response) {
+		r.Ctx.Put("fetched", true)
+		s.writeWARC(r)
+		if r.StatusCode == http.StatusOK {
+			if err := s.archive.Save(archive.PageFromResponse(r)); err != nil {
+				log.WithError(err).WithField("url", r.Request.URL).Warn("failed to archive wayback snapshot")
+			}
+		}
+	})
+
 	detailCollector.OnXML(xPathDetailRoot, func(e *colly.XMLElement) {
 		err := handlers.Handle(ctx, e, s.repository)
 		if err != nil {
@@ -249,52 +389,8 @@ func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *coll
 
 // createErrorHandler creates a reusable error handler for collectors with retry logic.
 func (s *Scraper) createErrorHandler() func(*colly.Response, error) {
+	policy := client.BackfillRetryPolicy(s.config.MaxRetry)
 	return func(r *colly.Response, err error) {
-		attempt := 1
-		if v := r.Ctx.GetAny("attempt"); v != nil {
-			if a, ok := v.(int); ok {
-				attempt = a
-			}
-		}
-
-		cookies := s.client.GetCookies(r.Request.URL.String())
-		fields := log.Fields{
-			"attempt":      attempt,
-			"cookie_count": len(cookies),
-			"status_code":  r.StatusCode,
-			"url":          r.Request.URL,
-		}
-
-		if strings.Contains(err.Error(), "already visited") {
-			log.WithError(err).WithFields(fields).Debug("already visited, skip retry")
-			return
-		}
-
-		// We don't retry 403 Forbidden errors, as they indicate restricted access and retries won't help.
-		// In the Wayback Machine, a 403 typically means the site owner has blocked archiving.
-		switch r.StatusCode {
-		case http.StatusForbidden:
-			log.WithError(err).WithFields(fields).Debug("request forbidden, skip retry")
-			return
-		case http.StatusNotFound:
-			log.WithError(err).WithFields(fields).Debug("request not found, skip retry")
-			return
-		}
-
-		shouldRetry := attempt < s.config.MaxRetry
-		if shouldRetry {
-			if err := s.client.ClearCache(r.Request); err != nil {
-				log.WithError(err).WithFields(fields).WithField("request_headers", utils.FlattenHeaders(r.Request.Headers)).Error("failed to clear cache")
-			}
-
-			backoff := time.Duration(attempt) * s.config.Delay
-			log.WithFields(fields).WithField("backoff", backoff).Debug("failed request, retrying")
-			time.Sleep(backoff)
-
-			r.Ctx.Put("attempt", attempt+1)
-			r.Request.Retry()
-		} else {
-			log.WithError(err).WithFields(fields).WithField("request_headers", utils.FlattenHeaders(r.Request.Headers)).Error("failed request, max retries reached")
-		}
+		s.client.HandleError(policy, r, err)
 	}
 }
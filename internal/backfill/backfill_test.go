@@ -0,0 +1,29 @@
+package backfill
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/client"
+)
+
+func TestClientConfigRoutesThroughConfiguredProxies(t *testing.T) {
+	t.Setenv("MYM_PROXIES", "http://proxy.test:3128, socks5://proxy.test:1080")
+	t.Setenv("MYM_PROXY_MODE", client.ProxySticky)
+
+	cfg := defaultConfig()
+	cfg.StoragePath = filepath.Join(t.TempDir(), "colly.db")
+	clientCfg := clientConfig(cfg, true)
+	if clientCfg.ProxyMode != client.ProxySticky {
+		t.Errorf("expected proxy mode %q, got %q", client.ProxySticky, clientCfg.ProxyMode)
+	}
+
+	cl, err := client.New(clientCfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	if stats := cl.ProxyStats(); len(stats) != 2 || stats[1].URL != "socks5://proxy.test:1080" {
+		t.Errorf("expected the client to use both proxies, got %+v", stats)
+	}
+}
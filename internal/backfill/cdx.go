@@ -0,0 +1,119 @@
+package backfill
+
+import (
+	"net/url"
+	"sort"
+)
+
+// cdxFields are the CDX columns requested for every restaurant URL.
+const cdxFields = "timestamp,original,digest,statuscode"
+
+// snapshot is a single capture listed by the Wayback CDX API or a TimeMap.
+type snapshot struct {
+	Timestamp  string // yyyyMMddhhmmss
+	Original   string
+	Digest     string // sha1 of the captured payload
+	StatusCode string
+	URI        string // capture URL to fetch, when the archive lists one
+}
+
+// Year returns the capture year of the snapshot.
+func (s snapshot) Year() string {
+	return s.Timestamp[:4]
+}
+
+// cdxAPI returns the CDX API query listing the captures of restaurantURL,
+// optionally bounded by from and to timestamps.
+func cdxAPI(restaurantURL, from, to string) string {
+	query := url.Values{}
+	query.Set("url", restaurantURL)
+	query.Set("output", "json")
+	query.Set("fl", cdxFields)
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+	return "https://web.archive.org/cdx/search/cdx?" + query.Encode()
+}
+
+// parseCDX converts CDX JSON rows into snapshots. The first row names the
+// columns; rows that are malformed or lack a full timestamp are dropped.
+func parseCDX(rows [][]string) []snapshot {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	field := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	// The CDX API may return malformed or incomplete rows.
+	// A valid timestamp must be at least 14 characters (yyyyMMddhhmmss), e.g. "20220101123456".
+	// Example of a malformed row: [] or [""] or ["2022"].
+	minTimestampLen := 14
+
+	var snapshots []snapshot
+	for _, row := range rows[1:] {
+		s := snapshot{
+			Timestamp:  field(row, "timestamp"),
+			Original:   field(row, "original"),
+			Digest:     field(row, "digest"),
+			StatusCode: field(row, "statuscode"),
+		}
+		if len(s.Timestamp) < minTimestampLen || s.Original == "" {
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots
+}
+
+// dedupeSnapshots drops non-200 captures and captures whose payload digest
+// matches the capture before them, keeping the earliest capture of each
+// version of the page. A page that changes and later changes back is kept
+// every time, so the last capture of a year always holds its latest content.
+// With latestPerYear only the most recent distinct capture of each year is
+// kept. The result is in timestamp order.
+func dedupeSnapshots(snapshots []snapshot, latestPerYear bool) []snapshot {
+	sorted := make([]snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp < sorted[j].Timestamp
+	})
+
+	var previous string
+	var unique []snapshot
+	for _, s := range sorted {
+		if s.StatusCode != "200" {
+			continue
+		}
+		if s.Digest != "" && s.Digest == previous {
+			continue
+		}
+		previous = s.Digest
+		unique = append(unique, s)
+	}
+
+	if !latestPerYear {
+		return unique
+	}
+
+	var latest []snapshot
+	for i, s := range unique {
+		if i+1 < len(unique) && unique[i+1].Year() == s.Year() {
+			continue
+		}
+		latest = append(latest, s)
+	}
+	return latest
+}
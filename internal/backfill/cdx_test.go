@@ -0,0 +1,87 @@
+package backfill
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCDXAPIRequestsDigestAndStatus(t *testing.T) {
+	api, err := url.Parse(cdxAPI("https://guide.michelin.com/sg/en/a", "", ""))
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	q := api.Query()
+	if q.Get("url") != "https://guide.michelin.com/sg/en/a" || q.Get("fl") != cdxFields || q.Get("output") != "json" {
+		t.Errorf("unexpected cdx query %q", api.RawQuery)
+	}
+	if q.Has("from") || q.Has("to") {
+		t.Errorf("unbounded query should not set from/to: %q", api.RawQuery)
+	}
+
+	api, _ = url.Parse(cdxAPI("https://guide.michelin.com/sg/en/a", "2019", "20211231"))
+	if q := api.Query(); q.Get("from") != "2019" || q.Get("to") != "20211231" {
+		t.Errorf("unexpected cdx range %q", api.RawQuery)
+	}
+}
+
+func TestParseCDX(t *testing.T) {
+	rows := [][]string{
+		{"timestamp", "original", "digest", "statuscode"},
+		{"20220101000000", "https://guide.michelin.com/a", "AAA", "200"},
+		{},
+		{"2022"},
+		{"20230101000000", "https://guide.michelin.com/a"}, // missing columns
+	}
+	got := parseCDX(rows)
+	want := []snapshot{
+		{Timestamp: "20220101000000", Original: "https://guide.michelin.com/a", Digest: "AAA", StatusCode: "200"},
+		{Timestamp: "20230101000000", Original: "https://guide.michelin.com/a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCDX() = %+v; want %+v", got, want)
+	}
+	if parseCDX(nil) != nil {
+		t.Errorf("parseCDX(nil) should be empty")
+	}
+}
+
+func TestDedupeSnapshots(t *testing.T) {
+	snapshots := []snapshot{
+		{Timestamp: "20210601000000", Digest: "B", StatusCode: "200"},
+		{Timestamp: "20210101000000", Digest: "A", StatusCode: "200"},
+		{Timestamp: "20210301000000", Digest: "A", StatusCode: "200"}, // same content as January
+		{Timestamp: "20210901000000", Digest: "C", StatusCode: "302"},
+		{Timestamp: "20211101000000", Digest: "A", StatusCode: "200"}, // changed back to January
+		{Timestamp: "20220101000000", Digest: "D", StatusCode: "200"},
+		{Timestamp: "20220201000000", Digest: "E", StatusCode: "-"},
+		{Timestamp: "20230101000000", Digest: "A", StatusCode: "200"}, // reverted page
+	}
+
+	tests := []struct {
+		name          string
+		latestPerYear bool
+		want          []string
+	}{
+		{
+			name: "distinct 200 captures",
+			want: []string{"20210101000000", "20210601000000", "20211101000000", "20220101000000", "20230101000000"},
+		},
+		{
+			name:          "latest per year",
+			latestPerYear: true,
+			want:          []string{"20211101000000", "20220101000000", "20230101000000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range dedupeSnapshots(snapshots, tt.latestPerYear) {
+				got = append(got, s.Timestamp)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dedupeSnapshots() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
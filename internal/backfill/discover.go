@@ -0,0 +1,216 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultDiscoveryPrefix covers every edition of the guide.
+	DefaultDiscoveryPrefix = "guide.michelin.com/"
+
+	discoveryPageSize = 5000
+
+	// CDX filters are full-match regular expressions on the captured URL.
+	filterRestaurantPages = "original:.*/restaurant/.*"
+	filterListingPages    = "original:.*/restaurants(/page/[0-9]+)?/?"
+
+	xPathRestaurantCardLink = "//div[contains(@class, 'card__menu selection-card')]//a[@class='link']"
+)
+
+// Discover searches the Wayback Machine for restaurant detail pages under
+// prefix that are not in the database, either captured directly or linked
+// from archived listing pages, and backfills them like RunAll does. This is
+// how restaurants that closed before the first live scrape are found.
+func (s *Scraper) Discover(ctx context.Context, prefix string) error {
+	restaurants, err := s.repository.ListRestaurants(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list restaurants: %w", err)
+	}
+	known := make(map[string]bool, len(restaurants))
+	for _, r := range restaurants {
+		known[r.URL] = true
+		for _, alias := range r.Aliases {
+			known[alias.URL] = true
+		}
+	}
+
+	collector := s.client.GetCollector()
+	detailCollector := s.client.GetDetailCollector()
+	s.setupHandlers(ctx, collector, detailCollector)
+	s.setupDetailHandlers(ctx, detailCollector)
+
+	// Discovery queries are repeated on every run, so they must not be
+	// dropped as already visited.
+	discoveryCollector := s.client.GetDetailCollector()
+	discoveryCollector.AllowURLRevisit = true
+
+	var discovered []string
+	// Keyed by the URL forms archives index, so that a form of a known
+	// restaurant no alias covers yet is backfilled too.
+	add := func(rawURL, source string) {
+		u, ok := restaurantURL(rawURL)
+		if !ok || known[u] {
+			return
+		}
+		known[u] = true
+		discovered = append(discovered, u)
+		log.WithFields(log.Fields{
+			"source": source,
+			"url":    u,
+		}).Debug("discovered restaurant")
+	}
+	s.setupDiscoveryHandlers(discoveryCollector, add)
+
+	log.WithField("prefix", prefix).Info("discovering restaurants in the wayback machine")
+	for _, filter := range []string{filterRestaurantPages, filterListingPages} {
+		if err := discoveryCollector.Visit(discoveryAPI(prefix, filter, "")); err != nil {
+			return fmt.Errorf("failed to query cdx api: %w", err)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"discovered": len(discovered),
+		"known":      len(restaurants),
+	}).Info("running backfill for discovered restaurants")
+
+	for _, u := range discovered {
+		for _, src := range s.sources {
+			if err := s.client.EnqueueURL(s.query(ctx, src, u)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.client.RunQueue(collector); err != nil {
+		return err
+	}
+
+	log.WithField("scraped", s.scraped.Load()).Info("completed discovery backfill")
+	s.client.LogProxyUsage()
+	return nil
+}
+
+func (s *Scraper) setupDiscoveryHandlers(collector *colly.Collector, add func(rawURL, source string)) {
+	collector.OnError(s.createErrorHandler())
+
+	collector.OnRequest(func(r *colly.Request) {
+		r.Headers.Set("Accept-Language", "en-SG,en;q=0.9")
+		if isCDXRequest(r.URL) {
+			r.Headers.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(cdxCacheMaxAge.Seconds())))
+		}
+
+		if r.Ctx.GetAny("attempt") == nil {
+			r.Ctx.Put("attempt", 1)
+		}
+		log.WithField("url", r.URL).Debug("requesting discovery page")
+	})
+
+	collector.OnResponse(func(r *colly.Response) {
+		if !isCDXRequest(r.Request.URL) {
+			return // archived listing page, handled by OnXML
+		}
+
+		var rows [][]string
+		if err := json.Unmarshal(r.Body, &rows); err != nil {
+			log.WithError(err).WithField("cdx_api", r.Request.URL).Warn("failed to parse cdx api response")
+			return
+		}
+		rows, resumeKey := splitResumeKey(rows)
+
+		query := r.Request.URL.Query()
+		listings := slices.Contains(query["filter"], filterListingPages)
+		for _, snap := range parseCDX(rows) {
+			if listings {
+				listingURL := "https://web.archive.org/web/" + snap.Timestamp + "id_/" + snap.Original
+				if err := r.Request.Visit(listingURL); err != nil {
+					log.WithError(err).WithField("wayback_url", listingURL).Debug("failed to visit listing snapshot")
+				}
+				continue
+			}
+			add(snap.Original, "cdx")
+		}
+
+		if resumeKey != "" {
+			filter := filterRestaurantPages
+			if listings {
+				filter = filterListingPages
+			}
+			next := discoveryAPI(query.Get("url"), filter, resumeKey)
+			if err := r.Request.Visit(next); err != nil {
+				log.WithError(err).WithField("cdx_api", next).Warn("failed to visit next cdx page")
+			}
+		}
+	})
+
+	collector.OnXML(xPathRestaurantCardLink, func(e *colly.XMLElement) {
+		// Links in id_ snapshots are not rewritten, so resolve them against
+		// the original listing URL rather than the Wayback URL.
+		original, _ := parsers.ParseRequestURL(e.Request.URL.String())
+		base, err := url.Parse(original)
+		if err != nil {
+			return
+		}
+		href, err := url.Parse(e.Attr("href"))
+		if err != nil {
+			return
+		}
+		add(base.ResolveReference(href).String(), "listing")
+	})
+}
+
+// discoveryAPI returns a CDX prefix query for captures matching filter,
+// starting after resumeKey if set. Each distinct URL is listed once.
+func discoveryAPI(prefix, filter, resumeKey string) string {
+	query := url.Values{}
+	query.Set("url", prefix)
+	query.Set("matchType", "prefix")
+	query.Set("output", "json")
+	query.Set("fl", "timestamp,original")
+	query.Add("filter", "statuscode:200")
+	query.Add("filter", filter)
+	query.Set("collapse", "urlkey")
+	query.Set("limit", strconv.Itoa(discoveryPageSize))
+	query.Set("showResumeKey", "true")
+	if resumeKey != "" {
+		query.Set("resumeKey", resumeKey)
+	}
+	return "https://web.archive.org/cdx/search/cdx?" + query.Encode()
+}
+
+func isCDXRequest(u *url.URL) bool {
+	return u.Path == "/cdx/search/cdx"
+}
+
+// splitResumeKey separates the trailing resume key that showResumeKey=true
+// appends after an empty row when more results are available.
+func splitResumeKey(rows [][]string) ([][]string, string) {
+	n := len(rows)
+	if n >= 2 && len(rows[n-2]) == 0 && len(rows[n-1]) == 1 {
+		return rows[:n-2], rows[n-1][0]
+	}
+	return rows, ""
+}
+
+// restaurantURL returns the normalized form of a restaurant detail URL, under
+// which archives index its captures, or false if rawURL is not a restaurant
+// detail page.
+func restaurantURL(rawURL string) (string, bool) {
+	u := parsers.NormalizeURL(rawURL)
+	if !strings.HasPrefix(u, "https://guide.michelin.com/") {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(u, "https://guide.michelin.com/"), "/")
+	if len(parts) < 2 || parts[len(parts)-2] != "restaurant" || parts[len(parts)-1] == "" {
+		return "", false
+	}
+	return u, true
+}
@@ -0,0 +1,64 @@
+package backfill
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRestaurantURL(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{input: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette", want: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette", ok: true},
+		{input: "http://www.guide.michelin.com:80/fr/fr/ile-de-france/paris/restaurant/l-ambroisie/?lang=fr", want: "https://guide.michelin.com/fr/fr/ile-de-france/paris/restaurant/l-ambroisie", ok: true},
+		{input: "https://guide.michelin.com/sg/en/restaurants/page/2"},
+		{input: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette/reviews"},
+		{input: "https://example.com/restaurant/odette"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := restaurantURL(tt.input)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("restaurantURL() = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSplitResumeKey(t *testing.T) {
+	rows := [][]string{
+		{"timestamp", "original"},
+		{"20200101000000", "https://guide.michelin.com/a"},
+		{},
+		{"com,michelin,guide)/a 20200101000000"},
+	}
+	got, key := splitResumeKey(rows)
+	if key != "com,michelin,guide)/a 20200101000000" || len(got) != 2 {
+		t.Errorf("splitResumeKey() = %v, %q", got, key)
+	}
+
+	got, key = splitResumeKey(rows[:2])
+	if key != "" || !reflect.DeepEqual(got, rows[:2]) {
+		t.Errorf("splitResumeKey() without key = %v, %q", got, key)
+	}
+}
+
+func TestDiscoveryAPI(t *testing.T) {
+	api, err := url.Parse(discoveryAPI("guide.michelin.com/sg/", filterListingPages, "key"))
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	q := api.Query()
+	if q.Get("matchType") != "prefix" || q.Get("collapse") != "urlkey" || q.Get("resumeKey") != "key" {
+		t.Errorf("unexpected discovery query %q", api.RawQuery)
+	}
+	if want := []string{"statuscode:200", filterListingPages}; !reflect.DeepEqual(q["filter"], want) {
+		t.Errorf("filters = %v; want %v", q["filter"], want)
+	}
+	if !isCDXRequest(api) {
+		t.Errorf("isCDXRequest(%s) = false", api)
+	}
+}
@@ -0,0 +1,162 @@
+package backfill
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
+)
+
+// Options controls which restaurants and Wayback snapshots a backfill
+// processes. The zero value processes every snapshot of every restaurant.
+type Options struct {
+	// Sources names the web archives to take snapshots from; see
+	// webarchive.Archives. Defaults to the Wayback Machine.
+	Sources []string
+
+	// From and To bound the captures requested from the CDX API. They are
+	// Wayback timestamps of 4 to 14 digits (yyyy[MM[dd[hh[mm[ss]]]]]).
+	From string
+	To   string
+
+	// Years keeps only captures made in these years. Only one of Years and
+	// From/To may be set.
+	Years []int
+
+	// LatestPerYear keeps only the most recent distinct capture of each year.
+	LatestPerYear bool
+
+	// Location keeps restaurants whose location contains it, case-insensitively.
+	Location string
+
+	// Distinctions keeps restaurants that have held any of these distinctions.
+	Distinctions []string
+
+	// StaleOnly keeps restaurants without an award for the latest guide year
+	// in the database, e.g. restaurants that have since left the guide.
+	StaleOnly bool
+
+	// Full ignores the per-restaurant checkpoints and reprocesses every
+	// snapshot, e.g. after a parser fix.
+	Full bool
+}
+
+// Validate reports whether the options are usable.
+func (o Options) Validate() error {
+	for _, name := range o.Sources {
+		if _, ok := webarchive.ByName(name); !ok {
+			return fmt.Errorf("unknown archive %q: want one of %s", name, strings.Join(webarchive.Names(), ", "))
+		}
+	}
+	for name, ts := range map[string]string{"from": o.From, "to": o.To} {
+		if ts == "" {
+			continue
+		}
+		if len(ts) < 4 || len(ts) > 14 || strings.Trim(ts, "0123456789") != "" {
+			return fmt.Errorf("invalid %s timestamp %q: want yyyy[MM[dd[hh[mm[ss]]]]]", name, ts)
+		}
+	}
+	// A short To covers the whole period it names, e.g. "2019" is all of 2019.
+	if o.From != "" && o.To != "" && o.From+strings.Repeat("0", 14-len(o.From)) > o.To+strings.Repeat("9", 14-len(o.To)) {
+		return fmt.Errorf("from %s is after to %s", o.From, o.To)
+	}
+	if len(o.Years) > 0 && (o.From != "" || o.To != "") {
+		return fmt.Errorf("years cannot be combined with from/to")
+	}
+	for _, d := range o.Distinctions {
+		if !slices.Contains(models.Distinctions, d) {
+			return fmt.Errorf("invalid distinction %q: want one of %s", d, strings.Join(models.Distinctions, ", "))
+		}
+	}
+	return nil
+}
+
+// ParseYears parses a comma-separated list of years and inclusive year ranges,
+// e.g. "2019-2021,2023".
+func ParseYears(s string) ([]int, error) {
+	var years []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid year %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid year range %q", part)
+			}
+		}
+		for y := start; y <= end; y++ {
+			if !slices.Contains(years, y) {
+				years = append(years, y)
+			}
+		}
+	}
+	slices.Sort(years)
+	return years, nil
+}
+
+// cdxRange returns the from/to bounds of the CDX query.
+func (o Options) cdxRange() (from, to string) {
+	if len(o.Years) > 0 {
+		return strconv.Itoa(o.Years[0]), strconv.Itoa(o.Years[len(o.Years)-1])
+	}
+	return o.From, o.To
+}
+
+// filterYears drops snapshots captured outside of Years, if set.
+func (o Options) filterYears(snapshots []snapshot) []snapshot {
+	if len(o.Years) == 0 {
+		return snapshots
+	}
+	var kept []snapshot
+	for _, s := range snapshots {
+		year, _ := strconv.Atoi(s.Year())
+		if slices.Contains(o.Years, year) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// selectRestaurants applies the Location, Distinctions and StaleOnly
+// selectors. Restaurants must have their awards loaded.
+func (o Options) selectRestaurants(restaurants []models.Restaurant) []models.Restaurant {
+	latestYear := 0
+	if o.StaleOnly {
+		for _, r := range restaurants {
+			for _, a := range r.Awards {
+				latestYear = max(latestYear, a.Year)
+			}
+		}
+	}
+
+	location := strings.ToLower(o.Location)
+
+	var selected []models.Restaurant
+	for _, r := range restaurants {
+		if location != "" && !strings.Contains(strings.ToLower(r.Location), location) {
+			continue
+		}
+		if len(o.Distinctions) > 0 && !slices.ContainsFunc(r.Awards, func(a models.RestaurantAward) bool {
+			return slices.Contains(o.Distinctions, a.Distinction)
+		}) {
+			continue
+		}
+		if o.StaleOnly && slices.ContainsFunc(r.Awards, func(a models.RestaurantAward) bool {
+			return a.Year == latestYear
+		}) {
+			continue
+		}
+		selected = append(selected, r)
+	}
+	return selected
+}
@@ -0,0 +1,108 @@
+package backfill
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+)
+
+func TestParseYears(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		{input: "2021", want: []int{2021}},
+		{input: "2019-2021", want: []int{2019, 2020, 2021}},
+		{input: "2023, 2019-2020,2020", want: []int{2019, 2020, 2023}},
+		{input: "2021-2019", wantErr: true},
+		{input: "twenty", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseYears(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseYears(%q) error = %v; wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseYears(%q) = %v; want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "zero value", opts: Options{}},
+		{name: "date window", opts: Options{From: "20190101", To: "2019"}},
+		{name: "short timestamp", opts: Options{From: "201"}, wantErr: true},
+		{name: "non numeric", opts: Options{To: "2019-01"}, wantErr: true},
+		{name: "inverted window", opts: Options{From: "2021", To: "2019"}, wantErr: true},
+		{name: "years with window", opts: Options{Years: []int{2020}, From: "2019"}, wantErr: true},
+		{name: "valid distinction", opts: Options{Distinctions: []string{models.OneStar}}},
+		{name: "unknown distinction", opts: Options{Distinctions: []string{"4 Stars"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOptionsCDXRangeAndYears(t *testing.T) {
+	opts := Options{Years: []int{2019, 2021}}
+	if from, to := opts.cdxRange(); from != "2019" || to != "2021" {
+		t.Errorf("cdxRange() = %q, %q; want 2019, 2021", from, to)
+	}
+
+	snapshots := []snapshot{
+		{Timestamp: "20190601000000"},
+		{Timestamp: "20200601000000"},
+		{Timestamp: "20210601000000"},
+	}
+	var got []string
+	for _, s := range opts.filterYears(snapshots) {
+		got = append(got, s.Timestamp)
+	}
+	if want := []string{"20190601000000", "20210601000000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filterYears() = %v; want %v", got, want)
+	}
+}
+
+func TestSelectRestaurants(t *testing.T) {
+	restaurants := []models.Restaurant{
+		{URL: "a", Location: "Paris, France", Awards: []models.RestaurantAward{{Year: 2024, Distinction: models.OneStar}}},
+		{URL: "b", Location: "Lyon, France", Awards: []models.RestaurantAward{{Year: 2022, Distinction: models.BibGourmand}}},
+		{URL: "c", Location: "Singapore", Awards: []models.RestaurantAward{{Year: 2021, Distinction: models.TwoStars}}},
+		{URL: "d", Location: "Marseille, France"},
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{name: "no selectors", want: []string{"a", "b", "c", "d"}},
+		{name: "location", opts: Options{Location: "france"}, want: []string{"a", "b", "d"}},
+		{name: "starred in france", opts: Options{Location: "France", Distinctions: []string{models.OneStar, models.TwoStars, models.ThreeStars}}, want: []string{"a"}},
+		{name: "stale only", opts: Options{StaleOnly: true}, want: []string{"b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range tt.opts.selectRestaurants(restaurants) {
+				got = append(got, r.URL)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectRestaurants() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
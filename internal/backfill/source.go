@@ -0,0 +1,131 @@
+package backfill
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
+)
+
+// Source lists the captures a web archive holds of a restaurant URL.
+type Source interface {
+	// Archive returns the archive serving the captures.
+	Archive() *webarchive.Archive
+
+	// Query returns the URL listing the captures of restaurantURL between
+	// the from and to timestamps. Sources that cannot filter by time ignore
+	// them; captures are filtered again once parsed.
+	Query(restaurantURL, from, to string) string
+
+	// Owns reports whether u is a query of this source and, if so, returns
+	// the restaurant URL it was made for.
+	Owns(u *url.URL) (restaurantURL string, ok bool)
+
+	// Parse extracts the captures from a query response.
+	Parse(body []byte) ([]snapshot, error)
+}
+
+// newSource returns the source for the named archive: the CDX API for the
+// Wayback Machine, which also reports digests and status codes, and the
+// Memento TimeMap for every other archive.
+func newSource(name string) (Source, error) {
+	archive, ok := webarchive.ByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown archive %q: want one of %s", name, strings.Join(webarchive.Names(), ", "))
+	}
+	if name == webarchive.Wayback {
+		return &cdxSource{archive: archive}, nil
+	}
+	return &mementoSource{archive: archive}, nil
+}
+
+// cdxSource queries the Internet Archive CDX API.
+type cdxSource struct {
+	archive *webarchive.Archive
+}
+
+func (s *cdxSource) Archive() *webarchive.Archive { return s.archive }
+
+func (s *cdxSource) Query(restaurantURL, from, to string) string {
+	return cdxAPI(restaurantURL, from, to)
+}
+
+func (s *cdxSource) Owns(u *url.URL) (string, bool) {
+	if u.Host != s.archive.Host() || !isCDXRequest(u) {
+		return "", false
+	}
+	return u.Query().Get("url"), true
+}
+
+func (s *cdxSource) Parse(body []byte) ([]snapshot, error) {
+	var rows [][]string
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, err
+	}
+	return parseCDX(rows), nil
+}
+
+// mementoSource queries the TimeMap of a Memento compliant archive (RFC 7089).
+type mementoSource struct {
+	archive *webarchive.Archive
+}
+
+func (s *mementoSource) Archive() *webarchive.Archive { return s.archive }
+
+func (s *mementoSource) Query(restaurantURL, _, _ string) string {
+	return s.archive.TimeMapURL(restaurantURL)
+}
+
+func (s *mementoSource) Owns(u *url.URL) (string, bool) {
+	restaurantURL, ok := strings.CutPrefix(u.String(), s.archive.TimeMap)
+	return restaurantURL, ok
+}
+
+// Parse lists every memento as a successful capture: TimeMaps carry neither
+// status codes nor digests, so nothing can be deduplicated before fetching.
+// Each capture is fetched from the URI the archive listed for it.
+func (s *mementoSource) Parse(body []byte) ([]snapshot, error) {
+	tm, err := webarchive.ParseTimeMap(body)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]snapshot, 0, len(tm.Mementos))
+	for _, m := range tm.Mementos {
+		snapshots = append(snapshots, snapshot{
+			Timestamp:  m.Timestamp(),
+			Original:   tm.Original,
+			StatusCode: "200",
+			URI:        s.archive.RawMementoURL(m.URI),
+		})
+	}
+	return snapshots, nil
+}
+
+// sourceFor returns the source that issued the query u.
+func (s *Scraper) sourceFor(u *url.URL) (Source, string, bool) {
+	for _, src := range s.sources {
+		if restaurantURL, ok := src.Owns(u); ok {
+			return src, restaurantURL, true
+		}
+	}
+	return nil, "", false
+}
+
+// inRange drops snapshots outside of the from and to timestamps, which may
+// be shorter than 14 digits.
+func inRange(snapshots []snapshot, from, to string) []snapshot {
+	if from == "" && to == "" {
+		return snapshots
+	}
+	lower := from + strings.Repeat("0", 14-len(from))
+	upper := to + strings.Repeat("9", 14-len(to))
+	var kept []snapshot
+	for _, s := range snapshots {
+		if (from == "" || s.Timestamp >= lower) && (to == "" || s.Timestamp <= upper) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
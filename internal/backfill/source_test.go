@@ -0,0 +1,92 @@
+package backfill
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
+)
+
+func TestNewSource(t *testing.T) {
+	if src, err := newSource(webarchive.Wayback); err != nil {
+		t.Errorf("newSource(wayback): %v", err)
+	} else if _, ok := src.(*cdxSource); !ok {
+		t.Errorf("newSource(wayback) = %T; want *cdxSource", src)
+	}
+	if src, err := newSource("arquivo"); err != nil {
+		t.Errorf("newSource(arquivo): %v", err)
+	} else if _, ok := src.(*mementoSource); !ok {
+		t.Errorf("newSource(arquivo) = %T; want *mementoSource", src)
+	}
+	if _, err := newSource("nope"); err == nil {
+		t.Errorf("newSource(nope) should fail")
+	}
+}
+
+func TestSourceOwns(t *testing.T) {
+	restaurantURL := "https://guide.michelin.com/pt/en/lisboa/b"
+	wayback, _ := newSource(webarchive.Wayback)
+	arquivo, _ := newSource("arquivo")
+
+	for _, src := range []Source{wayback, arquivo} {
+		u, err := url.Parse(src.Query(restaurantURL, "", ""))
+		if err != nil {
+			t.Fatalf("url.Parse: %v", err)
+		}
+		got, ok := src.Owns(u)
+		if !ok || got != restaurantURL {
+			t.Errorf("%s Owns(own query) = %q, %v; want %q, true", src.Archive().Name, got, ok, restaurantURL)
+		}
+		for _, other := range []Source{wayback, arquivo} {
+			if other == src {
+				continue
+			}
+			if _, ok := other.Owns(u); ok {
+				t.Errorf("%s should not own a %s query", other.Archive().Name, src.Archive().Name)
+			}
+		}
+	}
+}
+
+func TestMementoSourceParse(t *testing.T) {
+	src, _ := newSource("arquivo")
+	body := `<https://guide.michelin.com/pt/en/lisboa/b>; rel="original",
+<https://arquivo.pt/wayback/20210505120000/https://guide.michelin.com/pt/en/lisboa/b>; rel="first memento"; datetime="Wed, 05 May 2021 12:00:00 GMT",
+<http://arquivo.pt/wayback/20230101080910mp_/https://guide.michelin.com/pt/en/lisboa/b/>; rel="last memento"; datetime="Sun, 01 Jan 2023 08:09:10 GMT"`
+
+	got, err := src.Parse([]byte(body))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []snapshot{
+		{Timestamp: "20210505120000", Original: "https://guide.michelin.com/pt/en/lisboa/b", StatusCode: "200", URI: "https://arquivo.pt/wayback/20210505120000id_/https://guide.michelin.com/pt/en/lisboa/b"},
+		{Timestamp: "20230101080910", Original: "https://guide.michelin.com/pt/en/lisboa/b", StatusCode: "200", URI: "http://arquivo.pt/wayback/20230101080910id_/https://guide.michelin.com/pt/en/lisboa/b/"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v; want %+v", got, want)
+	}
+}
+
+func TestInRange(t *testing.T) {
+	snapshots := []snapshot{{Timestamp: "20181231235959"}, {Timestamp: "20190601000000"}, {Timestamp: "20201231235959"}, {Timestamp: "20210101000000"}}
+
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{want: []string{"20181231235959", "20190601000000", "20201231235959", "20210101000000"}},
+		{from: "2019", to: "2020", want: []string{"20190601000000", "20201231235959"}},
+		{from: "20190601", want: []string{"20190601000000", "20201231235959", "20210101000000"}},
+		{to: "2018", want: []string{"20181231235959"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range inRange(snapshots, tt.from, tt.to) {
+			got = append(got, s.Timestamp)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("inRange(%q, %q) = %v; want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// resumable reports whether the run covers the whole capture history, so
+// that per-restaurant checkpoints can be both used and advanced.
+func (o Options) resumable() bool {
+	return o.From == "" && o.To == "" && len(o.Years) == 0
+}
+
+// loadState returns the backfill state of url in the source's archive, or nil
+// if there is none.
+func (s *Scraper) loadState(ctx context.Context, src Source, url string) *models.BackfillState {
+	state, err := s.repository.FindBackfillState(ctx, url, src.Archive().Name)
+	if err != nil {
+		log.WithError(err).WithField("url", url).Warn("failed to load backfill state")
+		return nil
+	}
+	return state
+}
+
+// checkpoint returns the newest snapshot timestamp already processed for url
+// in the source's archive, or "" when this run should look at the whole
+// history.
+func (s *Scraper) checkpoint(ctx context.Context, src Source, url string) string {
+	if s.options.Full || !s.options.resumable() {
+		return ""
+	}
+	if state := s.loadState(ctx, src, url); state != nil {
+		return state.LatestSnapshot
+	}
+	return ""
+}
+
+// query returns the source's query for url, starting from its checkpoint.
+func (s *Scraper) query(ctx context.Context, src Source, url string) string {
+	from, to := s.options.cdxRange()
+	if cp := s.checkpoint(ctx, src, url); cp != "" {
+		from = cp
+	}
+	return src.Query(url, from, to)
+}
+
+// afterCheckpoint drops snapshots at or before checkpoint. The CDX from bound
+// is inclusive, so the checkpoint capture itself is always listed again.
+func afterCheckpoint(snapshots []snapshot, checkpoint string) []snapshot {
+	if checkpoint == "" {
+		return snapshots
+	}
+	var kept []snapshot
+	for _, s := range snapshots {
+		if s.Timestamp > checkpoint {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// advanceCheckpoint returns the newest timestamp among snapshots that is
+// older than the earliest failed snapshot, never moving back from checkpoint.
+func advanceCheckpoint(checkpoint string, snapshots []snapshot, failed []string) string {
+	earliestFailure := ""
+	for _, ts := range failed {
+		if earliestFailure == "" || ts < earliestFailure {
+			earliestFailure = ts
+		}
+	}
+	for _, s := range snapshots {
+		if earliestFailure != "" && s.Timestamp >= earliestFailure {
+			continue
+		}
+		if s.Timestamp > checkpoint {
+			checkpoint = s.Timestamp
+		}
+	}
+	return checkpoint
+}
+
+// saveState records the outcome of a source query for url. The checkpoint
+// only moves when the run covered the whole capture history.
+func (s *Scraper) saveState(ctx context.Context, src Source, url, checkpoint, outcome string) {
+	state := &models.BackfillState{
+		URL:           url,
+		Archive:       src.Archive().Name,
+		LastQueriedAt: time.Now().UTC(),
+		Outcome:       outcome,
+	}
+	previous := s.loadState(ctx, src, url)
+	if previous != nil {
+		state.LatestSnapshot = previous.LatestSnapshot
+	}
+	if s.options.resumable() && checkpoint > state.LatestSnapshot {
+		state.LatestSnapshot = checkpoint
+	}
+
+	if err := s.repository.SaveBackfillState(ctx, state); err != nil {
+		log.WithError(err).WithField("url", url).Warn("failed to save backfill state")
+	}
+}
+
+// visitSnapshot fetches a snapshot with the detail collector and reports
+// whether it is done with: fetched, already visited, or permanently missing.
+// Retries happen inside the collector's error handler, so a false result
+// means every attempt failed.
+func visitSnapshot(detailCollector *colly.Collector, snapshotURL string) (bool, error) {
+	reqCtx := colly.NewContext()
+	err := detailCollector.Request(http.MethodGet, snapshotURL, nil, reqCtx, nil)
+
+	var visited *colly.AlreadyVisitedError
+	if errors.As(err, &visited) || reqCtx.GetAny("fetched") != nil {
+		return true, nil
+	}
+	switch reqCtx.GetAny("status_code") {
+	case http.StatusForbidden, http.StatusNotFound:
+		return true, err
+	}
+	return false, err
+}
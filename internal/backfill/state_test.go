@@ -0,0 +1,135 @@
+package backfill
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/storage"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
+)
+
+func TestAfterCheckpoint(t *testing.T) {
+	snapshots := []snapshot{{Timestamp: "20220101000000"}, {Timestamp: "20230101000000"}, {Timestamp: "20240101000000"}}
+
+	var got []string
+	for _, s := range afterCheckpoint(snapshots, "20230101000000") {
+		got = append(got, s.Timestamp)
+	}
+	if want := []string{"20240101000000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("afterCheckpoint() = %v; want %v", got, want)
+	}
+	if len(afterCheckpoint(snapshots, "")) != 3 {
+		t.Errorf("afterCheckpoint() without checkpoint should keep everything")
+	}
+}
+
+func TestAdvanceCheckpoint(t *testing.T) {
+	snapshots := []snapshot{{Timestamp: "20220101000000"}, {Timestamp: "20230101000000"}, {Timestamp: "20240101000000"}}
+
+	tests := []struct {
+		name       string
+		checkpoint string
+		failed     []string
+		want       string
+	}{
+		{name: "all processed", want: "20240101000000"},
+		{name: "stops before first failure", failed: []string{"20240101000000", "20230101000000"}, want: "20220101000000"},
+		{name: "never moves back", checkpoint: "20230601000000", failed: []string{"20220101000000"}, want: "20230601000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := advanceCheckpoint(tt.checkpoint, snapshots, tt.failed); got != tt.want {
+				t.Errorf("advanceCheckpoint() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository: %v", err)
+	}
+	restaurantURL := "https://guide.michelin.com/sg/en/a"
+
+	src, _ := newSource(webarchive.Wayback)
+	s := &Scraper{repository: repo}
+	if from := queryParam(t, s.query(ctx, src, restaurantURL), "from"); from != "" {
+		t.Errorf("first run from = %q; want none", from)
+	}
+
+	s.saveState(ctx, src, restaurantURL, "20230101000000", models.BackfillComplete)
+	if from := queryParam(t, s.query(ctx, src, restaurantURL), "from"); from != "20230101000000" {
+		t.Errorf("incremental run from = %q; want checkpoint", from)
+	}
+
+	// A windowed run neither resumes from nor advances the checkpoint.
+	windowed := &Scraper{repository: repo, options: Options{Years: []int{2019}}}
+	if from := queryParam(t, windowed.query(ctx, src, restaurantURL), "from"); from != "2019" {
+		t.Errorf("windowed run from = %q; want 2019", from)
+	}
+	windowed.saveState(ctx, src, restaurantURL, "20190601000000", models.BackfillIncomplete)
+	state, _ := repo.FindBackfillState(ctx, restaurantURL, webarchive.Wayback)
+	if state.LatestSnapshot != "20230101000000" || state.Outcome != models.BackfillIncomplete {
+		t.Errorf("state after windowed run = %+v", state)
+	}
+
+	full := &Scraper{repository: repo, options: Options{Full: true}}
+	if from := queryParam(t, full.query(ctx, src, restaurantURL), "from"); from != "" {
+		t.Errorf("full run from = %q; want none", from)
+	}
+}
+
+func TestVisitSnapshot(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("<html></html>"))
+		case "/gone":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	c := colly.NewCollector()
+	c.OnError(func(r *colly.Response, err error) {
+		r.Ctx.Put("status_code", r.StatusCode)
+	})
+	c.OnResponse(func(r *colly.Response) {
+		r.Ctx.Put("fetched", true)
+	})
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/ok", want: true},
+		{path: "/ok", want: true}, // already visited
+		{path: "/gone", want: true},
+		{path: "/flaky", want: false},
+	}
+	for _, tt := range tests {
+		if got, _ := visitSnapshot(c, ts.URL+tt.path); got != tt.want {
+			t.Errorf("visitSnapshot(%s) = %v; want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func queryParam(t *testing.T, rawURL, name string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	return u.Query().Get(name)
+}
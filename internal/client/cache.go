@@ -0,0 +1,348 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultCacheScrapeTTL keeps live pages for roughly one daily run so an
+	// interrupted scrape can resume from cache, without serving stale awards
+	// to the next day's run.
+	DefaultCacheScrapeTTL = 24 * time.Hour
+	// DefaultCacheWaybackTTL is zero because Wayback snapshots are immutable.
+	DefaultCacheWaybackTTL = 0
+)
+
+const (
+	CacheBackendFile   = "file"
+	CacheBackendSQLite = "sqlite"
+)
+
+// Cache stores HTTP responses keyed by request URL.
+type Cache interface {
+	// Has reports whether a fresh entry exists, without decoding it.
+	Has(urlStr string) bool
+	// Get returns the fresh entry for urlStr, or nil on a miss.
+	Get(urlStr string) (*CacheEntry, error)
+	Put(entry *CacheEntry) error
+	Delete(urlStr string) error
+	Stats() (*CacheStats, error)
+	// Prune removes expired entries and returns how many were removed.
+	Prune() (int, error)
+	Close() error
+}
+
+// OpenCache returns the cache backend for a namespace path: a directory tree
+// for the file backend, or "<path>.db" for the sqlite backend.
+func OpenCache(backend, path string, ttl time.Duration) (Cache, error) {
+	switch backend {
+	case "", CacheBackendFile:
+		return NewFileCache(path, ttl), nil
+	case CacheBackendSQLite:
+		return NewSQLiteCache(path+".db", ttl)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", backend)
+	}
+}
+
+// CacheNamespace is a cache directory with its own expiry policy.
+type CacheNamespace struct {
+	Name string
+	Path string
+	TTL  time.Duration // zero means entries never expire
+}
+
+// CacheNamespaces lists the cache directories managed by mym.
+var CacheNamespaces = []CacheNamespace{
+	{Name: "scrape", Path: DefaultCacheScrape, TTL: DefaultCacheScrapeTTL},
+	{Name: "wayback", Path: DefaultCacheWayback, TTL: DefaultCacheWaybackTTL},
+}
+
+// CacheEntry is a cached HTTP response.
+type CacheEntry struct {
+	URL          string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	FetchedAt    time.Time
+	Uncompressed bool
+}
+
+// CacheStats summarises the entries of a cache.
+type CacheStats struct {
+	Entries int
+	Expired int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// FileCache stores gzip-compressed responses in a two-level sha1 directory
+// tree, the same layout colly's CacheDir uses.
+type FileCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// NewFileCache returns a FileCache rooted at dir.
+func NewFileCache(dir string, ttl time.Duration) *FileCache {
+	return &FileCache{Dir: dir, TTL: ttl}
+}
+
+func (c *FileCache) filename(urlStr string) string {
+	sum := sha1.Sum([]byte(urlStr))
+	hash := hex.EncodeToString(sum[:])
+	return path.Join(c.Dir, hash[:2], hash)
+}
+
+func (c *FileCache) expired(fetchedAt time.Time) bool {
+	return c.TTL > 0 && time.Since(fetchedAt) > c.TTL
+}
+
+// Has reports whether a fresh entry exists for urlStr without decoding it.
+func (c *FileCache) Has(urlStr string) bool {
+	info, err := os.Stat(c.filename(urlStr))
+	if err != nil {
+		return false
+	}
+	return !c.expired(info.ModTime())
+}
+
+// Get returns the entry for urlStr, or nil when it is missing, expired or
+// unreadable (e.g. written by colly's uncompressed CacheDir).
+func (c *FileCache) Get(urlStr string) (*CacheEntry, error) {
+	file, err := os.Open(c.filename(urlStr))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil
+	}
+	defer zr.Close()
+
+	entry := new(CacheEntry)
+	if err := gob.NewDecoder(zr).Decode(entry); err != nil {
+		return nil, nil
+	}
+	if c.expired(entry.FetchedAt) {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// Put writes an entry, replacing any existing one atomically.
+func (c *FileCache) Put(entry *CacheEntry) error {
+	filename := c.filename(entry.URL)
+	if err := os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(zw).Encode(entry); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filename+"~", buf.Bytes(), 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(filename+"~", filename); err != nil {
+		return err
+	}
+	// Keep mtime aligned with the fetch time so Stats and Prune can skip decoding.
+	return os.Chtimes(filename, entry.FetchedAt, entry.FetchedAt)
+}
+
+// Delete removes the entry for urlStr if present.
+func (c *FileCache) Delete(urlStr string) error {
+	if err := os.Remove(c.filename(urlStr)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Close is a no-op for FileCache.
+func (c *FileCache) Close() error {
+	return nil
+}
+
+// Stats walks the cache directory and summarises its entries.
+func (c *FileCache) Stats() (*CacheStats, error) {
+	stats := &CacheStats{}
+	err := c.walk(func(p string, info fs.FileInfo) error {
+		stats.Entries++
+		stats.Bytes += info.Size()
+		if c.expired(info.ModTime()) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || info.ModTime().Before(stats.Oldest) {
+			stats.Oldest = info.ModTime()
+		}
+		if info.ModTime().After(stats.Newest) {
+			stats.Newest = info.ModTime()
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Prune removes expired entries and leftover temporary files, returning the
+// number of files removed.
+func (c *FileCache) Prune() (int, error) {
+	removed := 0
+	err := c.walk(func(p string, info fs.FileInfo) error {
+		if !strings.HasSuffix(p, "~") && !c.expired(info.ModTime()) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+func (c *FileCache) walk(fn func(p string, info fs.FileInfo) error) error {
+	err := filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(p, info)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// cacheTransport serves GET requests from a Cache and stores cacheable
+// responses. Cache hits never reach next, so they are not rate limited.
+type cacheTransport struct {
+	cache Cache
+	next  http.RoundTripper
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cacheControl := req.Header.Get("Cache-Control")
+	if req.Method != http.MethodGet || cacheControl == "no-cache" {
+		return t.next.RoundTrip(req)
+	}
+
+	urlStr := req.URL.String()
+	entry, err := t.cache.Get(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if maxAge, ok := parseMaxAge(cacheControl); !ok || time.Since(entry.FetchedAt) <= maxAge {
+			return entry.response(req), nil
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if cacheable(resp.StatusCode, body) {
+		header := resp.Header.Clone()
+		// Replaying Set-Cookie from cache would overwrite the live session in the jar.
+		header.Del("Set-Cookie")
+		err := t.cache.Put(&CacheEntry{
+			URL:          urlStr,
+			StatusCode:   resp.StatusCode,
+			Header:       header,
+			Body:         body,
+			FetchedAt:    time.Now().UTC(),
+			Uncompressed: resp.Uncompressed,
+		})
+		if err != nil {
+			log.WithError(err).WithField("url", urlStr).Warn("failed to write cache entry")
+		}
+	}
+	return resp, nil
+}
+
+// cacheable refuses to cache error responses, session-expiry 202s and empty
+// bodies so a transient failure is never replayed on the next run.
+func cacheable(statusCode int, body []byte) bool {
+	switch statusCode {
+	case http.StatusOK:
+		return len(bytes.TrimSpace(body)) > 0
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// parseMaxAge returns the max-age directive of a request Cache-Control header.
+func parseMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+func (e *CacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+		Uncompressed:  e.Uncompressed,
+	}
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SQLiteCache stores responses in a single sqlite file, which is far cheaper
+// to ship around and delete than hundreds of thousands of small files.
+// Bodies are gzip-compressed; headers are stored as JSON.
+type SQLiteCache struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+// NewSQLiteCache opens (or creates) a sqlite cache at filename.
+func NewSQLiteCache(filename string, ttl time.Duration) (*SQLiteCache, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+	// Queue workers write concurrently; a single connection serializes them
+	// instead of surfacing "database is locked".
+	db.SetMaxOpenConns(1)
+
+	statements := []string{
+		"PRAGMA journal_mode = WAL;",
+		`CREATE TABLE IF NOT EXISTS responses (
+			url TEXT PRIMARY KEY,
+			status_code INTEGER NOT NULL,
+			header TEXT NOT NULL,
+			body BLOB NOT NULL,
+			uncompressed INTEGER NOT NULL,
+			fetched_at INTEGER NOT NULL
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_responses_fetched_at ON responses (fetched_at)",
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &SQLiteCache{db: db, ttl: ttl}, nil
+}
+
+// cutoff returns the oldest fetch time still considered fresh.
+func (c *SQLiteCache) cutoff() int64 {
+	if c.ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(-c.ttl).Unix()
+}
+
+// Has reports whether a fresh entry exists for urlStr.
+func (c *SQLiteCache) Has(urlStr string) bool {
+	var n int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM responses WHERE url = ? AND fetched_at >= ?", urlStr, c.cutoff()).Scan(&n)
+	return err == nil && n > 0
+}
+
+// Get returns the fresh entry for urlStr, or nil on a miss.
+func (c *SQLiteCache) Get(urlStr string) (*CacheEntry, error) {
+	var (
+		header       string
+		body         []byte
+		uncompressed bool
+		fetchedAt    int64
+	)
+	entry := &CacheEntry{URL: urlStr}
+	err := c.db.QueryRow(
+		"SELECT status_code, header, body, uncompressed, fetched_at FROM responses WHERE url = ? AND fetched_at >= ?",
+		urlStr, c.cutoff(),
+	).Scan(&entry.StatusCode, &header, &body, &uncompressed, &fetchedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(header), &entry.Header); err != nil {
+		return nil, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil
+	}
+	defer zr.Close()
+	if entry.Body, err = io.ReadAll(zr); err != nil {
+		return nil, nil
+	}
+	entry.Uncompressed = uncompressed
+	entry.FetchedAt = time.Unix(fetchedAt, 0).UTC()
+	return entry, nil
+}
+
+// Put inserts or replaces the entry for entry.URL.
+func (c *SQLiteCache) Put(entry *CacheEntry) error {
+	header, err := json.Marshal(entry.Header)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(entry.Body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(
+		`INSERT INTO responses (url, status_code, header, body, uncompressed, fetched_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET status_code = excluded.status_code, header = excluded.header,
+			body = excluded.body, uncompressed = excluded.uncompressed, fetched_at = excluded.fetched_at`,
+		entry.URL, entry.StatusCode, string(header), buf.Bytes(), entry.Uncompressed, entry.FetchedAt.Unix(),
+	)
+	return err
+}
+
+// Delete removes the entry for urlStr if present.
+func (c *SQLiteCache) Delete(urlStr string) error {
+	_, err := c.db.Exec("DELETE FROM responses WHERE url = ?", urlStr)
+	return err
+}
+
+// Stats summarises the entries of the cache.
+func (c *SQLiteCache) Stats() (*CacheStats, error) {
+	var (
+		stats          = &CacheStats{}
+		size           sql.NullInt64
+		oldest, newest sql.NullInt64
+	)
+	err := c.db.QueryRow(
+		"SELECT COUNT(*), SUM(LENGTH(body) + LENGTH(header)), MIN(fetched_at), MAX(fetched_at) FROM responses",
+	).Scan(&stats.Entries, &size, &oldest, &newest)
+	if err != nil {
+		return nil, err
+	}
+	stats.Bytes = size.Int64
+	if oldest.Valid {
+		stats.Oldest = time.Unix(oldest.Int64, 0).UTC()
+		stats.Newest = time.Unix(newest.Int64, 0).UTC()
+	}
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM responses WHERE fetched_at < ?", c.cutoff()).Scan(&stats.Expired); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Prune removes expired entries and returns how many were removed.
+func (c *SQLiteCache) Prune() (int, error) {
+	res, err := c.db.Exec("DELETE FROM responses WHERE fetched_at < ?", c.cutoff())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Close closes the underlying database.
+func (c *SQLiteCache) Close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestCacheBackendsExpireAndPrune(t *testing.T) {
+	for _, backend := range []string{CacheBackendFile, CacheBackendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			cache, err := OpenCache(backend, filepath.Join(t.TempDir(), "scrape"), time.Hour)
+			if err != nil {
+				t.Fatalf("OpenCache: %v", err)
+			}
+			defer cache.Close()
+
+			fresh := &CacheEntry{
+				URL:        "https://guide.michelin.com/fresh",
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": {"text/html"}},
+				Body:       []byte("fresh"),
+				FetchedAt:  time.Now().UTC(),
+			}
+			stale := &CacheEntry{
+				URL:        "https://guide.michelin.com/stale",
+				StatusCode: 200,
+				Header:     http.Header{},
+				Body:       []byte("stale"),
+				FetchedAt:  time.Now().Add(-2 * time.Hour).UTC(),
+			}
+			for _, e := range []*CacheEntry{fresh, stale} {
+				if err := cache.Put(e); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+			}
+
+			got, err := cache.Get(fresh.URL)
+			if err != nil || got == nil {
+				t.Fatalf("Get(fresh) = %v, %v", got, err)
+			}
+			if string(got.Body) != "fresh" || got.StatusCode != 200 || got.Header.Get("Content-Type") != "text/html" {
+				t.Errorf("unexpected entry: %+v", got)
+			}
+			if got.FetchedAt.Unix() != fresh.FetchedAt.Unix() {
+				t.Errorf("FetchedAt = %v, want %v", got.FetchedAt, fresh.FetchedAt)
+			}
+			if got, _ := cache.Get(stale.URL); got != nil {
+				t.Errorf("expected stale entry to be treated as a miss")
+			}
+			if !cache.Has(fresh.URL) || cache.Has(stale.URL) {
+				t.Errorf("Has mismatch: fresh=%v stale=%v", cache.Has(fresh.URL), cache.Has(stale.URL))
+			}
+
+			stats, err := cache.Stats()
+			if err != nil {
+				t.Fatalf("Stats: %v", err)
+			}
+			if stats.Entries != 2 || stats.Expired != 1 || stats.Bytes == 0 {
+				t.Errorf("Stats = %+v", stats)
+			}
+
+			removed, err := cache.Prune()
+			if err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			if removed != 1 {
+				t.Errorf("Prune removed %d, want 1", removed)
+			}
+			if !cache.Has(fresh.URL) {
+				t.Errorf("fresh entry should survive prune")
+			}
+
+			if err := cache.Delete(fresh.URL); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if cache.Has(fresh.URL) {
+				t.Errorf("entry should be gone after Delete")
+			}
+		})
+	}
+}
+
+func TestFileCacheIgnoresLegacyEntries(t *testing.T) {
+	cache := NewFileCache(t.TempDir(), 0)
+	url := "https://web.archive.org/web/20200101000000id_/https://guide.michelin.com/x"
+
+	filename := cache.filename(url)
+	if err := os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filename, []byte("not gzip"), 0640); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, err := cache.Get(url)
+	if err != nil || got != nil {
+		t.Fatalf("Get(legacy) = %v, %v; want miss", got, err)
+	}
+}
+
+func TestCacheTransport(t *testing.T) {
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/ok":
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "SESS-001"})
+			io.WriteString(w, "<html>ok</html>")
+		case "/empty":
+			w.WriteHeader(http.StatusOK)
+		case "/accepted":
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, "<html>login</html>")
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "boom")
+		}
+	}))
+	defer srv.Close()
+
+	cache := NewFileCache(t.TempDir(), 0)
+	client := &http.Client{Transport: &cacheTransport{cache: cache, next: http.DefaultTransport}}
+
+	get := func(path string, header http.Header) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	for range 2 {
+		for _, p := range []string{"/ok", "/empty", "/accepted", "/error"} {
+			get(p, nil)
+		}
+	}
+
+	want := map[string]int{"/ok": 1, "/empty": 2, "/accepted": 2, "/error": 2}
+	for p, n := range want {
+		if hits[p] != n {
+			t.Errorf("%s reached server %d times, want %d", p, hits[p], n)
+		}
+	}
+
+	entry, err := cache.Get(srv.URL + "/ok")
+	if err != nil || entry == nil {
+		t.Fatalf("expected /ok to be cached: %v", err)
+	}
+	if entry.Header.Get("Set-Cookie") != "" {
+		t.Errorf("Set-Cookie should not be cached, got %q", entry.Header.Get("Set-Cookie"))
+	}
+
+	if body := get("/ok", http.Header{"Cache-Control": {"no-cache"}}); body != "<html>ok</html>" {
+		t.Errorf("no-cache body = %q", body)
+	}
+	if hits["/ok"] != 2 {
+		t.Errorf("no-cache request should bypass cache, hits = %d", hits["/ok"])
+	}
+
+	if err := cache.Put(&CacheEntry{URL: srv.URL + "/ok", StatusCode: 200, Header: http.Header{}, Body: []byte("old"), FetchedAt: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if body := get("/ok", http.Header{"Cache-Control": {"max-age=3600"}}); body != "<html>ok</html>" {
+		t.Errorf("max-age should refetch stale entry, got %q", body)
+	}
+}
+
+func TestNewServesCachedResponsesWithoutDelay(t *testing.T) {
+	for _, backend := range []string{CacheBackendFile, CacheBackendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			testNewServesCachedResponses(t, backend)
+		})
+	}
+}
+
+func testNewServesCachedResponses(t *testing.T, backend string) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		io.WriteString(w, "<html><body>cached</body></html>")
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	dir := t.TempDir()
+	cl, err := New(&Config{
+		AllowedDomains: []string{u.Hostname()},
+		CacheBackend:   backend,
+		CachePath:      filepath.Join(dir, "cache"),
+		StoragePath:    filepath.Join(dir, "colly.db"),
+		Delay:          time.Second,
+		ThreadCount:    1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var bodies []string
+	dc := cl.GetDetailCollector()
+	dc.AllowURLRevisit = true
+	dc.OnResponse(func(r *colly.Response) {
+		bodies = append(bodies, string(r.Body))
+	})
+
+	start := time.Now()
+	for range 3 {
+		if err := dc.Visit(srv.URL + "/restaurant"); err != nil {
+			t.Fatalf("Visit: %v", err)
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("server hit %d times, want 1", hits)
+	}
+	if len(bodies) != 3 || bodies[2] != "<html><body>cached</body></html>" {
+		t.Errorf("unexpected bodies: %q", bodies)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("cache hits should not wait for the rate limit, took %s", elapsed)
+	}
+	if _, hit := cl.IsCached(srv.URL + "/restaurant"); !hit {
+		t.Errorf("IsCached reported a miss")
+	}
+}
+
+func TestCloseClosesCache(t *testing.T) {
+	dir := t.TempDir()
+	cl, err := New(&Config{
+		CacheBackend: CacheBackendSQLite,
+		CachePath:    filepath.Join(dir, "cache"),
+		StoragePath:  filepath.Join(dir, "colly.db"),
+		ThreadCount:  1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := cl.cache.Stats(); err == nil {
+		t.Errorf("expected the cache to be closed with the client")
+	}
+}
@@ -1,42 +1,56 @@
 package client
 
 import (
-	"crypto/sha1"
 	"database/sql"
-	"encoding/hex"
+	"errors"
+	"net/http"
 	"net/http/cookiejar"
 	"net/url"
-	"os"
-	"path"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/extensions"
 	"github.com/gocolly/colly/v2/queue"
-	"github.com/gocolly/colly/v2/storage"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
 	log "github.com/sirupsen/logrus"
 	"github.com/velebak/colly-sqlite3-storage/colly/sqlite3"
 )
 
 const (
+	DefaultArchivePath  = "data/archive.db"
 	DefaultCacheScrape  = "cache/scrape"
 	DefaultCacheWayback = "cache/wayback"
+	DefaultCookiePath   = "data/cookies.enc"
 	DefaultDataPath     = "data/michelin.db"
 	DefaultStoragePath  = "data/colly.db"
+
+	// DefaultRequestTimeout matches colly's default client timeout.
+	DefaultRequestTimeout = 10 * time.Second
 )
 
 // Config defines the minimal config needed for Colly
 type Config struct {
+	AccountsPath   string // JSON accounts used to refresh pooled sessions; see auth.LoadAccounts
 	AllowedDomains []string
+	ArchivePath    string
+	CacheBackend   string // CacheBackendFile (default) or CacheBackendSQLite
 	CachePath      string
+	CacheTTL       time.Duration
+	Conditional    bool   // send recorded validators with requests; see RecordValidators
+	CookiePath     string // encrypted session cookie store; "" starts without a session
+	CookieKey      []byte // key for CookiePath, see cookiestore.KeyFromEnv
+	Session        string // use only this stored session; "" pools all of them
 	DatabasePath   string
 	StoragePath    string
+	WARCPath       string // directory for WARC output; "" disables it
+	WARCMaxSize    int64
 	Delay          time.Duration
 	MaxRetry       int
+	Proxies        string // comma-separated http(s):// or socks5:// proxy URLs; "" connects directly
+	ProxyMode      string // ProxyRoundRobin (default) or ProxySticky
 	RandomDelay    time.Duration
 	RequestTimeout time.Duration
+	Run            string // RunScrape or RunBackfill, recorded with failed requests
 	ThreadCount    int
 }
 
@@ -45,62 +59,121 @@ type Colly struct {
 	collector *colly.Collector
 	queue     *queue.Queue
 	storage   *sqlite3.Storage
+	db        *sql.DB // the client's own handle on StoragePath; see openStorage
+	cache     Cache
+	cookies   *cookiestore.Store // nil unless Config.CookiePath is set
+	pool      *sessionPool       // nil unless the store holds several sessions
+	proxies   *proxyPool         // nil unless Config.Proxies is set
 	config    *Config
 }
 
 // New creates a new web client instance
 func New(cfg *Config) (*Colly, error) {
-	// We build collector options conditionally so cache can be disabled when CachePath is empty
-	opts := []colly.CollectorOption{
+	collector := colly.NewCollector(
 		colly.Async(false), // SQLite WAL only supports one write at a time
-	}
+		colly.AllowedDomains(cfg.AllowedDomains...),
+	)
 
-	if cfg.CachePath != "" {
-		opts = append(opts, colly.CacheDir(filepath.Join(cfg.CachePath)))
+	// Rate limiting and caching live in the transport rather than in colly's
+	// LimitRule and CacheDir so that cache hits skip the delay, and so the
+	// cache can expire, compress and refuse entries.
+	// The timeout is applied by limitTransport; see its doc comment.
+	timeout := cfg.RequestTimeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
 	}
+	collector.SetRequestTimeout(0)
 
-	opts = append(opts, colly.AllowedDomains(cfg.AllowedDomains...))
+	// Session cookies live in the encrypted cookie store rather than in
+	// colly-sqlite3-storage, whose SetCookies uses plain INSERT (not UPSERT)
+	// and whose reads always return the oldest row.
+	// sqlite storage continues serving visited-URL dedup and the queue.
+	var (
+		cookies  *cookiestore.Store
+		sessions map[string][]*http.Cookie
+		err      error
+	)
+	if cfg.CookiePath != "" {
+		cookies, err = cookiestore.New(cfg.CookiePath, cfg.CookieKey)
+		if err != nil {
+			return nil, err
+		}
+		sessions, err = cookies.LoadAll()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Session != "" {
+			sessions = map[string][]*http.Cookie{cfg.Session: sessions[cfg.Session]}
+		}
+	}
 
-	collector := colly.NewCollector(opts...)
-	if cfg.RequestTimeout > 0 {
-		collector.SetRequestTimeout(cfg.RequestTimeout)
+	// Proxies sit below the limiter, so that the time a request spends in
+	// flight is what their latency is measured on. Responses slower than
+	// half the timeout count against a proxy.
+	var (
+		base    http.RoundTripper = http.DefaultTransport
+		proxies *proxyPool
+	)
+	if cfg.Proxies != "" {
+		proxies, err = newProxyPool(cfg.Proxies, cfg.ProxyMode, timeout/2)
+		if err != nil {
+			return nil, err
+		}
+		base = newProxyTransport(proxies)
 	}
 
-	if err := collector.Limit(&colly.LimitRule{
-		DomainGlob:  "*",
-		Delay:       cfg.Delay,
-		RandomDelay: cfg.RandomDelay,
-	}); err != nil {
-		return nil, err
+	var (
+		transport http.RoundTripper = newLimitTransport(base, cfg.Delay, cfg.RandomDelay, timeout)
+		cache     Cache
+		pool      *sessionPool
+	)
+	// With several sessions each request is assigned one in the transport,
+	// below the cache so cache hits do not count against a session.
+	if len(sessions) > 1 {
+		pool, err = newSessionPool(cookies, cfg.AllowedDomains, sessions)
+		if err != nil {
+			return nil, err
+		}
+		transport = &sessionTransport{pool: pool, next: transport}
+	}
+	var conditional *conditionalTransport
+	if cfg.Conditional {
+		conditional = &conditionalTransport{next: transport}
+		transport = conditional
+	}
+	if cfg.CachePath != "" {
+		cache, err = OpenCache(cfg.CacheBackend, cfg.CachePath, cfg.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		transport = &cacheTransport{cache: cache, next: transport}
 	}
+	collector.WithTransport(transport)
 
 	extensions.RandomUserAgent(collector)
 	extensions.Referer(collector)
 
 	collyStorage := &sqlite3.Storage{Filename: cfg.StoragePath}
 
-	err := collector.SetStorage(collyStorage)
-	if err != nil {
+	if err := collector.SetStorage(collyStorage); err != nil {
 		return nil, err
 	}
 
-	// colly-sqlite3-storage.SetCookies uses plain INSERT (not UPSERT), so
-	// Set-Cookie responses accumulate stale rows; reads always return the
-	// oldest row.
-	// The fix here is to seed an in-memory jar from sqlite once at startup,
-	// then let Go's standard jar handle all subsequent Set-Cookie updates.
-	// sqlite storage continues serving visited-URL dedup and the queue.
-	memJar, err := cookiejar.New(nil)
-	if err != nil {
-		return nil, err
-	}
-	for _, domain := range cfg.AllowedDomains {
-		u := &url.URL{Scheme: "https", Host: domain}
-		if raw := collyStorage.Cookies(u); raw != "" {
-			memJar.SetCookies(u, storage.UnstringifyCookies(raw))
+	// A single session seeds an in-memory jar once at startup, then Go's
+	// standard jar handles all subsequent Set-Cookie updates. A pool keeps a
+	// jar per session in sessionTransport instead.
+	if pool != nil {
+		collector.SetCookieJar(nil)
+	} else {
+		memJar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
 		}
+		for _, stored := range sessions {
+			seedJar(memJar, cfg.AllowedDomains, stored)
+		}
+		collector.SetCookieJar(memJar)
 	}
-	collector.SetCookieJar(memJar)
 
 	queue, err := queue.New(
 		cfg.ThreadCount,
@@ -110,12 +183,36 @@ func New(cfg *Config) (*Colly, error) {
 		return nil, err
 	}
 
-	return &Colly{
+	db, err := openStorage(cfg.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Colly{
 		collector: collector,
 		queue:     queue,
 		storage:   collyStorage,
+		db:        db,
+		cache:     cache,
+		cookies:   cookies,
+		pool:      pool,
+		proxies:   proxies,
 		config:    cfg,
-	}, nil
+	}
+	if conditional != nil {
+		conditional.lookup = w.Validators
+	}
+	return w, nil
+}
+
+// Close releases the client's handles on the colly storage file and the
+// response cache.
+func (w *Colly) Close() error {
+	var err error
+	if w.cache != nil {
+		err = w.cache.Close()
+	}
+	return errors.Join(err, w.db.Close())
 }
 
 // GetCollector returns the colly collector for direct access.
@@ -145,36 +242,20 @@ func (w *Colly) GetDetailCollector() *colly.Collector {
 	return dc
 }
 
-// ClearCache removes the cache file for a given colly.Request
+// ClearCache removes the cache entry for a given colly.Request
 func (w *Colly) ClearCache(r *colly.Request) error {
-	if w.config == nil || w.config.CachePath == "" {
+	if w.cache == nil {
 		return nil
 	}
-
-	sum := sha1.Sum([]byte(r.URL.String()))
-	hash := hex.EncodeToString(sum[:])
-	filename := path.Join(w.config.CachePath, hash[:2], hash)
-
-	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	return nil
+	return w.cache.Delete(r.URL.String())
 }
 
-// IsCached reports whether cache is enabled and whether a URL exists in cache.
+// IsCached reports whether cache is enabled and whether a fresh entry for a URL exists in cache.
 func (w *Colly) IsCached(urlStr string) (cacheEnabled bool, cacheHit bool) {
-	if w == nil || w.config == nil || strings.TrimSpace(w.config.CachePath) == "" {
+	if w == nil || w.cache == nil {
 		return false, false
 	}
-
-	sum := sha1.Sum([]byte(urlStr))
-	hash := hex.EncodeToString(sum[:])
-	filename := path.Join(w.config.CachePath, hash[:2], hash)
-
-	if _, err := os.Stat(filename); err == nil {
-		return true, true
-	}
-	return true, false
+	return true, w.cache.Has(urlStr)
 }
 
 // EnqueueURL adds a URL to the queue for processing
@@ -195,6 +276,12 @@ func (w *Colly) RunQueue(dc *colly.Collector) error {
 	return nil
 }
 
+// StopQueue makes a running RunQueue return once the requests in flight are
+// done, leaving the rest on the queue.
+func (w *Colly) StopQueue() {
+	w.queue.Stop()
+}
+
 // QueueSize returns the number of pending requests in the queue.
 func (w *Colly) QueueSize() (int, error) {
 	return w.queue.Size()
@@ -203,12 +290,7 @@ func (w *Colly) QueueSize() (int, error) {
 // ClearVisited removes all rows from the visited table so that a fresh Phase 1
 // run can re-visit seed listing pages that were marked visited in a prior completed run.
 func (w *Colly) ClearVisited() error {
-	db, err := sql.Open("sqlite3", w.config.StoragePath)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-	_, err = db.Exec("DELETE FROM visited")
+	_, err := w.db.Exec("DELETE FROM visited")
 	return err
 }
 
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/storage"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
 	"github.com/velebak/colly-sqlite3-storage/colly/sqlite3"
 )
 
@@ -18,30 +20,33 @@ func sessionCookies(name, value string) []*http.Cookie {
 	return []*http.Cookie{{Name: name, Value: value}}
 }
 
-// TestNewSeedsCookiesFromSQLite verifies that cookies already stored in the
-// SQLite backend are seeded into the in-memory jar when New() is called.
-// This guards against the colly-sqlite3-storage plain-INSERT bug where the
-// stale first row would be returned on every read, causing the scraper to send
-// expired session cookies after a re-login.
-func TestNewSeedsCookiesFromSQLite(t *testing.T) {
+// testCookieKey is a fixed AES-256 key for cookie store tests.
+var testCookieKey = []byte("0123456789abcdef0123456789abcdef")
+
+// TestNewSeedsCookiesFromCookieStore verifies that cookies already saved in
+// the encrypted cookie store are seeded into the in-memory jar when New() is
+// called.
+func TestNewSeedsCookiesFromCookieStore(t *testing.T) {
 	dir := t.TempDir()
-	storagePath := filepath.Join(dir, "colly.db")
+	cookiePath := filepath.Join(dir, "cookies.enc")
 	domain := "guide.michelin.com"
 	target := &url.URL{Scheme: "https", Host: domain}
 
-	// Pre-populate the SQLite storage with a known session cookie, exactly as
+	// Pre-populate the cookie store with a known session cookie, exactly as
 	// InitCookies does after a successful login.
-	store := &sqlite3.Storage{Filename: storagePath}
-	if err := store.Init(); err != nil {
-		t.Fatalf("store.Init: %v", err)
+	store, err := cookiestore.New(cookiePath, testCookieKey)
+	if err != nil {
+		t.Fatalf("cookiestore.New: %v", err)
+	}
+	if err := store.Save(cookiestore.DefaultSession, sessionCookies("michelin_session", "abc123")); err != nil {
+		t.Fatalf("store.Save: %v", err)
 	}
-	raw := storage.StringifyCookies(sessionCookies("michelin_session", "abc123"))
-	store.SetCookies(target, raw)
-	store.Close()
 
 	cfg := &Config{
 		AllowedDomains: []string{domain},
-		StoragePath:    storagePath,
+		CookiePath:     cookiePath,
+		CookieKey:      testCookieKey,
+		StoragePath:    filepath.Join(dir, "colly.db"),
 		Delay:          0,
 		RandomDelay:    0,
 		ThreadCount:    1,
@@ -57,18 +62,18 @@ func TestNewSeedsCookiesFromSQLite(t *testing.T) {
 	if cookies["michelin_session"] != "abc123" {
 		t.Errorf("expected michelin_session=abc123 in jar, got: %v", cookies)
 	}
+
+	if _, err := New(&Config{AllowedDomains: []string{domain}, CookiePath: cookiePath, StoragePath: cfg.StoragePath}); !errors.Is(err, cookiestore.ErrMissingKey) {
+		t.Errorf("expected ErrMissingKey without a key, got %v", err)
+	}
 }
 
-// TestNewStaleRowWinsOnDuplicateCookies documents the colly-sqlite3-storage
+// TestNewIgnoresPlaintextCookies guards against the colly-sqlite3-storage
 // plain-INSERT bug: when two SetCookies calls write the same cookie name,
-// Cookies() returns only the first (stale) row — the latest value is silently
-// discarded. New() seeds memJar from that stale row, so the scraper sends an
-// expired session cookie after a re-login. This is exactly the bug that
-// InitCookies (Clear+Init) is designed to prevent.
-//
-// If this test ever fails (val == "fresh"), the library has been fixed and
-// the InitCookies workaround can be removed.
-func TestNewStaleRowWinsOnDuplicateCookies(t *testing.T) {
+// Cookies() returns only the first (stale) row. Seeding the jar from colly.db
+// sent an expired session cookie after a re-login, so New() must only read
+// the cookie store, and SetSessions clears the plaintext rows.
+func TestNewIgnoresPlaintextCookies(t *testing.T) {
 	dir := t.TempDir()
 	storagePath := filepath.Join(dir, "colly.db")
 	domain := "guide.michelin.com"
@@ -79,13 +84,15 @@ func TestNewStaleRowWinsOnDuplicateCookies(t *testing.T) {
 		t.Fatalf("store.Init: %v", err)
 	}
 
-	// Simulate two logins: stale cookie first, then fresh cookie.
+	// Simulate two logins by an earlier version: stale cookie first, then fresh cookie.
 	store.SetCookies(target, storage.StringifyCookies(sessionCookies("michelin_session", "stale")))
 	store.SetCookies(target, storage.StringifyCookies(sessionCookies("michelin_session", "fresh")))
 	store.Close()
 
 	cfg := &Config{
 		AllowedDomains: []string{domain},
+		CookiePath:     filepath.Join(dir, "cookies.enc"),
+		CookieKey:      testCookieKey,
 		StoragePath:    storagePath,
 		Delay:          0,
 		RandomDelay:    0,
@@ -96,13 +103,18 @@ func TestNewStaleRowWinsOnDuplicateCookies(t *testing.T) {
 	if err != nil {
 		t.Fatalf("New: %v", err)
 	}
+	if val, ok := cl.GetCookies(target.String())["michelin_session"]; ok {
+		t.Errorf("expected no session from colly.db, got %q", val)
+	}
 
-	cookies := cl.GetCookies(target.String())
-	val := cookies["michelin_session"]
-	// Expect "stale": the plain-INSERT bug means Cookies() returns the first row.
-	// A failure here means the library now returns "fresh" — the bug is fixed.
-	if val != "stale" {
-		t.Errorf("sqlite3 storage returned %q; expected \"stale\" (plain-INSERT bug) — if \"fresh\", the bug is fixed and InitCookies workaround can be removed", val)
+	if err := cl.SetSessions(map[string][]*http.Cookie{cookiestore.DefaultSession: sessionCookies("michelin_session", "latest")}); err != nil {
+		t.Fatalf("SetSessions: %v", err)
+	}
+	if val := cl.GetCookies(target.String())["michelin_session"]; val != "latest" {
+		t.Errorf("expected the new session in the jar, got %q", val)
+	}
+	if raw := cl.storage.Cookies(target); raw != "" {
+		t.Errorf("expected plaintext cookies to be cleared from colly.db, got %q", raw)
 	}
 }
 
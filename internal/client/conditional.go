@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Validators are what a previously processed page is recognised by: the
+// ETag and Last-Modified headers it came with and a hash of its body.
+type Validators struct {
+	ETag         string
+	LastModified string
+	BodyHash     string
+}
+
+// Validators returns the validators stored for rawURL, or nil if the page was
+// never recorded.
+func (w *Colly) Validators(rawURL string) (*Validators, error) {
+	var v Validators
+	err := w.db.QueryRow("SELECT etag, last_modified, body_hash FROM validators WHERE url = ?", rawURL).
+		Scan(&v.ETag, &v.LastModified, &v.BodyHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// RecordValidators stores the validators of a response once it has been
+// processed, so that the next run can skip the page while it is unchanged.
+func (w *Colly) RecordValidators(r *colly.Response) error {
+	etag, lastModified := "", ""
+	if r.Headers != nil {
+		etag, lastModified = r.Headers.Get("ETag"), r.Headers.Get("Last-Modified")
+	}
+	_, err := w.db.Exec(
+		`INSERT INTO validators (url, etag, last_modified, body_hash, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET etag = excluded.etag, last_modified = excluded.last_modified,
+			body_hash = excluded.body_hash, updated_at = excluded.updated_at`,
+		r.Request.URL.String(), etag, lastModified, bodyHash(r.Body), time.Now().UTC(),
+	)
+	return err
+}
+
+// ForgetValidators drops the validators of rawURL so that the next request
+// fetches and processes the page in full.
+func (w *Colly) ForgetValidators(rawURL string) error {
+	_, err := w.db.Exec("DELETE FROM validators WHERE url = ?", rawURL)
+	return err
+}
+
+// Unchanged reports whether a response body is the same as when its page was
+// last recorded, for servers that send no validators.
+func (w *Colly) Unchanged(r *colly.Response) (bool, error) {
+	v, err := w.Validators(r.Request.URL.String())
+	if err != nil || v == nil {
+		return false, err
+	}
+	return v.BodyHash != "" && v.BodyHash == bodyHash(r.Body), nil
+}
+
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// conditionalTransport turns requests for recorded pages into conditional
+// requests, so that the server can answer 304 Not Modified. It sits below the
+// cache, which never stores a 304. Probes, sent with no-cache, go out as is.
+type conditionalTransport struct {
+	lookup func(rawURL string) (*Validators, error)
+	next   http.RoundTripper
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Cache-Control") == "no-cache" {
+		return t.next.RoundTrip(req)
+	}
+	v, err := t.lookup(req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || (v.ETag == "" && v.LastModified == "") {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	var conditional []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		switch r.URL.Path {
+		case "/etag":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Mon, 05 Jan 2026 10:00:00 GMT")
+		}
+		w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	cl, err := New(&Config{
+		AllowedDomains: []string{u.Hostname()},
+		Conditional:    true,
+		StoragePath:    filepath.Join(t.TempDir(), "colly.db"),
+		CachePath:      filepath.Join(t.TempDir(), "cache"),
+		ThreadCount:    1,
+		RequestTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cl.collector.WithTransport(&cacheTransport{
+		cache: cl.cache,
+		next:  &conditionalTransport{lookup: cl.Validators, next: srv.Client().Transport},
+	})
+	cl.collector.AllowURLRevisit = true
+
+	var (
+		statuses  []int
+		unchanged []bool
+	)
+	cl.collector.OnResponse(func(r *colly.Response) {
+		statuses = append(statuses, r.StatusCode)
+		same, err := cl.Unchanged(r)
+		if err != nil {
+			t.Errorf("Unchanged: %v", err)
+		}
+		unchanged = append(unchanged, same)
+		if err := cl.RecordValidators(r); err != nil {
+			t.Errorf("RecordValidators: %v", err)
+		}
+	})
+	cl.collector.OnError(func(r *colly.Response, err error) {
+		statuses = append(statuses, r.StatusCode)
+	})
+
+	visit := func(path string) {
+		t.Helper()
+		cl.collector.Visit(srv.URL + path)
+		if err := cl.ClearCache(&colly.Request{URL: &url.URL{Scheme: "https", Host: u.Host, Path: path}}); err != nil {
+			t.Fatalf("ClearCache: %v", err)
+		}
+	}
+	visit("/etag")
+	visit("/etag")
+	visit("/plain")
+	visit("/plain")
+
+	want := []string{"|", `"v1"|Mon, 05 Jan 2026 10:00:00 GMT`, "|", "|"}
+	for i, got := range conditional {
+		if got != want[i] {
+			t.Errorf("request %d sent validators %q, want %q", i, got, want[i])
+		}
+	}
+	if len(statuses) != 4 || statuses[1] != http.StatusNotModified {
+		t.Fatalf("expected a 304 for the second etag request, got %v", statuses)
+	}
+	if len(unchanged) != 3 || unchanged[0] || unchanged[1] || !unchanged[2] {
+		t.Errorf("expected only the repeated plain page to be unchanged, got %v", unchanged)
+	}
+
+	if err := cl.ForgetValidators(srv.URL + "/etag"); err != nil {
+		t.Fatalf("ForgetValidators: %v", err)
+	}
+	if v, err := cl.Validators(srv.URL + "/etag"); err != nil || v != nil {
+		t.Errorf("expected no validators after forgetting them, got %+v, %v", v, err)
+	}
+}
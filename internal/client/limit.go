@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxBackoff caps the extra pause added after 429 and 5xx responses.
+	maxBackoff = 5 * time.Minute
+	// minBackoff is the first backoff step when Delay is shorter.
+	minBackoff = time.Second
+	// recoverAfter is how many successful responses in a row halve the
+	// backoff again.
+	recoverAfter = 20
+)
+
+// limitTransport paces network requests the same way colly's LimitRule does:
+// one request in flight at a time, followed by Delay plus up to RandomDelay of
+// jitter. It sits below the cache so that cache hits are not delayed.
+//
+// The pace adapts to the server: a 429 or 5xx response doubles a backoff added
+// on top of Delay, with jitter, and a Retry-After header is honoured as the
+// least pause before the next request. Sustained success halves the backoff
+// until the pace is back to Delay, which stays the minimum.
+//
+// Unlike LimitRule the pause is taken before the next request rather than
+// after the current one. The request timeout is also applied here, once the
+// slot is acquired, because http.Client.Timeout would include the time spent
+// waiting for other requests.
+type limitTransport struct {
+	next        http.RoundTripper
+	delay       time.Duration
+	randomDelay time.Duration
+	timeout     time.Duration
+	slot        chan struct{}
+	ready       time.Time     // guarded by slot
+	backoff     time.Duration // guarded by slot
+	successes   int           // guarded by slot; successful responses since the last backoff change
+}
+
+func newLimitTransport(next http.RoundTripper, delay, randomDelay, timeout time.Duration) *limitTransport {
+	return &limitTransport{
+		next:        next,
+		delay:       delay,
+		randomDelay: randomDelay,
+		timeout:     timeout,
+		slot:        make(chan struct{}, 1),
+	}
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slot <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-t.slot }()
+
+	if wait := time.Until(t.ready); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	now := time.Now()
+	t.ready = now.Add(t.adapt(req, resp, now))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request timeout once the body has been consumed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// adapt updates the backoff from a response, nil on transport errors, and
+// returns the pause before the next request. Retry-After is capped at
+// maxBackoff so that a bogus header cannot stall the run.
+func (t *limitTransport) adapt(req *http.Request, resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return t.pause()
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		t.successes = 0
+		t.backoff = min(max(2*t.backoff, t.delay, minBackoff), maxBackoff)
+		pause := t.pause() + rand.N(t.backoff/2+1)
+		retryAfter := min(parseRetryAfter(resp.Header.Get("Retry-After"), now), maxBackoff)
+		pause = max(pause, retryAfter)
+
+		log.WithFields(log.Fields{
+			"backoff":     t.backoff,
+			"pause":       pause,
+			"retry_after": retryAfter,
+			"status_code": resp.StatusCode,
+			"url":         req.URL.String(),
+		}).Warn("server asked to slow down, backing off")
+		return pause
+	}
+
+	if t.backoff > 0 {
+		t.successes++
+		if t.successes >= recoverAfter {
+			t.successes = 0
+			t.backoff /= 2
+			if t.backoff < minBackoff {
+				t.backoff = 0
+			}
+			log.WithField("backoff", t.backoff).Debug("requests succeeding, speeding back up")
+		}
+	}
+	return t.pause()
+}
+
+// pause returns Delay plus the current backoff and up to RandomDelay of jitter.
+func (t *limitTransport) pause() time.Duration {
+	jitter := time.Duration(0)
+	if t.randomDelay > 0 {
+		jitter = rand.N(t.randomDelay)
+	}
+	return t.delay + t.backoff + jitter
+}
+
+// parseRetryAfter returns the wait a Retry-After header asks for, given in
+// seconds or as an HTTP date, or zero.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func limitResponse(statusCode int, retryAfter string) *http.Response {
+	resp := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestLimitTransportAdapts(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://guide.michelin.com/", nil)
+	now := time.Now()
+
+	t.Run("backs off exponentially on 429 and 5xx", func(t *testing.T) {
+		lt := newLimitTransport(nil, 2*time.Second, 0, time.Minute)
+		for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+			pause := lt.adapt(req, limitResponse(http.StatusServiceUnavailable, ""), now)
+			if lt.backoff != want {
+				t.Fatalf("expected backoff %v, got %v", want, lt.backoff)
+			}
+			if pause < lt.delay+want || pause > lt.delay+want+want/2 {
+				t.Errorf("pause %v outside delay plus jittered backoff %v", pause, want)
+			}
+		}
+		for range 10 {
+			lt.adapt(req, limitResponse(http.StatusTooManyRequests, ""), now)
+		}
+		if lt.backoff != maxBackoff {
+			t.Errorf("expected backoff capped at %v, got %v", maxBackoff, lt.backoff)
+		}
+	})
+
+	t.Run("honours Retry-After", func(t *testing.T) {
+		lt := newLimitTransport(nil, 0, 0, time.Minute)
+		if pause := lt.adapt(req, limitResponse(http.StatusTooManyRequests, "30"), now); pause != 30*time.Second {
+			t.Errorf("expected a 30s pause, got %v", pause)
+		}
+		date := now.Add(time.Minute).UTC().Format(http.TimeFormat)
+		if pause := lt.adapt(req, limitResponse(http.StatusTooManyRequests, date), now); pause < 59*time.Second || pause > time.Minute {
+			t.Errorf("expected about a minute's pause, got %v", pause)
+		}
+		if pause := lt.adapt(req, limitResponse(http.StatusTooManyRequests, "86400"), now); pause != maxBackoff {
+			t.Errorf("expected Retry-After capped at %v, got %v", maxBackoff, pause)
+		}
+	})
+
+	t.Run("speeds back up to the delay after sustained success", func(t *testing.T) {
+		lt := newLimitTransport(nil, time.Second, 0, time.Minute)
+		lt.adapt(req, limitResponse(http.StatusTooManyRequests, ""), now)
+		lt.adapt(req, limitResponse(http.StatusTooManyRequests, ""), now)
+		if lt.backoff != 2*time.Second {
+			t.Fatalf("expected backoff 2s, got %v", lt.backoff)
+		}
+
+		for range recoverAfter {
+			lt.adapt(req, limitResponse(http.StatusOK, ""), now)
+		}
+		if lt.backoff != time.Second {
+			t.Errorf("expected backoff halved to 1s, got %v", lt.backoff)
+		}
+		for range recoverAfter {
+			lt.adapt(req, limitResponse(http.StatusOK, ""), now)
+		}
+		if pause := lt.adapt(req, limitResponse(http.StatusOK, ""), now); lt.backoff != 0 || pause != lt.delay {
+			t.Errorf("expected the pace back at the delay, got backoff %v pause %v", lt.backoff, pause)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	} {
+		if got := parseRetryAfter(tc.value, now); got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
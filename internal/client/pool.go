@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoActiveSession is returned for requests made while every session in the
+// pool is rotated out.
+var ErrNoActiveSession = errors.New("no active session in the pool")
+
+// SessionRefresher returns new cookies for the named session, e.g. by logging
+// in again.
+type SessionRefresher func(ctx context.Context, name string) ([]*http.Cookie, error)
+
+// SessionStats reports how a pooled session has been used.
+type SessionStats struct {
+	Name      string
+	Requests  int64
+	Rotations int // times rotated out on a 202 or 429
+	Active    bool
+}
+
+// poolSession is one cookie set of the pool with its own jar, so cookies the
+// site rotates on every response stay with the session that received them.
+type poolSession struct {
+	name      string
+	jar       http.CookieJar
+	requests  atomic.Int64
+	active    bool // guarded by sessionPool.mu
+	rotations int  // guarded by sessionPool.mu
+	gen       int  // guarded by sessionPool.mu; bumped when the session is replaced
+}
+
+// sessionPool assigns requests to sessions round-robin. A session that gets a
+// 202 or 429 is rotated out and refreshed in the background.
+type sessionPool struct {
+	mu       sync.Mutex
+	sessions []*poolSession
+	next     int
+	domains  []string
+	store    *cookiestore.Store
+	refresh  SessionRefresher
+}
+
+// newSessionPool creates a pool from stored sessions, ordered by name.
+func newSessionPool(store *cookiestore.Store, domains []string, sessions map[string][]*http.Cookie) (*sessionPool, error) {
+	p := &sessionPool{domains: domains, store: store}
+	for _, name := range slices.Sorted(maps.Keys(sessions)) {
+		jar, err := p.newJar(sessions[name])
+		if err != nil {
+			return nil, err
+		}
+		p.sessions = append(p.sessions, &poolSession{name: name, jar: jar, active: true})
+	}
+	return p, nil
+}
+
+// newJar returns a jar seeded with cookies for every allowed domain.
+func (p *sessionPool) newJar(cookies []*http.Cookie) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	seedJar(jar, p.domains, cookies)
+	return jar, nil
+}
+
+// acquire returns the next active session and its generation.
+func (p *sessionPool) acquire() (*poolSession, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for range p.sessions {
+		s := p.sessions[p.next%len(p.sessions)]
+		p.next++
+		if s.active {
+			return s, s.gen, nil
+		}
+	}
+	return nil, 0, ErrNoActiveSession
+}
+
+// rotateOut takes a session out of rotation and refreshes it in the
+// background. Responses from before the last refresh are ignored.
+func (p *sessionPool) rotateOut(s *poolSession, gen, statusCode int) {
+	p.mu.Lock()
+	if !s.active || s.gen != gen {
+		p.mu.Unlock()
+		return
+	}
+	s.active = false
+	s.rotations++
+	refresh := p.refresh
+	active := p.activeLocked()
+	p.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"active_sessions": active,
+		"session":         s.name,
+		"status_code":     statusCode,
+	}).Warn("session rotated out")
+
+	if refresh == nil {
+		return
+	}
+	go p.relogin(s, refresh)
+}
+
+// relogin replaces the cookies of a rotated out session and puts it back.
+func (p *sessionPool) relogin(s *poolSession, refresh SessionRefresher) {
+	fields := log.Fields{"session": s.name}
+	cookies, err := refresh(context.Background(), s.name)
+	if err != nil {
+		log.WithFields(fields).WithError(err).Error("failed to refresh session")
+		return
+	}
+	if err := p.replace(s.name, cookies); err != nil {
+		log.WithFields(fields).WithError(err).Error("failed to store refreshed session")
+		return
+	}
+	log.WithFields(fields).Info("session refreshed")
+}
+
+// replace swaps in new cookies for the named session, persisting them, and
+// makes it active again.
+func (p *sessionPool) replace(name string, cookies []*http.Cookie) error {
+	if err := p.store.Save(name, cookies); err != nil {
+		return err
+	}
+	jar, err := p.newJar(cookies)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions {
+		if s.name == name {
+			s.jar = jar
+			s.active = true
+			s.gen++
+		}
+	}
+	return nil
+}
+
+// jar returns the current jar of a session.
+func (p *sessionPool) jar(s *poolSession) http.CookieJar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return s.jar
+}
+
+func (p *sessionPool) activeLocked() int {
+	n := 0
+	for _, s := range p.sessions {
+		if s.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *sessionPool) stats() []SessionStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]SessionStats, len(p.sessions))
+	for i, s := range p.sessions {
+		out[i] = SessionStats{
+			Name:      s.name,
+			Requests:  s.requests.Load(),
+			Rotations: s.rotations,
+			Active:    s.active,
+		}
+	}
+	return out
+}
+
+// sessionTransport sends each request with the cookies of a pooled session
+// and keeps the cookies the response sets in that session.
+type sessionTransport struct {
+	pool *sessionPool
+	next http.RoundTripper
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s, gen, err := t.pool.acquire()
+	if err != nil {
+		return nil, err
+	}
+	jar := t.pool.jar(s)
+
+	// The session name lets a sticky proxy pool keep the session on one proxy.
+	req = req.Clone(context.WithValue(req.Context(), sessionKey{}, s.name))
+	req.Header.Del("Cookie")
+	for _, c := range jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+
+	s.requests.Add(1)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		jar.SetCookies(req.URL, cookies)
+	}
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusTooManyRequests {
+		t.pool.rotateOut(s, gen, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// seedJar adds the stored cookies to jar for each allowed domain.
+func seedJar(jar http.CookieJar, domains []string, cookies []*http.Cookie) {
+	for _, domain := range domains {
+		jar.SetCookies(&url.URL{Scheme: "https", Host: domain}, cookies)
+	}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
+)
+
+func TestSessionPoolRotatesAndRefreshes(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := r.Cookie("session")
+		mu.Lock()
+		sent = append(sent, c.Value)
+		mu.Unlock()
+		if c.Value == "bob" {
+			w.WriteHeader(http.StatusAccepted) // bob's session has expired
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	dir := t.TempDir()
+	store, err := cookiestore.New(filepath.Join(dir, "cookies.enc"), testCookieKey)
+	if err != nil {
+		t.Fatalf("cookiestore.New: %v", err)
+	}
+	if err := store.Replace(map[string][]*http.Cookie{
+		"alice": sessionCookies("session", "alice"),
+		"bob":   sessionCookies("session", "bob"),
+	}); err != nil {
+		t.Fatalf("store.Replace: %v", err)
+	}
+
+	cl, err := New(&Config{
+		AllowedDomains: []string{u.Hostname()},
+		CookiePath:     filepath.Join(dir, "cookies.enc"),
+		CookieKey:      testCookieKey,
+		StoragePath:    filepath.Join(dir, "colly.db"),
+		ThreadCount:    1,
+		RequestTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if cl.pool == nil {
+		t.Fatal("expected a session pool for two stored sessions")
+	}
+	cl.collector.WithTransport(&sessionTransport{pool: cl.pool, next: srv.Client().Transport})
+
+	refreshed := make(chan string, 1)
+	cl.SetSessionRefresher(func(ctx context.Context, name string) ([]*http.Cookie, error) {
+		refreshed <- name
+		return sessionCookies("session", name+"-2"), nil
+	})
+
+	visit := func(i int) error {
+		return cl.collector.Visit(fmt.Sprintf("%s/%d", srv.URL, i))
+	}
+	for i := range 3 {
+		if err := visit(i); err != nil {
+			t.Fatalf("Visit %d: %v", i, err)
+		}
+	}
+	select {
+	case name := <-refreshed:
+		if name != "bob" {
+			t.Fatalf("expected bob to be refreshed, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the rotated out session to be refreshed")
+	}
+	for deadline := time.Now().Add(time.Second); !cl.SessionStats()[1].Active; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected bob to be back in rotation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := visit(3); err != nil {
+		t.Fatalf("Visit 3: %v", err)
+	}
+
+	want := []string{"alice", "bob", "alice", "bob-2"}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("expected sessions %v, got %v", want, sent)
+	}
+	stats := cl.SessionStats()
+	if stats[0].Requests != 2 || stats[1].Requests != 2 || stats[1].Rotations != 1 || stats[0].Rotations != 0 {
+		t.Errorf("unexpected session stats: %+v", stats)
+	}
+	if stored, _ := store.Load("bob"); len(stored) != 1 || stored[0].Value != "bob-2" {
+		t.Errorf("expected the refreshed session to be stored, got %+v", stored)
+	}
+}
+
+func TestSessionPoolRunsOutOfSessions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	pool, err := newSessionPool(nil, []string{u.Hostname()}, map[string][]*http.Cookie{
+		"alice": sessionCookies("session", "alice"),
+		"bob":   sessionCookies("session", "bob"),
+	})
+	if err != nil {
+		t.Fatalf("newSessionPool: %v", err)
+	}
+	client := &http.Client{Transport: &sessionTransport{pool: pool, next: srv.Client().Transport}}
+
+	for range 2 {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrNoActiveSession) {
+		t.Fatalf("expected ErrNoActiveSession once every session is rotated out, got %v", err)
+	}
+}
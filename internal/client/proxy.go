@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Proxy selection modes for Config.ProxyMode.
+const (
+	// ProxyRoundRobin sends each request through the next healthy proxy.
+	ProxyRoundRobin = "round-robin"
+	// ProxySticky keeps each pooled session on one proxy while it is healthy,
+	// so a session is not seen coming from several addresses.
+	ProxySticky = "sticky"
+)
+
+const (
+	// proxyMaxFailures is how many failed requests in a row eject a proxy.
+	proxyMaxFailures = 3
+	// proxyEjectFor is how long an ejected proxy sits out. It doubles each
+	// time the proxy is ejected again without a success in between.
+	proxyEjectFor = time.Minute
+	proxyMaxEject = 30 * time.Minute
+)
+
+// ProxyModes lists the accepted proxy modes, the default first.
+var ProxyModes = []string{ProxyRoundRobin, ProxySticky}
+
+// proxySchemes are the proxy URL schemes http.Transport supports.
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// ErrNoHealthyProxy is returned for requests made while every proxy is
+// ejected.
+var ErrNoHealthyProxy = errors.New("every proxy is ejected")
+
+// ProxyStats reports how a proxy has performed.
+type ProxyStats struct {
+	URL        string // with the password redacted
+	Requests   int64
+	Failures   int64
+	AvgLatency time.Duration // over successful requests
+	Ejections  int
+	Healthy    bool
+}
+
+// proxyState tracks the health of one proxy. All fields are guarded by
+// proxyPool.mu.
+type proxyState struct {
+	url          *url.URL
+	requests     int64
+	failures     int64
+	successes    int64
+	latency      time.Duration // total over successful requests
+	consecutive  int           // failures since the last success
+	ejections    int
+	ejectStreak  int // ejections since the last success
+	ejectedUntil time.Time
+}
+
+func (s *proxyState) healthy(now time.Time) bool {
+	return !now.Before(s.ejectedUntil)
+}
+
+// proxyPool picks proxies round-robin, or sticky per session, skipping the
+// ones ejected for failing.
+type proxyPool struct {
+	mu       sync.Mutex
+	proxies  []*proxyState
+	next     int
+	sticky   bool
+	assigned map[string]*proxyState // by session name, in sticky mode
+	slow     time.Duration          // responses slower than this count as failures
+	now      func() time.Time
+}
+
+// newProxyPool parses a comma-separated list of proxy URLs.
+func newProxyPool(list, mode string, slow time.Duration) (*proxyPool, error) {
+	if mode == "" {
+		mode = ProxyRoundRobin
+	}
+	if !slices.Contains(ProxyModes, mode) {
+		return nil, fmt.Errorf("invalid proxy mode %q: want one of %s", mode, strings.Join(ProxyModes, ", "))
+	}
+
+	p := &proxyPool{
+		sticky:   mode == ProxySticky,
+		assigned: make(map[string]*proxyState),
+		slow:     slow,
+		now:      time.Now,
+	}
+	for _, raw := range strings.Split(list, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+		}
+		if !slices.Contains(proxySchemes, u.Scheme) || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q: want %s://host:port", u.Redacted(), strings.Join(proxySchemes, "|"))
+		}
+		p.proxies = append(p.proxies, &proxyState{url: u})
+	}
+	if len(p.proxies) == 0 {
+		return nil, colly.ErrEmptyProxyURL
+	}
+	return p, nil
+}
+
+// pick returns the proxy for a request of the named session.
+func (p *proxyPool) pick(session string) (*proxyState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if s, ok := p.assigned[session]; ok && p.sticky && s.healthy(now) {
+		return s, nil
+	}
+	for range p.proxies {
+		s := p.proxies[p.next%len(p.proxies)]
+		p.next++
+		if s.healthy(now) {
+			if p.sticky {
+				p.assigned[session] = s
+			}
+			return s, nil
+		}
+	}
+	return nil, ErrNoHealthyProxy
+}
+
+// report records the outcome of a request through s, ejecting it after
+// proxyMaxFailures failures in a row.
+func (p *proxyPool) report(s *proxyState, latency time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s.requests++
+	if !failed {
+		s.successes++
+		s.latency += latency
+		s.consecutive = 0
+		s.ejectStreak = 0
+		return
+	}
+
+	s.failures++
+	s.consecutive++
+	if s.consecutive < proxyMaxFailures {
+		return
+	}
+	s.consecutive = 0
+	s.ejections++
+	s.ejectStreak++
+	ejectFor := min(proxyEjectFor<<(s.ejectStreak-1), proxyMaxEject)
+	s.ejectedUntil = p.now().Add(ejectFor)
+
+	log.WithFields(log.Fields{
+		"eject_for": ejectFor,
+		"failures":  s.failures,
+		"proxy":     s.url.Redacted(),
+		"requests":  s.requests,
+	}).Warn("proxy ejected")
+}
+
+func (p *proxyPool) stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	out := make([]ProxyStats, len(p.proxies))
+	for i, s := range p.proxies {
+		out[i] = ProxyStats{
+			URL:       s.url.Redacted(),
+			Requests:  s.requests,
+			Failures:  s.failures,
+			Ejections: s.ejections,
+			Healthy:   s.healthy(now),
+		}
+		if s.successes > 0 {
+			out[i].AvgLatency = s.latency / time.Duration(s.successes)
+		}
+	}
+	return out
+}
+
+type (
+	proxyKey   struct{}
+	sessionKey struct{}
+)
+
+// proxyTransport picks a proxy for each request and tracks how it does. The
+// request goes out through an http.Transport whose Proxy is proxyFromContext.
+type proxyTransport struct {
+	pool *proxyPool
+	next http.RoundTripper
+}
+
+// newProxyTransport returns a proxyTransport over a copy of
+// http.DefaultTransport.
+func newProxyTransport(pool *proxyPool) *proxyTransport {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = proxyFromContext
+	return &proxyTransport{pool: pool, next: base}
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	session, _ := req.Context().Value(sessionKey{}).(string)
+	s, err := t.pool.pick(session)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, s.url))
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	latency := time.Since(start)
+	if errors.Is(err, context.Canceled) {
+		return nil, err // shutting down, not the proxy's fault
+	}
+
+	// Only what the proxy itself does wrong counts against it. A 429 or 5xx
+	// comes from the site, through every proxy alike, and is left to the
+	// limiter and the retry policy; ejecting proxies for it would take them
+	// all out during an outage.
+	failed := err != nil || latency > t.pool.slow
+	t.pool.report(s, latency, failed)
+	return resp, err
+}
+
+// proxyFromContext is the colly.ProxyFunc of the proxied transport: it
+// returns the proxy proxyTransport picked for the request.
+var proxyFromContext colly.ProxyFunc = func(req *http.Request) (*url.URL, error) {
+	u, _ := req.Context().Value(proxyKey{}).(*url.URL)
+	return u, nil
+}
+
+// ProxyStats returns how each configured proxy has performed, or nil when
+// requests go out directly.
+func (w *Colly) ProxyStats() []ProxyStats {
+	if w.proxies == nil {
+		return nil
+	}
+	return w.proxies.stats()
+}
+
+// LogProxyUsage logs the stats of each configured proxy at the end of a run.
+func (w *Colly) LogProxyUsage() {
+	for _, stats := range w.ProxyStats() {
+		log.WithFields(log.Fields{
+			"avg_latency": stats.AvgLatency,
+			"ejections":   stats.Ejections,
+			"failures":    stats.Failures,
+			"healthy":     stats.Healthy,
+			"proxy":       stats.URL,
+			"requests":    stats.Requests,
+		}).Info("proxy usage")
+	}
+}
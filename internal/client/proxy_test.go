@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxyStandIn answers proxied requests itself with status, recording which
+// proxy each request went through. With status 0 it drops the connection
+// instead.
+func proxyStandIn(t *testing.T, name string, status int, mu *sync.Mutex, via *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() || r.URL.Host != "guide.test" {
+			t.Errorf("expected an absolute-form request for guide.test, got %s", r.URL)
+		}
+		mu.Lock()
+		*via = append(*via, name)
+		mu.Unlock()
+		if status == 0 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxiesRotateAndEjectUnhealthyOnes(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		via []string
+	)
+	good := proxyStandIn(t, "good", http.StatusOK, &mu, &via)
+	bad := proxyStandIn(t, "bad", 0, &mu, &via)
+
+	cl, err := New(&Config{
+		AllowedDomains: []string{"guide.test"},
+		Proxies:        good.URL + ", " + bad.URL,
+		StoragePath:    filepath.Join(t.TempDir(), "colly.db"),
+		ThreadCount:    1,
+		RequestTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := range 8 {
+		cl.collector.Visit(fmt.Sprintf("http://guide.test/%d", i))
+	}
+
+	want := []string{"good", "bad", "good", "bad", "good", "bad", "good", "good"}
+	if fmt.Sprint(via) != fmt.Sprint(want) {
+		t.Errorf("expected requests via %v, got %v", want, via)
+	}
+	stats := cl.ProxyStats()
+	if stats[0].Requests != 5 || stats[0].Failures != 0 || !stats[0].Healthy || stats[0].AvgLatency <= 0 {
+		t.Errorf("unexpected stats for the good proxy: %+v", stats[0])
+	}
+	if stats[1].Requests != 3 || stats[1].Failures != 3 || stats[1].Ejections != 1 || stats[1].Healthy {
+		t.Errorf("unexpected stats for the bad proxy: %+v", stats[1])
+	}
+
+	// Once the ejection is over the proxy is tried again.
+	cl.proxies.now = func() time.Time { return time.Now().Add(proxyEjectFor) }
+	if s, err := cl.proxies.pick(""); err != nil || s != cl.proxies.proxies[1] {
+		t.Errorf("expected the bad proxy back in rotation, got %v, %v", s, err)
+	}
+}
+
+func TestProxiesAreNotEjectedForServerErrors(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		via []string
+	)
+	busy := proxyStandIn(t, "busy", http.StatusTooManyRequests, &mu, &via)
+	down := proxyStandIn(t, "down", http.StatusServiceUnavailable, &mu, &via)
+
+	pool, err := newProxyPool(busy.URL+","+down.URL, "", time.Second)
+	if err != nil {
+		t.Fatalf("newProxyPool: %v", err)
+	}
+	client := &http.Client{Transport: newProxyTransport(pool)}
+	for range 2 * proxyMaxFailures {
+		resp, err := client.Get("http://guide.test/")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	for _, stats := range pool.stats() {
+		if stats.Failures != 0 || !stats.Healthy {
+			t.Errorf("expected %s to stay healthy, got %+v", stats.URL, stats)
+		}
+	}
+}
+
+func TestProxyPool(t *testing.T) {
+	t.Run("sticky keeps each session on one proxy", func(t *testing.T) {
+		pool, err := newProxyPool("http://a:3128,socks5://user:secret@b:1080", ProxySticky, time.Second)
+		if err != nil {
+			t.Fatalf("newProxyPool: %v", err)
+		}
+		alice, _ := pool.pick("alice")
+		bob, _ := pool.pick("bob")
+		if alice == bob {
+			t.Fatal("expected the sessions to be spread over both proxies")
+		}
+		for range 3 {
+			if s, _ := pool.pick("alice"); s != alice {
+				t.Fatalf("expected alice to stay on %s, got %s", alice.url, s.url)
+			}
+		}
+
+		for range proxyMaxFailures {
+			pool.report(alice, time.Millisecond, true)
+		}
+		if s, _ := pool.pick("alice"); s != bob {
+			t.Errorf("expected alice to move off the ejected proxy, got %s", s.url)
+		}
+		if got := pool.stats()[1].URL; got != "socks5://user:xxxxx@b:1080" {
+			t.Errorf("expected the password redacted, got %s", got)
+		}
+	})
+
+	t.Run("ejections back off and run out of proxies", func(t *testing.T) {
+		pool, err := newProxyPool("http://a:3128", "", time.Second)
+		if err != nil {
+			t.Fatalf("newProxyPool: %v", err)
+		}
+		now := time.Now()
+		pool.now = func() time.Time { return now }
+		s, _ := pool.pick("")
+		for range proxyMaxFailures {
+			pool.report(s, time.Millisecond, true)
+		}
+		if _, err := pool.pick(""); err != ErrNoHealthyProxy {
+			t.Fatalf("expected ErrNoHealthyProxy, got %v", err)
+		}
+		if s.ejectedUntil != now.Add(proxyEjectFor) {
+			t.Errorf("expected a first ejection of %v, got %v", proxyEjectFor, s.ejectedUntil.Sub(now))
+		}
+		for range proxyMaxFailures {
+			pool.report(s, time.Millisecond, true)
+		}
+		if s.ejectedUntil != now.Add(2*proxyEjectFor) {
+			t.Errorf("expected a second ejection of %v, got %v", 2*proxyEjectFor, s.ejectedUntil.Sub(now))
+		}
+	})
+
+	t.Run("rejects invalid configuration", func(t *testing.T) {
+		for _, tc := range []struct{ list, mode string }{
+			{"", ""},
+			{"a:3128", ""},
+			{"ftp://a:21", ""},
+			{"http://a:3128", "random"},
+		} {
+			if _, err := newProxyPool(tc.list, tc.mode, time.Second); err == nil {
+				t.Errorf("expected an error for proxies %q and mode %q", tc.list, tc.mode)
+			}
+		}
+	})
+}
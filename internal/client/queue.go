@@ -0,0 +1,308 @@
+package client
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	whatwgUrl "github.com/nlnwa/whatwg-url/url"
+)
+
+// Names of the runs sharing the crawl storage, for Config.Run.
+const (
+	RunScrape   = "scrape"
+	RunBackfill = "backfill"
+)
+
+// Runs lists the accepted run names.
+var Runs = []string{RunScrape, RunBackfill}
+
+// QueueStats summarises the crawl state held in colly storage.
+type QueueStats struct {
+	Pending int
+	Visited int
+	Failed  int
+}
+
+// QueueItem is a pending request in the crawl queue.
+type QueueItem struct {
+	ID       int64
+	URL      string
+	Method   string
+	Location string
+}
+
+// FailedRequest is a request that exhausted its retries, kept in the failed
+// (dead-letter) table.
+type FailedRequest struct {
+	ID         int64
+	URL        string
+	Location   string
+	Run        string // the run that recorded it, RunScrape or RunBackfill
+	Kind       string
+	StatusCode int
+	Error      string
+	FailedAt   time.Time
+}
+
+// openStorage opens the handle the client shares for its own tables in the
+// colly storage file, and makes sure the failed and validators tables exist.
+// It holds a single connection, so the client's writes queue up behind each
+// other rather than contend with colly's for the database lock.
+func openStorage(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS failed (
+		id INTEGER PRIMARY KEY,
+		url TEXT NOT NULL,
+		location TEXT,
+		status_code INT,
+		error TEXT,
+		failed_at DATETIME
+	)`)
+	if err == nil {
+		err = addFailedKind(db)
+	}
+	if err == nil {
+		err = addFailedRun(db)
+	}
+	if err == nil {
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS validators (
+			url TEXT PRIMARY KEY,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			body_hash TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME
+		)`)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// addFailedKind adds the kind column to failed tables created before it.
+func addFailedKind(db *sql.DB) error {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('failed') WHERE name = 'kind'").Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE failed ADD COLUMN kind TEXT NOT NULL DEFAULT ''")
+	return err
+}
+
+// addFailedRun adds the run column to failed tables created before it. The
+// existing rows are assigned to a run by host: only the scraper requests the
+// Michelin Guide itself.
+func addFailedRun(db *sql.DB) error {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('failed') WHERE name = 'run'").Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	if _, err := db.Exec("ALTER TABLE failed ADD COLUMN run TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"UPDATE failed SET run = CASE WHEN url LIKE 'https://guide.michelin.com/%' THEN ? ELSE ? END",
+		RunScrape, RunBackfill,
+	)
+	return err
+}
+
+// QueueStats returns the number of pending, visited and failed requests.
+func (w *Colly) QueueStats() (*QueueStats, error) {
+	stats := &QueueStats{}
+	counts := []struct {
+		table string
+		dest  *int
+	}{
+		{"queue", &stats.Pending},
+		{"visited", &stats.Visited},
+		{"failed", &stats.Failed},
+	}
+	for _, c := range counts {
+		if err := w.db.QueryRow("SELECT COUNT(*) FROM " + c.table).Scan(c.dest); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %w", c.table, err)
+		}
+	}
+	return stats, nil
+}
+
+// ListQueue returns up to limit pending requests in the order they will be
+// dispatched. A non-positive limit returns every pending request.
+func (w *Colly) ListQueue(limit int) ([]QueueItem, error) {
+	if limit <= 0 {
+		limit = -1 // sqlite treats a negative LIMIT as no limit
+	}
+	rows, err := w.db.Query("SELECT id, data FROM queue ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []QueueItem
+	for rows.Next() {
+		var (
+			id   int64
+			data []byte
+		)
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		r, err := w.collector.UnmarshalRequest(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode queued request %d: %w", id, err)
+		}
+		items = append(items, QueueItem{
+			ID:       id,
+			URL:      r.URL.String(),
+			Method:   r.Method,
+			Location: r.Ctx.Get("location"),
+		})
+	}
+	return items, rows.Err()
+}
+
+// ClearQueue removes every pending request and returns how many were dropped.
+func (w *Colly) ClearQueue() (int64, error) {
+	res, err := w.db.Exec("DELETE FROM queue")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RecordFailure stores a request that exhausted its retries, under the run
+// set in Config.Run, so it can later be re-enqueued with RetryFailed.
+func (w *Colly) RecordFailure(r *colly.Request, kind ErrorKind, statusCode int, reqErr error) error {
+	msg := ""
+	if reqErr != nil {
+		msg = reqErr.Error()
+	}
+	_, err := w.db.Exec(
+		"INSERT INTO failed (url, location, run, kind, status_code, error, failed_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		r.URL.String(), r.Ctx.Get("location"), w.config.Run, kind.String(), statusCode, msg, time.Now().UTC(),
+	)
+	return err
+}
+
+// ListFailed returns every request recorded by RecordFailure.
+func (w *Colly) ListFailed() ([]FailedRequest, error) {
+	rows, err := w.db.Query("SELECT id, url, location, run, kind, status_code, error, failed_at FROM failed ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []FailedRequest
+	for rows.Next() {
+		var f FailedRequest
+		if err := rows.Scan(&f.ID, &f.URL, &f.Location, &f.Run, &f.Kind, &f.StatusCode, &f.Error, &f.FailedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// RetryFailed moves the failed requests of a run back onto the queue and
+// returns how many were re-enqueued. The queue is shared, so only the matching
+// run should drain it next. Their visited rows are removed as well, otherwise
+// the queue would drop them as already visited.
+func (w *Colly) RetryFailed(run string) (int, error) {
+	if !slices.Contains(Runs, run) {
+		return 0, fmt.Errorf("invalid run %q: want one of %s", run, strings.Join(Runs, ", "))
+	}
+	all, err := w.ListFailed()
+	if err != nil {
+		return 0, err
+	}
+	var failed []FailedRequest
+	for _, f := range all {
+		if f.Run == run {
+			failed = append(failed, f)
+		}
+	}
+
+	for i, f := range failed {
+		if err := w.ForgetVisited(f.URL); err != nil {
+			return i, err
+		}
+		if err := w.EnqueueURLWithContext(f.URL, f.Location); err != nil {
+			return i, err
+		}
+		if err := w.deleteFailed(f.ID); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(failed), nil
+}
+
+func (w *Colly) deleteFailed(id int64) error {
+	_, err := w.db.Exec("DELETE FROM failed WHERE id = ?", id)
+	return err
+}
+
+// ForgetVisited removes the visited row for a GET request to rawURL so that
+// it can be dispatched again.
+func (w *Colly) ForgetVisited(rawURL string) error {
+	_, err := w.db.Exec("DELETE FROM visited WHERE requestID = ?", visitedID(rawURL))
+	return err
+}
+
+// Requeue puts a request back at the end of the crawl queue, keeping its
+// location, e.g. after it was rate limited. It returns how many times the
+// request has been re-queued, including this time.
+func (w *Colly) Requeue(r *colly.Request) (int, error) {
+	requeued, _ := strconv.Atoi(r.Ctx.Get("requeued"))
+	requeued++
+	if err := w.ForgetVisited(r.URL.String()); err != nil {
+		return requeued, err
+	}
+
+	ctx := colly.NewContext()
+	ctx.Put("location", r.Ctx.Get("location"))
+	// Context values go through JSON in the queue, so the count is kept as a
+	// string rather than an int that would come back as a float64.
+	ctx.Put("requeued", strconv.Itoa(requeued))
+	data, err := (&colly.Request{URL: r.URL, Method: "GET", Ctx: ctx}).Marshal()
+	if err != nil {
+		return requeued, err
+	}
+	return requeued, w.storage.AddRequest(data)
+}
+
+// urlParser is the WHATWG URL parser colly normalizes request URLs with.
+var urlParser = whatwgUrl.NewParser(whatwgUrl.WithPercentEncodeSinglePercentSign())
+
+// visitedID mirrors colly's requestHash for body-less GET requests, which is
+// the key colly stores in the visited table. Like colly's scrape, it parses
+// the URL with the WHATWG parser and net/url before requestHash normalizes it
+// once more, e.g. adding the trailing slash of a bare host.
+func visitedID(rawURL string) int64 {
+	if u, err := urlParser.Parse(rawURL); err == nil {
+		if u, err := url.Parse(u.Href(false)); err == nil {
+			rawURL = u.String()
+		}
+	}
+	if u, err := urlParser.Parse(rawURL); err == nil {
+		rawURL = u.String()
+	}
+	h := fnv.New64a()
+	io.WriteString(h, rawURL)
+	// sqlite3 storage casts the uint64 hash to int64 before storing it
+	return int64(h.Sum64())
+}
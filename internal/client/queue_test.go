@@ -0,0 +1,293 @@
+package client
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func newTestClient(t *testing.T) *Colly {
+	t.Helper()
+	cl, err := New(&Config{
+		StoragePath: filepath.Join(t.TempDir(), "colly.db"),
+		ThreadCount: 1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return cl
+}
+
+func TestQueueListAndClear(t *testing.T) {
+	cl := newTestClient(t)
+
+	urls := []string{
+		"https://guide.michelin.com/sg/en/restaurant/a",
+		"https://guide.michelin.com/sg/en/restaurant/b",
+		"https://guide.michelin.com/sg/en/restaurant/c",
+	}
+	for _, u := range urls {
+		if err := cl.EnqueueURLWithContext(u, "Singapore"); err != nil {
+			t.Fatalf("EnqueueURLWithContext: %v", err)
+		}
+	}
+
+	items, err := cl.ListQueue(2)
+	if err != nil {
+		t.Fatalf("ListQueue: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("ListQueue(2) returned %d items", len(items))
+	}
+	if items[0].URL != urls[0] || items[0].Location != "Singapore" || items[0].Method != "GET" {
+		t.Errorf("unexpected first item: %+v", items[0])
+	}
+
+	all, err := cl.ListQueue(0)
+	if err != nil {
+		t.Fatalf("ListQueue: %v", err)
+	}
+	if len(all) != len(urls) {
+		t.Fatalf("ListQueue(0) returned %d items, want %d", len(all), len(urls))
+	}
+
+	removed, err := cl.ClearQueue()
+	if err != nil {
+		t.Fatalf("ClearQueue: %v", err)
+	}
+	if removed != int64(len(urls)) {
+		t.Errorf("ClearQueue removed %d, want %d", removed, len(urls))
+	}
+
+	stats, err := cl.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if stats.Pending != 0 {
+		t.Errorf("pending = %d after clear, want 0", stats.Pending)
+	}
+}
+
+func TestForgetVisitedMatchesCollyHash(t *testing.T) {
+	cl := newTestClient(t)
+	target := "https://guide.michelin.com/sg/en/restaurant/a"
+
+	id := visitedID(target)
+	if err := cl.storage.Visited(uint64(id)); err != nil {
+		t.Fatalf("Visited: %v", err)
+	}
+
+	visited, err := cl.collector.HasVisited(target)
+	if err != nil {
+		t.Fatalf("HasVisited: %v", err)
+	}
+	if !visited {
+		t.Fatalf("colly does not recognise visitedID(%q) as visited", target)
+	}
+
+	if err := cl.ForgetVisited(target); err != nil {
+		t.Fatalf("ForgetVisited: %v", err)
+	}
+	visited, err = cl.collector.HasVisited(target)
+	if err != nil {
+		t.Fatalf("HasVisited: %v", err)
+	}
+	if visited {
+		t.Errorf("expected %q to be forgotten", target)
+	}
+}
+
+func TestForgetVisitedRemovesRowsWrittenByVisit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// URLs that net/url and colly's WHATWG parser write differently.
+	targets := []string{
+		srv.URL,
+		srv.URL + "/restaurant/a b",
+		srv.URL + "/restaurant/100%",
+		srv.URL + "/restaurant/./a/../b",
+	}
+	for _, target := range targets {
+		t.Run(target, func(t *testing.T) {
+			cl := newTestClient(t)
+			if err := cl.collector.Visit(target); err != nil {
+				t.Fatalf("Visit: %v", err)
+			}
+			if err := cl.ForgetVisited(target); err != nil {
+				t.Fatalf("ForgetVisited: %v", err)
+			}
+
+			var rows int
+			if err := cl.db.QueryRow("SELECT COUNT(*) FROM visited").Scan(&rows); err != nil {
+				t.Fatalf("count visited: %v", err)
+			}
+			if rows != 0 {
+				t.Errorf("expected the visited row of %q to be removed, %d left", target, rows)
+			}
+		})
+	}
+}
+
+func TestRetryFailedRequeuesOnlyTheGivenRun(t *testing.T) {
+	storagePath := filepath.Join(t.TempDir(), "colly.db")
+	newClient := func(run string) *Colly {
+		cl, err := New(&Config{StoragePath: storagePath, Run: run, ThreadCount: 1})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return cl
+	}
+	cl, backfill := newClient(RunScrape), newClient(RunBackfill)
+	target := "https://guide.michelin.com/sg/en/restaurant/a"
+
+	request := func(rawURL string) *colly.Request {
+		u, _ := url.Parse(rawURL)
+		ctx := colly.NewContext()
+		ctx.Put("location", "Singapore")
+		return &colly.Request{URL: u, Method: "GET", Ctx: ctx}
+	}
+
+	if err := cl.storage.Visited(uint64(visitedID(target))); err != nil {
+		t.Fatalf("Visited: %v", err)
+	}
+	if err := cl.RecordFailure(request(target), KindTransient, 500, errors.New("Internal Server Error")); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+	snapshot := "https://web.archive.org/web/2024id_/" + target
+	if err := backfill.RecordFailure(request(snapshot), KindTransient, 503, errors.New("Service Unavailable")); err != nil {
+		t.Fatalf("RecordFailure: %v", err)
+	}
+
+	stats, err := cl.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if stats.Failed != 2 || stats.Visited != 1 || stats.Pending != 0 {
+		t.Fatalf("unexpected stats before retry: %+v", stats)
+	}
+
+	if _, err := cl.RetryFailed("wayback"); err == nil {
+		t.Fatal("expected an unknown run to be rejected")
+	}
+	n, err := cl.RetryFailed(RunScrape)
+	if err != nil {
+		t.Fatalf("RetryFailed: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("RetryFailed requeued %d, want 1", n)
+	}
+
+	stats, err = cl.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if stats.Failed != 1 || stats.Visited != 0 || stats.Pending != 1 {
+		t.Fatalf("unexpected stats after retry: %+v", stats)
+	}
+
+	items, err := cl.ListQueue(0)
+	if err != nil {
+		t.Fatalf("ListQueue: %v", err)
+	}
+	if items[0].URL != target || items[0].Location != "Singapore" {
+		t.Errorf("unexpected requeued item: %+v", items[0])
+	}
+	failed, err := cl.ListFailed()
+	if err != nil {
+		t.Fatalf("ListFailed: %v", err)
+	}
+	if len(failed) != 1 || failed[0].URL != snapshot || failed[0].Run != RunBackfill {
+		t.Errorf("expected the backfill failure to be kept, got %+v", failed)
+	}
+}
+
+func TestFailedRowsFromBeforeRunsAreAssignedByHost(t *testing.T) {
+	storagePath := filepath.Join(t.TempDir(), "colly.db")
+	db, err := sql.Open("sqlite3", storagePath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE failed (
+		id INTEGER PRIMARY KEY,
+		url TEXT NOT NULL,
+		location TEXT,
+		status_code INT,
+		error TEXT,
+		failed_at DATETIME
+	);
+	INSERT INTO failed (url, location, status_code, error, failed_at) VALUES
+		('https://guide.michelin.com/sg/en/restaurant/a', 'Singapore', 500, '', '2026-01-01 00:00:00'),
+		('https://web.archive.org/cdx/search/cdx?url=guide.michelin.com', '', 503, '', '2026-01-01 00:00:00')`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("create old failed table: %v", err)
+	}
+
+	cl, err := New(&Config{StoragePath: storagePath, ThreadCount: 1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	failed, err := cl.ListFailed()
+	if err != nil {
+		t.Fatalf("ListFailed: %v", err)
+	}
+	if len(failed) != 2 || failed[0].Run != RunScrape || failed[1].Run != RunBackfill {
+		t.Errorf("unexpected runs for old failed rows: %+v", failed)
+	}
+}
+
+func TestRequeueKeepsLocationAndCountsRequeues(t *testing.T) {
+	cl := newTestClient(t)
+	target := "https://guide.michelin.com/sg/en/restaurant/a"
+
+	u, _ := url.Parse(target)
+	ctx := colly.NewContext()
+	ctx.Put("location", "Singapore")
+	req := &colly.Request{URL: u, Method: "GET", Ctx: ctx}
+
+	if err := cl.storage.Visited(uint64(visitedID(target))); err != nil {
+		t.Fatalf("Visited: %v", err)
+	}
+	n, err := cl.Requeue(req)
+	if err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Requeue returned %d, want 1", n)
+	}
+
+	stats, err := cl.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if stats.Visited != 0 || stats.Pending != 1 {
+		t.Fatalf("unexpected stats after requeue: %+v", stats)
+	}
+	items, err := cl.ListQueue(0)
+	if err != nil {
+		t.Fatalf("ListQueue: %v", err)
+	}
+	if items[0].URL != target || items[0].Location != "Singapore" {
+		t.Errorf("unexpected requeued item: %+v", items[0])
+	}
+
+	// The count travels through the queue with the request.
+	data, err := cl.storage.GetRequest()
+	if err != nil {
+		t.Fatalf("GetRequest: %v", err)
+	}
+	queued, err := cl.collector.UnmarshalRequest(data)
+	if err != nil {
+		t.Fatalf("UnmarshalRequest: %v", err)
+	}
+	if n, err := cl.Requeue(queued); err != nil || n != 2 {
+		t.Errorf("expected the second requeue to count 2, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorKind classifies a failed request for a RetryPolicy.
+type ErrorKind int
+
+const (
+	// KindTransient is any failure worth retrying, e.g. a 5xx or a
+	// transport error.
+	KindTransient ErrorKind = iota
+	// KindAlreadyVisited means colly dropped the request as a revisit.
+	KindAlreadyVisited
+	// KindCanceled means the run is shutting down.
+	KindCanceled
+	// KindPermanent is a status a retry will not change, e.g. 404.
+	KindPermanent
+	// KindRateLimited means the server wants fewer requests.
+	KindRateLimited
+	// KindSessionExpired means the session cookies no longer work.
+	KindSessionExpired
+	// KindNotModified is a 304 to a conditional request. It is not a
+	// failure; the caller handles it.
+	KindNotModified
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindAlreadyVisited:
+		return "already_visited"
+	case KindCanceled:
+		return "canceled"
+	case KindPermanent:
+		return "permanent"
+	case KindRateLimited:
+		return "rate_limited"
+	case KindSessionExpired:
+		return "session_expired"
+	case KindNotModified:
+		return "not_modified"
+	default:
+		return "transient"
+	}
+}
+
+// RetryAction is what a RetryPolicy decides to do with a failed request.
+type RetryAction int
+
+const (
+	// RetrySkip drops the request.
+	RetrySkip RetryAction = iota
+	// RetryNow sends the request again straight away. The limiter paces it.
+	RetryNow
+	// RetryRequeue puts the request back at the end of the crawl queue.
+	RetryRequeue
+	// RetryDeadLetter gives up on the request and records it in the failed
+	// table, from where `mym queue retry-failed` can re-enqueue it.
+	RetryDeadLetter
+	// RetryAbort means the run cannot go on, e.g. every session has expired.
+	// The caller decides how to stop.
+	RetryAbort
+)
+
+func (a RetryAction) String() string {
+	switch a {
+	case RetryNow:
+		return "retry"
+	case RetryRequeue:
+		return "requeue"
+	case RetryDeadLetter:
+		return "dead_letter"
+	case RetryAbort:
+		return "abort"
+	default:
+		return "skip"
+	}
+}
+
+// RetryPolicy decides how failed requests are handled. Status codes not listed
+// are transient.
+type RetryPolicy struct {
+	MaxRetry       int
+	Permanent      []int // status codes not worth retrying
+	RateLimited    []int // status codes asking for fewer requests
+	SessionExpired []int // status codes sent for expired session cookies
+	// Requeue puts rate limited requests back on the crawl queue, up to
+	// MaxRetry times, instead of retrying them in place. Only set it for
+	// requests that come from the queue.
+	Requeue bool
+}
+
+// ScraperRetryPolicy is the policy for the live Michelin Guide scraper, where
+// a 202 means the session has expired.
+func ScraperRetryPolicy(maxRetry int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetry:       maxRetry,
+		Permanent:      []int{http.StatusNotFound},
+		RateLimited:    []int{http.StatusTooManyRequests},
+		SessionExpired: []int{http.StatusAccepted},
+	}
+}
+
+// BackfillRetryPolicy is the policy for the Wayback Machine backfill. A 403
+// there typically means the site owner has blocked archiving.
+func BackfillRetryPolicy(maxRetry int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetry:    maxRetry,
+		Permanent:   []int{http.StatusForbidden, http.StatusNotFound},
+		RateLimited: []int{http.StatusTooManyRequests},
+	}
+}
+
+// Classify returns the kind of a failure from its status code, 0 when no
+// response was received, and error.
+func (p RetryPolicy) Classify(statusCode int, err error) ErrorKind {
+	var visited *colly.AlreadyVisitedError
+	switch {
+	case errors.As(err, &visited):
+		return KindAlreadyVisited
+	case errors.Is(err, context.Canceled):
+		return KindCanceled
+	case statusCode == http.StatusNotModified:
+		return KindNotModified
+	case errors.Is(err, ErrNoActiveSession), slices.Contains(p.SessionExpired, statusCode):
+		return KindSessionExpired
+	case slices.Contains(p.RateLimited, statusCode):
+		return KindRateLimited
+	case slices.Contains(p.Permanent, statusCode):
+		return KindPermanent
+	default:
+		return KindTransient
+	}
+}
+
+// Decide returns the action for a failure of the given kind. attempt counts
+// from 1, requeued is how many times the request went back on the queue, and
+// activeSession reports whether a pooled session is left to retry with.
+func (p RetryPolicy) Decide(kind ErrorKind, attempt, requeued int, activeSession bool) RetryAction {
+	switch kind {
+	case KindAlreadyVisited, KindCanceled, KindPermanent, KindNotModified:
+		return RetrySkip
+	case KindSessionExpired:
+		if attempt < p.MaxRetry && activeSession {
+			return RetryNow
+		}
+		return RetryAbort
+	case KindRateLimited:
+		if attempt < p.MaxRetry && activeSession {
+			return RetryNow
+		}
+		if p.Requeue {
+			if requeued < p.MaxRetry {
+				return RetryRequeue
+			}
+			return RetryDeadLetter
+		}
+	}
+	if attempt < p.MaxRetry {
+		return RetryNow
+	}
+	return RetryDeadLetter
+}
+
+// HandleError applies the policy to a failed response, err being nil for
+// responses colly does not treat as errors such as a 202. It carries out
+// every action but RetryAbort, which it returns for the caller to act on.
+func (w *Colly) HandleError(p RetryPolicy, r *colly.Response, err error) RetryAction {
+	attempt := 1
+	if a, ok := r.Ctx.GetAny("attempt").(int); ok {
+		attempt = a
+	}
+	requeued, _ := strconv.Atoi(r.Ctx.Get("requeued"))
+	kind := p.Classify(r.StatusCode, err)
+	action := p.Decide(kind, attempt, requeued, w.HasActiveSession())
+
+	fields := log.Fields{
+		"action":       action.String(),
+		"attempt":      attempt,
+		"cookie_count": len(w.GetCookies(r.Request.URL.String())),
+		"kind":         kind.String(),
+		"status_code":  r.StatusCode,
+		"url":          r.Request.URL,
+	}
+	entry := log.WithFields(fields)
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+
+	switch action {
+	case RetrySkip:
+		entry.Debug("failed request, skip retry")
+	case RetryNow:
+		w.clearCacheFor(r, fields)
+		entry.Debug("failed request, retrying")
+		r.Ctx.Put("attempt", attempt+1)
+		if err := r.Request.Retry(); err != nil {
+			log.WithFields(fields).WithError(err).Error("failed to retry request")
+		}
+	case RetryRequeue:
+		w.clearCacheFor(r, fields)
+		n, err := w.Requeue(r.Request)
+		if err != nil {
+			log.WithFields(fields).WithError(err).Error("failed to re-queue request")
+			break
+		}
+		entry.WithField("requeued", n).Warn("failed request, re-queued")
+	case RetryDeadLetter:
+		entry.WithField("request_headers", utils.FlattenHeaders(r.Request.Headers)).Error("failed request, max retries reached")
+		if err := w.RecordFailure(r.Request, kind, r.StatusCode, err); err != nil {
+			log.WithFields(fields).WithError(err).Warn("failed to record failed request")
+		}
+	case RetryAbort:
+		entry.Error("failed request, cannot continue")
+	}
+	return action
+}
+
+// clearCacheFor drops a cached copy of a failed response so a retry goes to
+// the network.
+func (w *Colly) clearCacheFor(r *colly.Response, fields log.Fields) {
+	if err := w.ClearCache(r.Request); err != nil {
+		log.WithFields(fields).WithError(err).Warn("failed to clear cache")
+	}
+}
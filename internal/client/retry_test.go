@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestRetryPolicyClassify(t *testing.T) {
+	scraper := ScraperRetryPolicy(3)
+	backfill := BackfillRetryPolicy(3)
+	visited := &colly.AlreadyVisitedError{Destination: &url.URL{Host: "guide.michelin.com"}}
+
+	for _, tc := range []struct {
+		policy     RetryPolicy
+		statusCode int
+		err        error
+		want       ErrorKind
+	}{
+		{scraper, 0, visited, KindAlreadyVisited},
+		{scraper, 0, fmt.Errorf("visit: %w", context.Canceled), KindCanceled},
+		{scraper, 0, ErrNoActiveSession, KindSessionExpired},
+		{scraper, http.StatusAccepted, nil, KindSessionExpired},
+		{scraper, http.StatusTooManyRequests, errors.New("Too Many Requests"), KindRateLimited},
+		{scraper, http.StatusNotFound, errors.New("Not Found"), KindPermanent},
+		{scraper, http.StatusForbidden, errors.New("Forbidden"), KindTransient},
+		{scraper, http.StatusBadGateway, errors.New("Bad Gateway"), KindTransient},
+		{scraper, 0, errors.New("connection reset by peer"), KindTransient},
+		{scraper, http.StatusNotModified, errors.New("Not Modified"), KindNotModified},
+		{backfill, http.StatusForbidden, errors.New("Forbidden"), KindPermanent},
+		{backfill, http.StatusAccepted, nil, KindTransient},
+	} {
+		if got := tc.policy.Classify(tc.statusCode, tc.err); got != tc.want {
+			t.Errorf("Classify(%d, %v) = %v, want %v", tc.statusCode, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicyDecide(t *testing.T) {
+	policy := ScraperRetryPolicy(3)
+	requeuing := policy
+	requeuing.Requeue = true
+
+	for _, tc := range []struct {
+		name          string
+		policy        RetryPolicy
+		kind          ErrorKind
+		attempt       int
+		requeued      int
+		activeSession bool
+		want          RetryAction
+	}{
+		{"already visited", policy, KindAlreadyVisited, 1, 0, false, RetrySkip},
+		{"canceled", policy, KindCanceled, 1, 0, false, RetrySkip},
+		{"permanent", policy, KindPermanent, 1, 0, false, RetrySkip},
+		{"not modified", policy, KindNotModified, 3, 0, false, RetrySkip},
+		{"transient", policy, KindTransient, 2, 0, false, RetryNow},
+		{"transient past max retries", policy, KindTransient, 3, 0, false, RetryDeadLetter},
+		{"session expired with another session", policy, KindSessionExpired, 1, 0, true, RetryNow},
+		{"session expired without a pool", policy, KindSessionExpired, 1, 0, false, RetryAbort},
+		{"session expired past max retries", policy, KindSessionExpired, 3, 0, true, RetryAbort},
+		{"rate limited with another session", requeuing, KindRateLimited, 1, 0, true, RetryNow},
+		{"rate limited, requeued", requeuing, KindRateLimited, 1, 2, false, RetryRequeue},
+		{"rate limited past max requeues", requeuing, KindRateLimited, 1, 3, false, RetryDeadLetter},
+		{"rate limited, retried in place", policy, KindRateLimited, 1, 0, false, RetryNow},
+		{"rate limited past max retries", policy, KindRateLimited, 3, 0, false, RetryDeadLetter},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.Decide(tc.kind, tc.attempt, tc.requeued, tc.activeSession); got != tc.want {
+				t.Errorf("Decide = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandleErrorRequeuesAndDeadLetters(t *testing.T) {
+	cl := newTestClient(t)
+	policy := ScraperRetryPolicy(2)
+	policy.Requeue = true
+
+	response := func(rawURL string, statusCode int) *colly.Response {
+		u, _ := url.Parse(rawURL)
+		ctx := colly.NewContext()
+		ctx.Put("location", "Singapore")
+		ctx.Put("attempt", 2)
+		return &colly.Response{StatusCode: statusCode, Ctx: ctx, Request: &colly.Request{URL: u, Method: "GET", Ctx: ctx}}
+	}
+
+	limited := response("https://guide.michelin.com/sg/en/restaurant/a", http.StatusTooManyRequests)
+	if got := cl.HandleError(policy, limited, errors.New("Too Many Requests")); got != RetryRequeue {
+		t.Fatalf("expected the rate limited request to be re-queued, got %v", got)
+	}
+	failed := response("https://guide.michelin.com/sg/en/restaurant/b", http.StatusBadGateway)
+	if got := cl.HandleError(policy, failed, errors.New("Bad Gateway")); got != RetryDeadLetter {
+		t.Fatalf("expected the failed request to be dead-lettered, got %v", got)
+	}
+
+	stats, err := cl.QueueStats()
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if stats.Pending != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	dead, err := cl.ListFailed()
+	if err != nil {
+		t.Fatalf("ListFailed: %v", err)
+	}
+	if dead[0].URL != failed.Request.URL.String() || dead[0].Kind != "transient" || dead[0].StatusCode != http.StatusBadGateway || dead[0].Location != "Singapore" {
+		t.Errorf("unexpected dead letter: %+v", dead[0])
+	}
+}
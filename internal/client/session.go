@@ -0,0 +1,122 @@
+package client
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/gocolly/colly/v2/extensions"
+)
+
+// SessionCookies returns the cookies of the named session in the cookie
+// store, as persisted by login. Unlike GetCookies, these keep their domain
+// and expiry.
+func (w *Colly) SessionCookies(name string) ([]*http.Cookie, error) {
+	if w.cookies == nil {
+		return nil, errors.New("no cookie store configured")
+	}
+	return w.cookies.Load(name)
+}
+
+// SetSessions replaces every session in the cookie store and updates the
+// running client. Plaintext cookies left in colly.db by earlier versions are
+// removed.
+func (w *Colly) SetSessions(sessions map[string][]*http.Cookie) error {
+	if w.cookies == nil {
+		return errors.New("no cookie store configured")
+	}
+	if err := w.cookies.Replace(sessions); err != nil {
+		return err
+	}
+
+	if w.pool != nil {
+		for name, cookies := range sessions {
+			if err := w.pool.replace(name, cookies); err != nil {
+				return err
+			}
+		}
+	} else {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return err
+		}
+		for _, cookies := range sessions {
+			seedJar(jar, w.config.AllowedDomains, cookies)
+		}
+		w.collector.SetCookieJar(jar)
+	}
+	return w.clearPlaintextCookies()
+}
+
+// SetSessionRefresher sets how pooled sessions are refreshed after being
+// rotated out. Without one, a rotated out session stays out for the run.
+func (w *Colly) SetSessionRefresher(refresh SessionRefresher) {
+	if w.pool == nil {
+		return
+	}
+	w.pool.mu.Lock()
+	defer w.pool.mu.Unlock()
+	w.pool.refresh = refresh
+}
+
+// HasActiveSession reports whether requests rotate over a session pool that
+// still has a session to send them with. It is false without a pool, where an
+// expired session needs a new login.
+func (w *Colly) HasActiveSession() bool {
+	if w.pool == nil {
+		return false
+	}
+	w.pool.mu.Lock()
+	defer w.pool.mu.Unlock()
+	return w.pool.activeLocked() > 0
+}
+
+// SessionStats returns per-session request counts of the pool, or nil
+// without one.
+func (w *Colly) SessionStats() []SessionStats {
+	if w.pool == nil {
+		return nil
+	}
+	return w.pool.stats()
+}
+
+// clearPlaintextCookies empties the cookies table of colly.db.
+func (w *Colly) clearPlaintextCookies() error {
+	db, err := sql.Open("sqlite3", w.config.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("DELETE FROM cookies")
+	return err
+}
+
+// Probe fetches rawURL once with the session cookies and returns the response
+// status code. The request bypasses the response cache and is not recorded as
+// visited, so it never hides a page from a later crawl.
+func (w *Colly) Probe(rawURL string) (int, error) {
+	c := w.collector.Clone()
+	c.AllowURLRevisit = true
+	extensions.RandomUserAgent(c)
+
+	statusCode := 0
+	c.OnResponse(func(r *colly.Response) {
+		statusCode = r.StatusCode
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		statusCode = r.StatusCode
+	})
+
+	header := http.Header{}
+	header.Set("Cache-Control", "no-cache")
+	err := c.Request(http.MethodGet, rawURL, nil, nil, header)
+	if statusCode != 0 {
+		return statusCode, nil
+	}
+	if err == nil {
+		err = errors.New("no response")
+	}
+	return 0, err
+}
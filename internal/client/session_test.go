@@ -0,0 +1,79 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
+)
+
+func TestSessionCookiesAndProbe(t *testing.T) {
+	requests := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if c, err := r.Cookie("michelin_session"); err != nil || c.Value != "abc123" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	expires := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+	cookiePath := filepath.Join(t.TempDir(), "cookies.enc")
+	store, err := cookiestore.New(cookiePath, testCookieKey)
+	if err != nil {
+		t.Fatalf("cookiestore.New: %v", err)
+	}
+	if err := store.Save(cookiestore.DefaultSession, []*http.Cookie{
+		{Name: "michelin_session", Value: "abc123", Domain: u.Hostname(), Expires: expires},
+	}); err != nil {
+		t.Fatalf("store.Save: %v", err)
+	}
+
+	cl, err := New(&Config{
+		AllowedDomains: []string{u.Hostname()},
+		CookiePath:     cookiePath,
+		CookieKey:      testCookieKey,
+		StoragePath:    filepath.Join(t.TempDir(), "colly.db"),
+		CachePath:      filepath.Join(t.TempDir(), "cache"),
+		ThreadCount:    1,
+		RequestTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cl.collector.WithTransport(&cacheTransport{cache: cl.cache, next: srv.Client().Transport})
+
+	cookies, err := cl.SessionCookies(cookiestore.DefaultSession)
+	if err != nil {
+		t.Fatalf("SessionCookies: %v", err)
+	}
+	if len(cookies) != 1 || cookies[0].Name != "michelin_session" || !cookies[0].Expires.Equal(expires) {
+		t.Fatalf("expected the stored cookie with its expiry, got %+v", cookies)
+	}
+
+	// Probing twice must reach the server twice: neither the cache nor the
+	// visited table may answer for it.
+	for range 2 {
+		status, err := cl.Probe(srv.URL + "/restaurants")
+		if err != nil {
+			t.Fatalf("Probe: %v", err)
+		}
+		if status != http.StatusOK {
+			t.Fatalf("expected 200 with a valid session, got %d", status)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests to reach the server, got %d", requests)
+	}
+	if err := cl.collector.Visit(srv.URL + "/restaurants"); err != nil {
+		t.Fatalf("probe should not mark the url visited: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+// Package cookiestore persists named session cookie sets in a file encrypted
+// with AES-GCM, kept apart from colly.db so sessions do not travel with the
+// queue and visited table.
+package cookiestore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KeyEnv     = "MYM_COOKIE_KEY"
+	KeyFileEnv = "MYM_COOKIE_KEY_FILE"
+
+	// KeySize is the AES-256 key size in bytes.
+	KeySize = 32
+
+	// DefaultSession names the session of a single account login.
+	DefaultSession = "default"
+
+	// magic prefixes every store file and is authenticated with the payload.
+	magic = "mymcookies1"
+)
+
+// ErrMissingKey is returned when no encryption key is configured.
+var ErrMissingKey = fmt.Errorf("no cookie encryption key: set %s or %s to a %d-byte key, base64 or hex encoded (e.g. openssl rand -base64 %d)", KeyEnv, KeyFileEnv, KeySize, KeySize)
+
+// Store reads and writes the encrypted cookie file.
+type Store struct {
+	mu   sync.Mutex // serializes read-modify-write of the file
+	path string
+	aead cipher.AEAD
+}
+
+// storedCookie is the serialized form of an http.Cookie; http.Cookie itself
+// has fields that do not survive JSON.
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Expires  time.Time `json:"expires,omitzero"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+}
+
+// New returns a Store for the file at path, encrypted with key.
+func New(path string, key []byte) (*Store, error) {
+	if len(key) == 0 {
+		return nil, ErrMissingKey
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("cookie encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: path, aead: aead}, nil
+}
+
+// KeyFromEnv reads the encryption key from MYM_COOKIE_KEY, or from the file
+// named by MYM_COOKIE_KEY_FILE. It returns ErrMissingKey when neither is set.
+func KeyFromEnv() ([]byte, error) {
+	if v := os.Getenv(KeyEnv); v != "" {
+		return ParseKey(v)
+	}
+	if path := os.Getenv(KeyFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cookie key file: %w", err)
+		}
+		if len(data) == KeySize {
+			return data, nil
+		}
+		return ParseKey(string(data))
+	}
+	return nil, ErrMissingKey
+}
+
+// ParseKey decodes a base64 or hex encoded key.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("cookie encryption key must be %d bytes, base64 or hex encoded", KeySize)
+}
+
+// Load returns the cookies of the named session, or none if it has not been
+// saved.
+func (s *Store) Load(name string) ([]*http.Cookie, error) {
+	sessions, err := s.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	return sessions[name], nil
+}
+
+// LoadAll returns the cookies of every stored session by name.
+func (s *Store) LoadAll() (map[string][]*http.Cookie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[string][]*http.Cookie, len(stored))
+	for name, cookies := range stored {
+		sessions[name] = make([]*http.Cookie, len(cookies))
+		for i, c := range cookies {
+			sessions[name][i] = &http.Cookie{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   c.Domain,
+				Path:     c.Path,
+				Expires:  c.Expires,
+				Secure:   c.Secure,
+				HttpOnly: c.HttpOnly,
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// Sessions returns the names of the stored sessions, sorted.
+func (s *Store) Sessions() ([]string, error) {
+	sessions, err := s.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(sessions)), nil
+}
+
+// Save replaces the cookies of the named session, keeping the others.
+func (s *Store) Save(name string, cookies []*http.Cookie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+	stored[name] = toStored(cookies)
+	return s.write(stored)
+}
+
+// Replace replaces every stored session with sessions.
+func (s *Store) Replace(sessions map[string][]*http.Cookie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := make(map[string][]storedCookie, len(sessions))
+	for name, cookies := range sessions {
+		stored[name] = toStored(cookies)
+	}
+	return s.write(stored)
+}
+
+// toStored converts cookies to their serialized form.
+func toStored(cookies []*http.Cookie) []storedCookie {
+	stored := make([]storedCookie, len(cookies))
+	for i, c := range cookies {
+		stored[i] = storedCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+	}
+	return stored
+}
+
+// read decrypts the file; a missing file holds no sessions.
+func (s *Store) read() (map[string][]storedCookie, error) {
+	stored := map[string][]storedCookie{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stored, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookie store: %w", err)
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < len(magic)+nonceSize || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%s is not a cookie store", s.path)
+	}
+	nonce := data[len(magic) : len(magic)+nonceSize]
+	plain, err := s.aead.Open(nil, nonce, data[len(magic)+nonceSize:], []byte(magic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt cookie store, wrong key?: %w", err)
+	}
+	if err := json.Unmarshal(plain, &stored); err != nil {
+		// Stores written before named sessions hold a single cookie list.
+		var single []storedCookie
+		if json.Unmarshal(plain, &single) != nil {
+			return nil, fmt.Errorf("failed to decode cookie store: %w", err)
+		}
+		stored = map[string][]storedCookie{DefaultSession: single}
+	}
+	return stored, nil
+}
+
+// write encrypts stored to a temporary file and renames it over the store,
+// so a crash never leaves a half-written store.
+func (s *Store) write(stored map[string][]storedCookie) error {
+	plain, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := append([]byte(magic), nonce...)
+	data = s.aead.Seal(data, nonce, plain, []byte(magic))
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".cookies-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cookie store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cookie store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cookie store: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
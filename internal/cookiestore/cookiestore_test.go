@@ -0,0 +1,126 @@
+package cookiestore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "cookies.enc")
+	store, err := New(path, testKey)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	cookies, err := store.Load(DefaultSession)
+	if err != nil || cookies != nil {
+		t.Fatalf("expected no cookies before the first save, got %v, %v", cookies, err)
+	}
+
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := []*http.Cookie{
+		{Name: "session_id", Value: "secret-session", Domain: ".guide.michelin.com", Path: "/", Expires: expires, Secure: true, HttpOnly: true},
+		{Name: "locale", Value: "en", Domain: "guide.michelin.com"},
+	}
+	if err := store.Save(DefaultSession, want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read store: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-session")) {
+		t.Fatal("cookie value stored in plaintext")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	got, err := store.Load(DefaultSession)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(got) != 2 || got[0].Value != "secret-session" || !got[0].Expires.Equal(expires) || !got[0].HttpOnly || got[1].Name != "locale" {
+		t.Fatalf("unexpected cookies after round trip: %+v", got)
+	}
+
+	other, _ := New(path, bytes.Repeat([]byte{1}, KeySize))
+	if _, err := other.Load(DefaultSession); err == nil {
+		t.Error("expected decrypting with the wrong key to fail")
+	}
+}
+
+func TestStoreKeepsNamedSessions(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "cookies.enc"), testKey)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, name := range []string{"bob", "alice"} {
+		if err := store.Save(name, []*http.Cookie{{Name: "session_id", Value: name}}); err != nil {
+			t.Fatalf("Save %s: %v", name, err)
+		}
+	}
+
+	names, err := store.Sessions()
+	if err != nil || len(names) != 2 || names[0] != "alice" || names[1] != "bob" {
+		t.Fatalf("expected sessions [alice bob], got %v, %v", names, err)
+	}
+	bob, _ := store.Load("bob")
+	if len(bob) != 1 || bob[0].Value != "bob" {
+		t.Fatalf("saving alice should keep bob, got %+v", bob)
+	}
+
+	if err := store.Replace(map[string][]*http.Cookie{"carol": {{Name: "session_id", Value: "carol"}}}); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if names, _ := store.Sessions(); len(names) != 1 || names[0] != "carol" {
+		t.Fatalf("expected only carol after Replace, got %v", names)
+	}
+}
+
+func TestNewRequiresKey(t *testing.T) {
+	if _, err := New("cookies.enc", nil); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("expected ErrMissingKey, got %v", err)
+	}
+	if _, err := New("cookies.enc", []byte("short")); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
+
+func TestKeyFromEnv(t *testing.T) {
+	t.Setenv(KeyEnv, "")
+	t.Setenv(KeyFileEnv, "")
+	if _, err := KeyFromEnv(); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("expected ErrMissingKey, got %v", err)
+	}
+
+	t.Setenv(KeyEnv, base64.StdEncoding.EncodeToString(testKey))
+	if key, err := KeyFromEnv(); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("base64 key: got %x, %v", key, err)
+	}
+
+	t.Setenv(KeyEnv, "")
+	keyFile := filepath.Join(t.TempDir(), "cookie.key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(testKey)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(KeyFileEnv, keyFile)
+	if key, err := KeyFromEnv(); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("hex key file: got %x, %v", key, err)
+	}
+
+	t.Setenv(KeyEnv, "not-a-key")
+	if _, err := KeyFromEnv(); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
@@ -0,0 +1,199 @@
+// Package dedupe finds restaurants stored more than once, under URLs that
+// differ by slug, locale or region path.
+package dedupe
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+)
+
+// DefaultMaxDistance is how close, in meters, two restaurants must be for
+// their coordinates to count as the same place.
+const DefaultMaxDistance = 150.0
+
+const (
+	ReasonURL      = "url"      // same URL once the locale prefix is dropped
+	ReasonName     = "name"     // same name, ignoring case and punctuation
+	ReasonPhone    = "phone"    // same phone number digits
+	ReasonDistance = "distance" // coordinates within the maximum distance
+)
+
+// Group is a set of restaurants that appear to be the same one. Canonical is
+// the one to keep: the restaurant with the most recent award.
+type Group struct {
+	Canonical  models.Restaurant
+	Duplicates []models.Restaurant
+	Reasons    []string
+}
+
+// Find groups restaurants that match on URL, or that lie within maxDistance
+// meters of each other and share a name or phone number. Proximity is always
+// required without a URL match, as branches of a chain can share both a name
+// and a central phone number. Restaurants must have their awards loaded.
+func Find(restaurants []models.Restaurant, maxDistance float64) []Group {
+	parent := make([]int, len(restaurants))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+
+	// Every rule needs the URL, name or phone to match, so only restaurants
+	// sharing one of them are compared.
+	buckets := map[string][]int{}
+	for i, r := range restaurants {
+		key := "url:" + parsers.URLKey(r.URL)
+		buckets[key] = append(buckets[key], i)
+		if name := normalizeName(r.Name); name != "" {
+			buckets["name:"+name] = append(buckets["name:"+name], i)
+		}
+		if phone := normalizePhone(r.PhoneNumber); phone != "" {
+			buckets["phone:"+phone] = append(buckets["phone:"+phone], i)
+		}
+	}
+
+	reasons := map[int]map[string]bool{}
+	for _, members := range buckets {
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				a, b := members[x], members[y]
+				matched := match(&restaurants[a], &restaurants[b], maxDistance)
+				if matched == nil {
+					continue
+				}
+				ra, rb := root(a), root(b)
+				parent[rb] = ra
+				if reasons[ra] == nil {
+					reasons[ra] = map[string]bool{}
+				}
+				for _, reason := range matched {
+					reasons[ra][reason] = true
+				}
+				for reason := range reasons[rb] {
+					reasons[ra][reason] = true
+				}
+			}
+		}
+	}
+
+	members := map[int][]models.Restaurant{}
+	for i := range restaurants {
+		members[root(i)] = append(members[root(i)], restaurants[i])
+	}
+
+	var groups []Group
+	for r, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		slices.SortFunc(group, compareCanonical)
+		g := Group{Canonical: group[0], Duplicates: group[1:]}
+		for reason := range reasons[root(r)] {
+			g.Reasons = append(g.Reasons, reason)
+		}
+		slices.Sort(g.Reasons)
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b Group) int { return cmp.Compare(a.Canonical.ID, b.Canonical.ID) })
+	return groups
+}
+
+// match returns why a and b look like the same restaurant, or nil.
+func match(a, b *models.Restaurant, maxDistance float64) []string {
+	var reasons []string
+	if parsers.URLKey(a.URL) == parsers.URLKey(b.URL) {
+		reasons = append(reasons, ReasonURL)
+	}
+	name := normalizeName(a.Name) != "" && normalizeName(a.Name) == normalizeName(b.Name)
+	if name {
+		reasons = append(reasons, ReasonName)
+	}
+	phone := normalizePhone(a.PhoneNumber) != "" && normalizePhone(a.PhoneNumber) == normalizePhone(b.PhoneNumber)
+	if phone {
+		reasons = append(reasons, ReasonPhone)
+	}
+	d, ok := distance(a, b)
+	near := ok && d <= maxDistance
+	if near {
+		reasons = append(reasons, ReasonDistance)
+	}
+
+	if slices.Contains(reasons, ReasonURL) || (near && (name || phone)) {
+		return reasons
+	}
+	return nil
+}
+
+// compareCanonical orders the restaurants of a group so that the one to keep
+// comes first: latest award year, then most awards, then oldest record.
+func compareCanonical(a, b models.Restaurant) int {
+	latest := func(r models.Restaurant) int {
+		year := 0
+		for _, award := range r.Awards {
+			year = max(year, award.Year)
+		}
+		return year
+	}
+	if c := cmp.Compare(latest(b), latest(a)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(len(b.Awards), len(a.Awards)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// normalizeName lowercases a name and drops everything but letters and digits.
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// normalizePhone keeps the digits of a phone number, or "" if there are too
+// few to identify a restaurant.
+func normalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) < 6 {
+		return ""
+	}
+	return digits
+}
+
+// distance returns the great-circle distance between two restaurants in
+// meters, or false if either lacks coordinates.
+func distance(a, b *models.Restaurant) (float64, bool) {
+	lat1, err1 := strconv.ParseFloat(a.Latitude, 64)
+	lng1, err2 := strconv.ParseFloat(a.Longitude, 64)
+	lat2, err3 := strconv.ParseFloat(b.Latitude, 64)
+	lng2, err4 := strconv.ParseFloat(b.Longitude, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return 0, false
+	}
+
+	const earthRadius = 6371000.0
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := rad(lat2-lat1), rad(lng2-lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h)), true
+}
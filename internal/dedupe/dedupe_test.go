@@ -0,0 +1,74 @@
+package dedupe
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+)
+
+func TestFind(t *testing.T) {
+	restaurants := []models.Restaurant{
+		{ID: 1, URL: "https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette", Name: "Odette", PhoneNumber: "+65 6385 0498", Latitude: "1.2902", Longitude: "103.8515",
+			Awards: []models.RestaurantAward{{Year: 2020}}},
+		{ID: 2, URL: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette", Name: "Odette", PhoneNumber: "+6563850498", Latitude: "1.2902", Longitude: "103.8515",
+			Awards: []models.RestaurantAward{{Year: 2024}}},
+		// Renamed slug, same phone and place.
+		{ID: 3, URL: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette-national-gallery", Name: "Odette at National Gallery", PhoneNumber: "+65 6385 0498", Latitude: "1.2905", Longitude: "103.8516"},
+		// Same name elsewhere: a different restaurant.
+		{ID: 4, URL: "https://guide.michelin.com/fr/en/ile-de-france/paris/restaurant/odette", Name: "Odette", PhoneNumber: "+33 1 00 00 00 00", Latitude: "48.8566", Longitude: "2.3522"},
+		// Same name nearby without a phone: a move down the street, or a
+		// branch; grouped either way for review.
+		{ID: 5, URL: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/burnt-ends", Name: "Burnt Ends", Latitude: "1.2800", Longitude: "103.8400"},
+		{ID: 6, URL: "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/burnt-ends-dempsey", Name: "Burnt Ends!", Latitude: "1.2805", Longitude: "103.8401"},
+	}
+
+	groups := Find(restaurants, DefaultMaxDistance)
+	if len(groups) != 2 {
+		t.Fatalf("Find() returned %d groups; want 2: %+v", len(groups), groups)
+	}
+
+	odette, burntEnds := groups[0], groups[1]
+	if burntEnds.Canonical.ID != 5 || len(burntEnds.Duplicates) != 1 || burntEnds.Duplicates[0].ID != 6 {
+		t.Errorf("unexpected group %+v", burntEnds)
+	}
+	if odette.Canonical.ID != 2 {
+		t.Errorf("canonical = %d; want the restaurant with the latest award", odette.Canonical.ID)
+	}
+	var ids []uint
+	for _, d := range odette.Duplicates {
+		ids = append(ids, d.ID)
+	}
+	if !reflect.DeepEqual(ids, []uint{1, 3}) {
+		t.Errorf("duplicates = %v; want [1 3]", ids)
+	}
+	if want := []string{ReasonDistance, ReasonName, ReasonPhone, ReasonURL}; !reflect.DeepEqual(odette.Reasons, want) {
+		t.Errorf("reasons = %v; want %v", odette.Reasons, want)
+	}
+}
+
+func TestFindIgnoresDistantNamesakes(t *testing.T) {
+	restaurants := []models.Restaurant{
+		{ID: 1, URL: "https://guide.michelin.com/sg/en/a/restaurant/x", Name: "Same", Latitude: "1.0", Longitude: "103.0"},
+		{ID: 2, URL: "https://guide.michelin.com/sg/en/b/restaurant/y", Name: "Same", Latitude: "1.1", Longitude: "103.0"},
+		{ID: 3, URL: "https://guide.michelin.com/sg/en/c/restaurant/z", Name: "Other", PhoneNumber: "12", Latitude: "1.0", Longitude: "103.0"},
+		// Branches of a chain sharing a central phone number.
+		{ID: 4, URL: "https://guide.michelin.com/sg/en/d/restaurant/chain-orchard", Name: "Chain", PhoneNumber: "+65 6000 0000", Latitude: "1.30", Longitude: "103.83"},
+		{ID: 5, URL: "https://guide.michelin.com/sg/en/d/restaurant/chain-marina", Name: "Chain", PhoneNumber: "+65 6000 0000", Latitude: "1.28", Longitude: "103.86"},
+	}
+	if groups := Find(restaurants, DefaultMaxDistance); len(groups) != 0 {
+		t.Errorf("Find() = %+v; want no groups", groups)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	a := &models.Restaurant{Latitude: "1.2902", Longitude: "103.8515"}
+	b := &models.Restaurant{Latitude: "1.2911", Longitude: "103.8515"}
+	d, ok := distance(a, b)
+	if !ok || d < 95 || d > 105 {
+		t.Errorf("distance() = %v, %v; want about 100m", d, ok)
+	}
+	if _, ok := distance(a, &models.Restaurant{}); ok {
+		t.Errorf("distance() without coordinates should not be ok")
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"time"
 
 	"github.com/gocolly/colly/v2"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
@@ -44,6 +45,10 @@ func Handle(ctx context.Context, e *colly.XMLElement, repo storage.RestaurantRep
 		data.Location = e.Request.Ctx.Get("location")
 	}
 
+	// Pages carry the time they were fetched when they are archived, so that a
+	// reparse dates its captures by that rather than by now.
+	fetchedAt, _ := e.Request.Ctx.GetAny("fetched_at").(time.Time)
+
 	restaurant = &models.Restaurant{
 		URL:                   data.URL,
 		Name:                  data.Name,
@@ -56,6 +61,7 @@ func Handle(ctx context.Context, e *colly.XMLElement, repo storage.RestaurantRep
 		FacilitiesAndServices: data.FacilitiesAndServices,
 		PhoneNumber:           data.PhoneNumber,
 		WebsiteURL:            data.WebsiteURL,
+		UpdatedAt:             fetchedAt,
 	}
 
 	if err := repo.SaveRestaurant(ctx, restaurant); err != nil {
@@ -73,6 +79,8 @@ func Handle(ctx context.Context, e *colly.XMLElement, repo storage.RestaurantRep
 		Price:        data.Price,
 		GreenStar:    data.GreenStar,
 		WaybackURL:   data.WaybackURL,
+		Archive:      data.Archive,
+		SeenAt:       fetchedAt,
 	}
 
 	if err := repo.SaveAward(ctx, award); err != nil {
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RestaurantAlias maps a former or alternative URL of a restaurant, such as
+// an older slug or locale, to the restaurant it was merged into.
+type RestaurantAlias struct {
+	URL          string     `gorm:"primaryKey"`
+	RestaurantID uint       `gorm:"not null;index"`
+	Restaurant   Restaurant `gorm:"constraint:OnDelete:CASCADE"`
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+}
+
+// TableName sets the table name for RestaurantAlias
+func (RestaurantAlias) TableName() string {
+	return "restaurant_aliases"
+}
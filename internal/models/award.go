@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,10 +18,15 @@ const (
 	SelectedRestaurants = "Selected Restaurants"
 )
 
-// RestaurantAward stores award information for a restaurant in a specific year.
+// Distinctions lists every valid distinction, highest first.
+var Distinctions = []string{ThreeStars, TwoStars, OneStar, BibGourmand, SelectedRestaurants}
+
+// RestaurantAward stores award information for a restaurant in a specific year,
+// rolled up from the AwardCaptures of that year.
 type RestaurantAward struct {
 	ID           uint   `gorm:"primaryKey"`
-	WaybackURL   string `gorm:"column:wayback_url"` // "" for live scraping, URL for backfill
+	WaybackURL   string `gorm:"column:wayback_url"`  // "" for live scraping, archive URL for backfill
+	Archive      string `gorm:"not null;default:''"` // name of the web archive WaybackURL belongs to
 	RestaurantID uint   `gorm:"not null;index:idx_restaurant_year;constraint:OnDelete:CASCADE;uniqueIndex:idx_restaurant_year_unique"`
 	Distinction  string `gorm:"not null;index:idx_distinction"`
 	GreenStar    bool
@@ -29,6 +35,8 @@ type RestaurantAward struct {
 
 	CreatedAt time.Time `gorm:"type:datetime"`
 	UpdatedAt time.Time `gorm:"type:datetime"`
+
+	SeenAt time.Time `gorm:"-"` // when the page was fetched, to date its capture by; zero means now
 }
 
 // BeforeCreate runs validation before creating a restaurant award record
@@ -49,14 +57,7 @@ func (r *RestaurantAward) validate() error {
 	if strings.TrimSpace(r.Distinction) == "" {
 		return errors.New("distinction cannot be empty")
 	}
-	allowed := map[string]bool{
-		ThreeStars:          true,
-		TwoStars:            true,
-		OneStar:             true,
-		BibGourmand:         true,
-		SelectedRestaurants: true,
-	}
-	if !allowed[r.Distinction] {
+	if !slices.Contains(Distinctions, r.Distinction) {
 		return errors.New("distinction must be a valid value")
 	}
 	if strings.TrimSpace(r.Price) == "" {
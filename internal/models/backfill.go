@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	BackfillComplete    = "complete"     // every new snapshot was processed
+	BackfillIncomplete  = "incomplete"   // some snapshots failed and will be retried
+	BackfillNoSnapshots = "no_snapshots" // the archive listed nothing new
+)
+
+// BackfillState records the backfill progress of a restaurant URL in a web
+// archive so that later runs only ask the archive for newer snapshots.
+type BackfillState struct {
+	URL            string    `gorm:"primaryKey"`
+	Archive        string    `gorm:"primaryKey"`
+	LastQueriedAt  time.Time `gorm:"type:datetime;not null"`
+	LatestSnapshot string    // newest snapshot timestamp (yyyyMMddhhmmss) processed without gaps
+	Outcome        string    `gorm:"not null"`
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+	UpdatedAt time.Time `gorm:"type:datetime"`
+}
+
+// TableName sets the table name for BackfillState
+func (BackfillState) TableName() string {
+	return "backfill_state"
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AwardCapture is a single observation of a restaurant's award, taken from a
+// live scrape or an archived snapshot. The yearly RestaurantAward is rolled up
+// from the captures of that year.
+type AwardCapture struct {
+	ID           uint       `gorm:"primaryKey"`
+	RestaurantID uint       `gorm:"not null;index:idx_capture_restaurant_year"`
+	Restaurant   Restaurant `gorm:"constraint:OnDelete:CASCADE"`
+	Year         int        `gorm:"not null;index:idx_capture_restaurant_year"`
+	Distinction  string     `gorm:"not null"`
+	GreenStar    bool
+	Price        string    `gorm:"not null;default:''"`      // "" when the page showed no price
+	WaybackURL   string    `gorm:"column:wayback_url;index"` // "" for live scraping, archive URL for backfill
+	Archive      string    `gorm:"not null;default:''"`
+	CapturedAt   time.Time `gorm:"type:datetime;not null;index"` // snapshot time, or scrape time for live captures
+	LastSeenAt   time.Time `gorm:"type:datetime;not null"`       // last scrape that observed the same award
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+}
+
+// BeforeCreate runs validation before creating an award capture record
+func (c *AwardCapture) BeforeCreate(tx *gorm.DB) error {
+	return c.validate()
+}
+
+// validate checks the fields a capture cannot do without; unlike awards, a
+// capture may lack a price.
+func (c *AwardCapture) validate() error {
+	if c.RestaurantID == 0 {
+		return errors.New("restaurant ID must be positive")
+	}
+	if !slices.Contains(Distinctions, c.Distinction) {
+		return errors.New("distinction must be a valid value")
+	}
+	currentYear := time.Now().Year()
+	if c.Year < 1900 || c.Year > currentYear+1 {
+		return fmt.Errorf("year must be between 1900 and %d", currentYear)
+	}
+	return nil
+}
+
+// TableName sets the table name for AwardCapture
+func (AwardCapture) TableName() string {
+	return "award_captures"
+}
+
+// Award returns the capture as an award, dated at its capture time.
+func (c *AwardCapture) Award() *RestaurantAward {
+	return &RestaurantAward{
+		RestaurantID: c.RestaurantID,
+		Year:         c.Year,
+		Distinction:  c.Distinction,
+		GreenStar:    c.GreenStar,
+		Price:        c.Price,
+		WaybackURL:   c.WaybackURL,
+		Archive:      c.Archive,
+		UpdatedAt:    c.CapturedAt,
+	}
+}
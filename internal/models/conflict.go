@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+const (
+	ConflictOverwritten = "overwritten" // archived data replaced the existing award
+	ConflictUpgraded    = "upgraded"    // a live scrape replaced an archived award
+	ConflictKept        = "kept"        // the existing award was kept
+)
+
+// SourceLive is the source of awards scraped from the live guide, as opposed
+// to the archive URL of backfilled awards.
+const SourceLive = "live"
+
+// AwardConflict records a disagreement between an existing award and an
+// incoming one for the same restaurant and year, and how SaveAward settled it.
+type AwardConflict struct {
+	ID                  uint       `gorm:"primaryKey"`
+	RestaurantID        uint       `gorm:"not null;index:idx_conflict_restaurant_year;uniqueIndex:idx_conflict_unique"`
+	Restaurant          Restaurant `gorm:"constraint:OnDelete:CASCADE"`
+	Year                int        `gorm:"not null;index:idx_conflict_restaurant_year;uniqueIndex:idx_conflict_unique"`
+	ExistingDistinction string     `gorm:"not null"`
+	ExistingPrice       string     `gorm:"not null"`
+	ExistingGreenStar   bool
+	ExistingSource      string `gorm:"not null;uniqueIndex:idx_conflict_unique"` // SourceLive or the archive URL
+	IncomingDistinction string `gorm:"not null;uniqueIndex:idx_conflict_unique"`
+	IncomingPrice       string `gorm:"not null;uniqueIndex:idx_conflict_unique"`
+	IncomingGreenStar   bool   `gorm:"uniqueIndex:idx_conflict_unique"`
+	IncomingSource      string `gorm:"not null;uniqueIndex:idx_conflict_unique"` // SourceLive or the archive URL
+	Decision            string `gorm:"not null;index:idx_conflict_decision"`
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+	UpdatedAt time.Time `gorm:"type:datetime"` // last time the same conflict was seen
+}
+
+// TableName sets the table name for AwardConflict
+func (AwardConflict) TableName() string {
+	return "award_conflicts"
+}
+
+// AwardSource returns where an award was taken from: SourceLive or its
+// archive URL.
+func AwardSource(a *RestaurantAward) string {
+	if a.WaybackURL == "" {
+		return SourceLive
+	}
+	return a.WaybackURL
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AwardOverride pins award fields for a restaurant and year, taking precedence
+// over both live and archived data. Empty Distinction or Price and a nil
+// GreenStar leave that field to the scraped data.
+type AwardOverride struct {
+	ID           uint       `gorm:"primaryKey"`
+	RestaurantID uint       `gorm:"not null;uniqueIndex:idx_override_restaurant_year"`
+	Restaurant   Restaurant `gorm:"constraint:OnDelete:CASCADE"`
+	Year         int        `gorm:"not null;uniqueIndex:idx_override_restaurant_year"`
+	Distinction  string     `gorm:"not null;default:''"`
+	Price        string     `gorm:"not null;default:''"`
+	GreenStar    *bool
+	Reason       string // why the override was set, for later curators
+
+	CreatedAt time.Time `gorm:"type:datetime"`
+	UpdatedAt time.Time `gorm:"type:datetime"`
+}
+
+// BeforeCreate runs validation before creating an award override record
+func (o *AwardOverride) BeforeCreate(tx *gorm.DB) error {
+	return o.validate()
+}
+
+// BeforeUpdate runs validation before updating an award override record
+func (o *AwardOverride) BeforeUpdate(tx *gorm.DB) error {
+	return o.validate()
+}
+
+// validate checks that the override pins at least one valid field
+func (o *AwardOverride) validate() error {
+	if o.RestaurantID == 0 {
+		return errors.New("restaurant ID must be positive")
+	}
+	if o.Distinction == "" && strings.TrimSpace(o.Price) == "" && o.GreenStar == nil {
+		return errors.New("override must pin a distinction, price or green star")
+	}
+	if o.Distinction != "" && !slices.Contains(Distinctions, o.Distinction) {
+		return fmt.Errorf("distinction must be one of %s", strings.Join(Distinctions, ", "))
+	}
+	currentYear := time.Now().Year()
+	if o.Year < 1900 || o.Year > currentYear+1 {
+		return fmt.Errorf("year must be between 1900 and %d", currentYear)
+	}
+	return nil
+}
+
+// TableName sets the table name for AwardOverride
+func (AwardOverride) TableName() string {
+	return "award_overrides"
+}
+
+// Apply sets the pinned fields on award and returns the ones it changed as
+// "old → new".
+func (o *AwardOverride) Apply(award *RestaurantAward) map[string]string {
+	diff := map[string]string{}
+	if o.Distinction != "" && award.Distinction != o.Distinction {
+		diff["distinction"] = fmt.Sprintf("%v → %v", award.Distinction, o.Distinction)
+		award.Distinction = o.Distinction
+	}
+	if o.Price != "" && award.Price != o.Price {
+		diff["price"] = fmt.Sprintf("%v → %v", award.Price, o.Price)
+		award.Price = o.Price
+	}
+	if o.GreenStar != nil && award.GreenStar != *o.GreenStar {
+		diff["green_star"] = fmt.Sprintf("%v → %v", award.GreenStar, *o.GreenStar)
+		award.GreenStar = *o.GreenStar
+	}
+	return diff
+}
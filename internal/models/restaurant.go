@@ -13,6 +13,7 @@ type Restaurant struct {
 	ID                    uint              `gorm:"primaryKey"`
 	URL                   string            `gorm:"unique;not null;index"`
 	Address               string            `gorm:"not null"`
+	Aliases               []RestaurantAlias `gorm:"foreignKey:RestaurantID"`
 	Awards                []RestaurantAward `gorm:"foreignKey:RestaurantID"`
 	Cuisine               string            `gorm:"not null"`
 	Description           string            `gorm:"not null"`
@@ -0,0 +1,48 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/antchfx/htmlquery"
+	"github.com/gocolly/colly/v2"
+)
+
+// NewElement builds the root XMLElement for an HTML page fetched from rawURL,
+// the same way colly does in OnXML("html"). It lets pages stored outside of
+// colly (e.g. the raw HTML archive) go through Parse and handlers.Handle.
+func NewElement(body []byte, rawURL string, ctx *colly.Context) (*colly.XMLElement, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if ctx == nil {
+		ctx = colly.NewContext()
+	}
+
+	doc, err := htmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	root := htmlquery.FindOne(doc, "//html")
+	if root == nil {
+		return nil, errors.New("no html element in page")
+	}
+
+	headers := http.Header{"Content-Type": {"text/html"}}
+	resp := &colly.Response{
+		StatusCode: http.StatusOK,
+		Body:       body,
+		Ctx:        ctx,
+		Headers:    &headers,
+		Request: &colly.Request{
+			URL:     u,
+			Method:  http.MethodGet,
+			Ctx:     ctx,
+			Headers: &http.Header{},
+		},
+	}
+	return colly.NewXMLElementFromHTMLNode(resp, root), nil
+}
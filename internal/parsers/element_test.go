@@ -0,0 +1,39 @@
+package parsers
+
+import (
+	"testing"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func TestNewElementFeedsParse(t *testing.T) {
+	body := []byte(`<html><head><script type="application/ld+json">` + wakuGhinJSONLD + `</script></head><body></body></html>`)
+	waybackURL := "https://web.archive.org/web/20240101000000id_/https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/waku-ghin"
+
+	ctx := colly.NewContext()
+	ctx.Put("location", "Singapore")
+	e, err := NewElement(body, waybackURL, ctx)
+	if err != nil {
+		t.Fatalf("NewElement() error = %v", err)
+	}
+	if e.Request.Ctx.Get("location") != "Singapore" {
+		t.Fatalf("context not carried onto request")
+	}
+
+	data := Parse(e)
+	if data.Name != "Waku Ghin" {
+		t.Fatalf("Name = %q; want %q", data.Name, "Waku Ghin")
+	}
+	if data.WaybackURL != waybackURL {
+		t.Fatalf("WaybackURL = %q; want %q", data.WaybackURL, waybackURL)
+	}
+	if data.URL != "https://guide.michelin.com/en/singapore-region/singapore/restaurant/waku-ghin" {
+		t.Fatalf("URL = %q", data.URL)
+	}
+}
+
+func TestNewElementRejectsInvalidURL(t *testing.T) {
+	if _, err := NewElement([]byte("<html></html>"), "://bad", nil); err == nil {
+		t.Fatal("expected error for invalid URL")
+	}
+}
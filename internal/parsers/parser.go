@@ -1,14 +1,14 @@
 package parsers
 
 import (
-	"strings"
-
 	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
 )
 
 // ExtractedData contains all possible data that can be extracted from a restaurant page
 type ExtractedData struct {
 	Address               string
+	Archive               string // name of the web archive WaybackURL belongs to
 	Cuisine               string
 	Description           string
 	Distinction           string
@@ -21,18 +21,19 @@ type ExtractedData struct {
 	PhoneNumber           string
 	Price                 string
 	URL                   string
-	WaybackURL            string
+	WaybackURL            string // archive URL, from the Wayback Machine or another web archive
 	WebsiteURL            string
 	Year                  int
 }
 
 // Parse is the unified extraction function that works for both scraper and backfill modes
 func Parse(e *colly.XMLElement) *ExtractedData {
-	url, waybackURL := parseRequestURL(e.Request.URL.String())
+	url, waybackURL := ParseRequestURL(e.Request.URL.String())
 	data := seedExtractedData(findAndParseJSONLD(e))
 
-	data.URL = url
+	data.URL = CanonicalURL(url)
 	data.WaybackURL = waybackURL
+	data.Archive = ParseArchiveName(waybackURL)
 
 	address := firstNonEmpty(tryRestaurantSelectors(e, "address", NormalizeAddress), data.Address)
 	distinction, greenStar := ExtractDistinction(e)
@@ -58,13 +59,22 @@ func Parse(e *colly.XMLElement) *ExtractedData {
 	return data
 }
 
-func parseRequestURL(currentURL string) (url, waybackURL string) {
-	url = currentURL
-	if strings.Contains(currentURL, "web.archive.org") {
-		waybackURL = currentURL
-		url = extractOriginalURL(currentURL)
+// ParseRequestURL splits a request URL into the restaurant URL and, for
+// web archive captures, the archive URL it was fetched from.
+func ParseRequestURL(currentURL string) (url, archiveURL string) {
+	if _, ok := webarchive.Lookup(currentURL); ok {
+		return extractOriginalURL(currentURL), currentURL
 	}
-	return url, waybackURL
+	return currentURL, ""
+}
+
+// ParseArchiveName returns the name of the web archive a request URL was
+// fetched from, or "" for the live site.
+func ParseArchiveName(currentURL string) string {
+	if c, ok := webarchive.Lookup(currentURL); ok {
+		return c.Archive.Name
+	}
+	return ""
 }
 
 func seedExtractedData(ld *jsonLDRestaurant) *ExtractedData {
@@ -1,18 +1,96 @@
 package parsers
 
-import "regexp"
+import (
+	"net/url"
+	"regexp"
+	"strings"
 
-var (
-	// waybackRegex matches a Wayback Machine archive URL
-	waybackRegex = regexp.MustCompile(`https?://web\.archive\.org/web/\d{14}[^/]*/(.+)`)
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
 )
 
-// extractOriginalURL extracts the original URL from a Wayback Machine archive URL.
+const guideHost = "guide.michelin.com"
+
+// localeSegment matches the country and language segments that prefix guide
+// paths, e.g. "sg" and "en" in /sg/en/..., or "zh_CN" and "zh-tw".
+var localeSegment = regexp.MustCompile(`^[a-z]{2}([-_][a-z]{2})?$`)
+
+// extractOriginalURL extracts the original URL from a web archive capture URL.
 // e.g. https://web.archive.org/web/YYYYMMDDhhmmss/ORIGINAL_URL
-func extractOriginalURL(waybackURL string) string {
-	matches := waybackRegex.FindStringSubmatch(waybackURL)
-	if len(matches) >= 2 {
-		return matches[1]
+func extractOriginalURL(archiveURL string) string {
+	if c, ok := webarchive.Lookup(archiveURL); ok {
+		return c.Original
+	}
+	return archiveURL
+}
+
+// canonicalLocale is the locale prefix every localized URL is stored under.
+const canonicalLocale = "en"
+
+// CanonicalURL returns the form of a Michelin Guide URL stored in the
+// database: that of NormalizeURL, with its locale prefix replaced by /en/, so
+// that /sg/en/..., /en/... and /sg/zh_CN/... forms of the same page are one
+// restaurant. Other URLs are returned unchanged.
+func CanonicalURL(rawURL string) string {
+	segments, ok := guidePath(rawURL)
+	if !ok {
+		return rawURL
+	}
+	if n := localePrefix(segments); n > 0 && n < len(segments) {
+		segments = append([]string{canonicalLocale}, segments[n:]...)
+	}
+	return "https://" + guideHost + "/" + strings.Join(segments, "/")
+}
+
+// NormalizeURL returns a Michelin Guide URL as the guide serves it: https, no
+// www, port, query, fragment or trailing slash, and a lowercase locale prefix,
+// which is kept as archives index pages under it. Other URLs are returned
+// unchanged.
+func NormalizeURL(rawURL string) string {
+	segments, ok := guidePath(rawURL)
+	if !ok {
+		return rawURL
+	}
+	return "https://" + guideHost + "/" + strings.Join(segments, "/")
+}
+
+// URLKey returns the path of a Michelin Guide URL without its locale prefix,
+// which every form of the same page shares.
+func URLKey(rawURL string) string {
+	segments, ok := guidePath(rawURL)
+	if !ok {
+		return rawURL
+	}
+	n := localePrefix(segments)
+	if n == len(segments) {
+		n-- // a bare /sg/en keys by its language
+	}
+	return strings.Join(segments[n:], "/")
+}
+
+// guidePath returns the path segments of a Michelin Guide URL, with its
+// locale prefix lowercased, or false for other URLs.
+func guidePath(rawURL string) ([]string, bool) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") != guideHost {
+		return nil, false
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i < len(segments) && i < 2; i++ {
+		lower := strings.ToLower(segments[i])
+		if !localeSegment.MatchString(lower) {
+			break
+		}
+		segments[i] = lower
+	}
+	return segments, true
+}
+
+// localePrefix returns how many leading segments, at most two, are a locale
+// prefix.
+func localePrefix(segments []string) int {
+	n := 0
+	for n < 2 && n < len(segments) && localeSegment.MatchString(segments[n]) {
+		n++
 	}
-	return waybackURL
+	return n
 }
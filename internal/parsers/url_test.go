@@ -32,3 +32,55 @@ func TestExtractOriginalURL(t *testing.T) {
 		})
 	}
 }
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette", "https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette"},
+		{"https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette", "https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette"},
+		{"http://www.guide.michelin.com:80/SG/EN/singapore-region/singapore/restaurant/odette/?utm=x#top", "https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette"},
+		{"https://guide.michelin.com/tw/zh_TW/taipei-region/taipei/restaurant/le-palais", "https://guide.michelin.com/en/taipei-region/taipei/restaurant/le-palais"},
+		{"https://guide.michelin.com/restaurant/odette/", "https://guide.michelin.com/restaurant/odette"},
+		{"https://guide.michelin.com/sg/en", "https://guide.michelin.com/sg/en"},
+		{"https://example.com/a/", "https://example.com/a/"},
+	}
+	for _, tt := range tests {
+		if got := CanonicalURL(tt.input); got != tt.expected {
+			t.Errorf("CanonicalURL(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"http://www.guide.michelin.com:80/SG/EN/singapore-region/singapore/restaurant/odette/?utm=x#top", "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette"},
+		{"https://guide.michelin.com/tw/zh_TW/taipei-region/taipei/restaurant/le-palais", "https://guide.michelin.com/tw/zh_tw/taipei-region/taipei/restaurant/le-palais"},
+		{"https://example.com/a/", "https://example.com/a/"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeURL(tt.input); got != tt.expected {
+			t.Errorf("NormalizeURL(%q) = %q; want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestURLKey(t *testing.T) {
+	want := "singapore-region/singapore/restaurant/odette"
+	for _, input := range []string{
+		"https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette",
+		"https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette/",
+		"http://guide.michelin.com/sg/zh_CN/singapore-region/singapore/restaurant/odette",
+	} {
+		if got := URLKey(input); got != want {
+			t.Errorf("URLKey(%q) = %q; want %q", input, got, want)
+		}
+	}
+	if a, b := URLKey("https://guide.michelin.com/sg/en/restaurant/odette"), URLKey("https://guide.michelin.com/sg/en/restaurant/burnt-ends"); a == b {
+		t.Errorf("different restaurants should not share a key: %q", a)
+	}
+}
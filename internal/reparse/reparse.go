@@ -0,0 +1,150 @@
+package reparse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/archive"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/client"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/handlers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/storage"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/warc"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reparser replays archived pages through the parsers and handlers without
+// touching the network.
+type Reparser struct {
+	archive    *archive.Store
+	repository storage.RestaurantRepository
+}
+
+// New returns a Reparser over the default archive and database.
+func New() (*Reparser, error) {
+	policy, err := storage.MergePolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := storage.NewSQLiteRepository(client.DefaultDataPath, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	store, err := archive.Open(client.DefaultArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	return &Reparser{
+		archive:    store,
+		repository: repo,
+	}, nil
+}
+
+// Close releases the archive and repository opened by New.
+func (p *Reparser) Close() error {
+	return errors.Join(p.archive.Close(), p.repository.Close())
+}
+
+// RunAll reparses every archived page.
+func (p *Reparser) RunAll(ctx context.Context) error {
+	return p.run(ctx, "")
+}
+
+// Run reparses the archived pages of a single restaurant URL.
+func (p *Reparser) Run(ctx context.Context, url string) error {
+	return p.run(ctx, url)
+}
+
+func (p *Reparser) run(ctx context.Context, url string) error {
+	var parsed, failed int
+
+	err := p.archive.Each(url, func(page *archive.Page) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fields := log.Fields{
+			"hash":        page.Hash,
+			"url":         page.URL,
+			"wayback_url": page.WaybackURL,
+		}
+		if p.handle(ctx, page.Body, page.RequestURL(), page.Location, page.FetchedAt, fields) {
+			parsed++
+		} else {
+			failed++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"failed": failed,
+		"parsed": parsed,
+	}).Info("completed reparse")
+	return nil
+}
+
+// RunWARC reparses every successful response recorded in the given WARC
+// files, in file order.
+func (p *Reparser) RunWARC(ctx context.Context, paths []string) error {
+	var parsed, failed int
+
+	for _, path := range paths {
+		err := warc.EachResponse(path, func(targetURI string, date time.Time, body []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			fields := log.Fields{
+				"url":  targetURI,
+				"warc": path,
+			}
+			if p.handle(ctx, body, targetURI, "", date, fields) {
+				parsed++
+			} else {
+				failed++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"failed": failed,
+		"files":  len(paths),
+		"parsed": parsed,
+	}).Info("completed reparse")
+	return nil
+}
+
+// handle runs a single page through the parsers and handlers, reporting
+// whether it was extracted successfully. Its captures are dated by fetchedAt.
+func (p *Reparser) handle(ctx context.Context, body []byte, requestURL, location string, fetchedAt time.Time, fields log.Fields) bool {
+	pageCtx := colly.NewContext()
+	if location != "" {
+		pageCtx.Put("location", location)
+	}
+	if !fetchedAt.IsZero() {
+		pageCtx.Put("fetched_at", fetchedAt.UTC())
+	}
+	e, err := parsers.NewElement(body, requestURL, pageCtx)
+	if err != nil {
+		log.WithError(err).WithFields(fields).Warn("failed to load archived page")
+		return false
+	}
+
+	if err := handlers.Handle(ctx, e, p.repository); err != nil {
+		log.WithError(err).WithFields(fields).Error("failed to handle restaurant extraction")
+		return false
+	}
+	return true
+}
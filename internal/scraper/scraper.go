@@ -5,20 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/archive"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/auth"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/client"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/cookiestore"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/handlers"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/storage"
-	"github.com/ngshiheng/michelin-my-maps/v4/internal/utils"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/warc"
 	log "github.com/sirupsen/logrus"
-	"github.com/velebak/colly-sqlite3-storage/colly/sqlite3"
 )
 
 const (
@@ -29,13 +32,52 @@ const (
 	xPathDetailRoot             = "html"
 )
 
+// clientConfig returns the config of the scraper's client.
+func clientConfig(cfg *client.Config, cookieKey []byte, ignoreCache bool) *client.Config {
+	clientCfg := &client.Config{
+		AllowedDomains: cfg.AllowedDomains,
+		CacheBackend:   cfg.CacheBackend,
+		CachePath:      cfg.CachePath,
+		CacheTTL:       cfg.CacheTTL,
+		Conditional:    true,
+		CookiePath:     cfg.CookiePath,
+		CookieKey:      cookieKey,
+		Delay:          cfg.Delay,
+		MaxRetry:       cfg.MaxRetry,
+		Proxies:        cfg.Proxies,
+		ProxyMode:      cfg.ProxyMode,
+		RandomDelay:    cfg.RandomDelay,
+		Run:            client.RunScrape,
+		StoragePath:    cfg.StoragePath,
+		ThreadCount:    cfg.ThreadCount,
+	}
+	if ignoreCache {
+		log.Debug("running with no cache")
+		clientCfg.CachePath = ""
+	}
+	return clientCfg
+}
+
+// ErrSessionExpired is returned by a run stopped because every session has
+// expired, so that the caller can log in again.
+var ErrSessionExpired = errors.New("session expired")
+
 // defaultConfig returns a default config for the scraper
 func defaultConfig() *client.Config {
 	return &client.Config{
+		AccountsPath:   os.Getenv("MYM_ACCOUNTS_FILE"),
 		AllowedDomains: []string{"guide.michelin.com"},
+		ArchivePath:    client.DefaultArchivePath,
+		CacheBackend:   os.Getenv("MYM_CACHE_BACKEND"),
 		CachePath:      client.DefaultCacheScrape,
+		CacheTTL:       client.DefaultCacheScrapeTTL,
+		CookiePath:     client.DefaultCookiePath,
 		DatabasePath:   client.DefaultDataPath,
+		Proxies:        os.Getenv("MYM_PROXIES"),
+		ProxyMode:      os.Getenv("MYM_PROXY_MODE"),
 		StoragePath:    client.DefaultStoragePath,
+		WARCPath:       os.Getenv("MYM_WARC_DIR"),
+		WARCMaxSize:    warc.DefaultMaxSize,
 		Delay:          2 * time.Second,
 		MaxRetry:       3,
 		RandomDelay:    3 * time.Second, // 2–5 s jitter
@@ -45,76 +87,93 @@ func defaultConfig() *client.Config {
 
 // Scraper orchestrates the scraping process
 type Scraper struct {
+	added      map[string]string // new restaurants by URL key to enqueue from the listing pages; nil enqueues all
+	archive    *archive.Store
 	client     *client.Colly
 	config     *client.Config
+	discovery  string      // DiscoverySitemap or DiscoveryListing
+	expired    atomic.Bool // set once every session has expired, to stop the run
 	repository storage.RestaurantRepository
 	scraped    atomic.Int64
+	unchanged  atomic.Int64 // pages skipped as not modified since the last run
+	warc       *warc.Writer // nil unless WARC output is enabled
 }
 
-// New returns a new Scraper with default settings
-func New(ignoreCache bool) (*Scraper, error) {
+// New returns a new Scraper with default settings, discovering detail pages
+// with the given mode; "" means DiscoveryListing.
+func New(ignoreCache bool, discovery string) (*Scraper, error) {
+	if discovery == "" {
+		discovery = DiscoveryListing
+	}
+	if !slices.Contains(DiscoveryModes, discovery) {
+		return nil, fmt.Errorf("invalid discovery mode %q: want one of %s", discovery, strings.Join(DiscoveryModes, ", "))
+	}
 	cfg := defaultConfig()
 
-	repo, err := storage.NewSQLiteRepository(cfg.DatabasePath)
+	policy, err := storage.MergePolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := storage.NewSQLiteRepository(cfg.DatabasePath, policy)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create repository: %w", err)
 	}
 
-	clientCfg := &client.Config{
-		AllowedDomains: cfg.AllowedDomains,
-		CachePath:      cfg.CachePath,
-		Delay:          cfg.Delay,
-		MaxRetry:       cfg.MaxRetry,
-		RandomDelay:    cfg.RandomDelay,
-		StoragePath:    cfg.StoragePath,
-		ThreadCount:    cfg.ThreadCount,
-	}
-	if ignoreCache {
-		log.Debug("running with no cache")
-		clientCfg.CachePath = ""
+	cookieKey, err := cookiestore.KeyFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	cl, err := client.New(clientCfg)
+	cl, err := client.New(clientConfig(cfg, cookieKey, ignoreCache))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
+	if cfg.AccountsPath != "" {
+		accounts, err := auth.LoadAccounts(cfg.AccountsPath)
+		if err != nil {
+			return nil, err
+		}
+		cl.SetSessionRefresher(auth.Refresher(accounts, true, auth.DefaultTimeout))
+	}
+
+	store, err := archive.Open(cfg.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
 
 	s := &Scraper{
+		archive:    store,
 		client:     cl,
 		config:     cfg,
+		discovery:  discovery,
 		repository: repo,
 	}
-	return s, nil
-}
 
-// InitCookies persists Michelin Guide session cookies to the cookie storage.
-// Existing rows are cleared first since the sqlite3 backend uses plain INSERT (not upsert)
-// We need to Init -> Clear -> Init because Clear does not do DROP TABLE IF EXISTS
-func (s *Scraper) InitCookies(cookies []*http.Cookie) error {
-	url := &url.URL{Host: "guide.michelin.com"}
-
-	store := &sqlite3.Storage{Filename: s.config.StoragePath}
-	defer store.Close()
-
-	if err := store.Init(); err != nil {
-		return fmt.Errorf("failed to initialize storage: %w", err)
-	}
-
-	if err := store.Clear(); err != nil {
-		return fmt.Errorf("failed to clear storage: %w", err)
+	if cfg.WARCPath != "" {
+		s.warc, err = warc.NewWriter(cfg.WARCPath, "scrape", cfg.WARCMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create WARC writer: %w", err)
+		}
 	}
+	return s, nil
+}
 
-	if err := store.Init(); err != nil {
-		return fmt.Errorf("failed to re-initialize storage: %w", err)
+// Close releases the client, repository and archive opened by New and closes
+// the current WARC file, if any.
+func (s *Scraper) Close() error {
+	var err error
+	if s.warc != nil {
+		err = s.warc.Close()
 	}
+	return errors.Join(err, s.archive.Close(), s.repository.Close(), s.client.Close())
+}
 
-	lines := make([]string, len(cookies))
-
-	for i, c := range cookies {
-		lines[i] = c.String()
+// InitSessions persists Michelin Guide session cookies, by session name, to
+// the encrypted cookie store, replacing the previous sessions.
+func (s *Scraper) InitSessions(sessions map[string][]*http.Cookie) error {
+	if err := s.client.SetSessions(sessions); err != nil {
+		return fmt.Errorf("failed to store session cookies: %w", err)
 	}
-
-	store.SetCookies(url, strings.Join(lines, "\n"))
 	return nil
 }
 
@@ -124,7 +183,7 @@ func (s *Scraper) RunAll(ctx context.Context) error {
 	detailCollector := s.client.GetDetailCollector()
 
 	s.setupHandlers(ctx, collector)
-	s.setupDetailHandlers(ctx, detailCollector)
+	s.setupDetailHandlers(ctx, detailCollector, true)
 
 	queueSize, err := s.client.QueueSize()
 	if err != nil {
@@ -144,56 +203,98 @@ func (s *Scraper) RunAll(ctx context.Context) error {
 			return fmt.Errorf("failed to clear visited table: %w", err)
 		}
 
-		// Phase 1: visit all 5 seed listing pages. Each page visit follows pagination
-		// via e.Request.Visit (synchronous, collector's WaitGroup tracks it) and
-		// enqueues discovered detail page URLs into colly.db via EnqueueURLWithContext.
-		// TODO: allow user to specify initial URL
-		michelinGuideURLs := map[string]string{
-			models.ThreeStars:          "https://guide.michelin.com/en/restaurants/3-stars-michelin",
-			models.TwoStars:            "https://guide.michelin.com/en/restaurants/2-stars-michelin",
-			models.OneStar:             "https://guide.michelin.com/en/restaurants/1-star-michelin",
-			models.BibGourmand:         "https://guide.michelin.com/en/restaurants/bib-gourmand",
-			models.SelectedRestaurants: "https://guide.michelin.com/en/restaurants/the-plate-michelin",
-		}
-
-		for _, url := range michelinGuideURLs {
-			if err := collector.Visit(url); err != nil {
-				log.WithField("url", url).WithError(err).Error("failed to visit seed url")
+		// Phase 1: enqueue detail page URLs into colly.db, from the sitemaps
+		// or by paging through the listing pages.
+		if s.discovery == DiscoverySitemap {
+			added, err := s.discoverSitemaps(ctx)
+			if err != nil {
+				log.WithError(err).Warn("sitemap discovery failed, falling back to listing pages")
+				s.discoverListings(collector)
+			} else if len(added) > 0 {
+				s.discoverAdded(collector, added)
 			}
+		} else {
+			s.discoverListings(collector)
 		}
 	}
+	if s.expired.Load() {
+		return ErrSessionExpired
+	}
 
 	// Phase 2: drain all ~18k detail page URLs accumulated in colly.db queue
 	log.Info("starting detail scrape, draining queue")
 	if err := s.client.RunQueue(detailCollector); err != nil {
 		return err
 	}
+	if s.expired.Load() {
+		return ErrSessionExpired
+	}
 
-	log.WithField("scraped", s.scraped.Load()).Info("completed scraping")
+	log.WithFields(log.Fields{
+		"scraped":   s.scraped.Load(),
+		"unchanged": s.unchanged.Load(),
+	}).Info("completed scraping")
+	for _, stats := range s.client.SessionStats() {
+		log.WithFields(log.Fields{
+			"active":    stats.Active,
+			"requests":  stats.Requests,
+			"rotations": stats.Rotations,
+			"session":   stats.Name,
+		}).Info("session usage")
+	}
+	s.client.LogProxyUsage()
 	return nil
 }
 
+// discoverListings visits all 5 seed listing pages. Each page visit follows
+// pagination via e.Request.Visit (synchronous, collector's WaitGroup tracks
+// it) and enqueues discovered detail page URLs into colly.db via
+// EnqueueURLWithContext.
+func (s *Scraper) discoverListings(collector *colly.Collector) {
+	// TODO: allow user to specify initial URL
+	michelinGuideURLs := map[string]string{
+		models.ThreeStars:          "https://guide.michelin.com/en/restaurants/3-stars-michelin",
+		models.TwoStars:            "https://guide.michelin.com/en/restaurants/2-stars-michelin",
+		models.OneStar:             "https://guide.michelin.com/en/restaurants/1-star-michelin",
+		models.BibGourmand:         "https://guide.michelin.com/en/restaurants/bib-gourmand",
+		models.SelectedRestaurants: "https://guide.michelin.com/en/restaurants/the-plate-michelin",
+	}
+
+	for _, url := range michelinGuideURLs {
+		if err := collector.Visit(url); err != nil {
+			log.WithField("url", url).WithError(err).Error("failed to visit seed url")
+		}
+	}
+}
+
 // Run scrapes a single restaurant URL for its details.
 func (s *Scraper) Run(ctx context.Context, url string) error {
 	log.WithField("url", url).Debug("running scrape for restaurant")
 
 	detailCollector := s.client.GetDetailCollector()
-	s.setupDetailHandlers(ctx, detailCollector)
+	s.setupDetailHandlers(ctx, detailCollector, false)
 
 	err := detailCollector.Visit(url)
 	if err != nil {
 		log.WithError(err).WithField("url", url).Error("failed to visit restaurant URL")
 		return err
 	}
+	if s.expired.Load() {
+		return ErrSessionExpired
+	}
 
 	log.WithField("url", url).Debug("completed scraping for one restaurant")
 	return nil
 }
 
 func (s *Scraper) setupHandlers(ctx context.Context, collector *colly.Collector) {
-	collector.OnError(s.createErrorHandler())
+	collector.OnError(s.createErrorHandler(false))
 
 	collector.OnRequest(func(r *colly.Request) {
+		if s.expired.Load() {
+			r.Abort()
+			return
+		}
 		r.Headers.Set("Accept-Language", "en-SG,en;q=0.9")
 
 		attempt := r.Ctx.GetAny("attempt")
@@ -213,9 +314,10 @@ func (s *Scraper) setupHandlers(ctx context.Context, collector *colly.Collector)
 
 	collector.OnResponse(func(r *colly.Response) {
 		if r.StatusCode == http.StatusAccepted {
-			s.retryAccepted(r, "restaurant listing page")
+			s.retryAccepted(r)
 			return
 		}
+		s.writeWARC(r)
 
 		log.WithFields(log.Fields{
 			"cache_hit":   r.Ctx.GetAny("cache_hit"),
@@ -228,6 +330,13 @@ func (s *Scraper) setupHandlers(ctx context.Context, collector *colly.Collector)
 		// In 202, this won't run; no need to handle this codepath.
 		url := e.Request.AbsoluteURL(e.ChildAttr(xPathRestaurantCardLink, "href"))
 		location := e.ChildText(xPathRestaurantCardLocation)
+		if s.added != nil {
+			key := parsers.URLKey(url)
+			if _, ok := s.added[key]; !ok {
+				return
+			}
+			delete(s.added, key)
+		}
 
 		// Enqueue the detail URL into colly.db so phase 2 (RunQueue) can
 		// process it with detailCollector. EnqueueURLWithContext is required
@@ -249,26 +358,79 @@ func (s *Scraper) setupHandlers(ctx context.Context, collector *colly.Collector)
 }
 
 // retryAccepted handles a 202 response from Michelin Guide, which (almost)
-// always indicates session expiry
-func (s *Scraper) retryAccepted(r *colly.Response, requestType string) {
-	fields := log.Fields{
-		"request_type": requestType,
-		"status_code":  r.StatusCode,
-		"url":          r.Request.URL,
+// always indicates session expiry. With a session pool the expired session
+// has been rotated out and the request goes out again with the next one.
+func (s *Scraper) retryAccepted(r *colly.Response) {
+	s.handleError(client.ScraperRetryPolicy(s.config.MaxRetry), r, nil)
+}
+
+// notModified handles a 304 to a conditional request. The restaurant is marked
+// seen without reparsing, unless the database has nothing to mark, in which
+// case the page is fetched again in full.
+func (s *Scraper) notModified(ctx context.Context, r *colly.Response) {
+	if s.markSeen(ctx, r) {
+		return
+	}
+	fields := log.Fields{"url": r.Request.URL}
+	if err := s.client.ForgetValidators(r.Request.URL.String()); err != nil {
+		log.WithFields(fields).WithError(err).Error("failed to forget page validators")
+		return
 	}
+	log.WithFields(fields).Info("restaurant not modified but missing from the database, refetching")
+	if err := r.Request.Retry(); err != nil {
+		log.WithFields(fields).WithError(err).Error("failed to retry request")
+	}
+}
 
-	if err := s.client.ClearCache(r.Request); err != nil {
-		log.WithFields(fields).WithError(err).Warn("failed to clear cache")
+// markSeen records that a restaurant page has not changed since it was last
+// processed. It reports false when there is no live capture to mark.
+func (s *Scraper) markSeen(ctx context.Context, r *colly.Response) bool {
+	url := parsers.CanonicalURL(r.Request.URL.String())
+	seen, err := s.repository.MarkSeen(ctx, url)
+	if err != nil {
+		log.WithError(err).WithField("url", url).Warn("failed to mark restaurant seen")
+		return false
 	}
+	if seen {
+		s.unchanged.Add(1)
+		log.WithFields(log.Fields{
+			"status_code": r.StatusCode,
+			"url":         url,
+		}).Debug("restaurant unchanged, marked seen")
+	}
+	return seen
+}
 
-	log.WithFields(fields).Error("session expired")
-	os.Exit(2)
+// writeWARC records a response in the WARC output, if enabled. Cache replays
+// are skipped so every record reflects an actual fetch.
+func (s *Scraper) writeWARC(r *colly.Response) {
+	if s.warc == nil {
+		return
+	}
+	if cacheHit, _ := r.Ctx.GetAny("cache_hit").(bool); cacheHit {
+		return
+	}
+	if err := s.warc.WriteResponse(r); err != nil {
+		log.WithError(err).WithField("url", r.Request.URL).Warn("failed to write WARC record")
+	}
 }
 
-func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *colly.Collector) {
-	detailCollector.OnError(s.createErrorHandler())
+// setupDetailHandlers registers the restaurant detail handlers. requeue is
+// set when the requests come from the crawl queue, so that rate limited ones
+// can be put back on it.
+func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *colly.Collector, requeue bool) {
+	detailCollector.OnError(s.createErrorHandler(requeue))
+	detailCollector.OnError(func(r *colly.Response, err error) {
+		if r.StatusCode == http.StatusNotModified {
+			s.notModified(ctx, r)
+		}
+	})
 
 	detailCollector.OnRequest(func(r *colly.Request) {
+		if s.expired.Load() {
+			r.Abort()
+			return
+		}
 		r.Headers.Set("Accept-Language", "en-SG,en;q=0.9")
 
 		attempt := r.Ctx.GetAny("attempt")
@@ -289,7 +451,24 @@ func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *coll
 
 	detailCollector.OnResponse(func(r *colly.Response) {
 		if r.StatusCode == http.StatusAccepted {
-			s.retryAccepted(r, "restaurant detail")
+			s.retryAccepted(r)
+			return
+		}
+		s.writeWARC(r)
+		if r.StatusCode == http.StatusOK {
+			// The capture is dated like the archived page, so that reparsing
+			// the page updates that capture.
+			page := archive.PageFromResponse(r)
+			r.Ctx.Put("fetched_at", page.FetchedAt)
+			if err := s.archive.Save(page); err != nil {
+				log.WithError(err).WithField("url", r.Request.URL).Warn("failed to archive restaurant page")
+			}
+			// Pages without validators can still be recognised by their body.
+			if unchanged, err := s.client.Unchanged(r); err != nil {
+				log.WithError(err).WithField("url", r.Request.URL).Warn("failed to compare restaurant page")
+			} else if unchanged && s.markSeen(ctx, r) {
+				r.Ctx.Put("unchanged", true)
+			}
 		}
 	})
 
@@ -298,6 +477,9 @@ func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *coll
 			// 202 retries are handled in OnResponse; ignore this response body.
 			return
 		}
+		if unchanged, _ := e.Request.Ctx.GetAny("unchanged").(bool); unchanged {
+			return
+		}
 
 		err := handlers.Handle(ctx, e, s.repository)
 		if err != nil {
@@ -305,63 +487,32 @@ func (s *Scraper) setupDetailHandlers(ctx context.Context, detailCollector *coll
 			return
 		}
 		s.scraped.Add(1)
+		if err := s.client.RecordValidators(e.Response); err != nil {
+			log.WithError(err).WithField("url", e.Request.URL).Warn("failed to record page validators")
+		}
 	})
 }
 
-// createErrorHandler creates a reusable error handler for collectors with retry logic.
-func (s *Scraper) createErrorHandler() func(*colly.Response, error) {
+// createErrorHandler creates a reusable error handler for collectors with
+// retry logic. With requeue, rate limited requests go back on the crawl queue
+// once no other session is available.
+func (s *Scraper) createErrorHandler(requeue bool) func(*colly.Response, error) {
+	policy := client.ScraperRetryPolicy(s.config.MaxRetry)
+	policy.Requeue = requeue
 	return func(r *colly.Response, err error) {
-		attempt := 1
-		if v := r.Ctx.GetAny("attempt"); v != nil {
-			if a, ok := v.(int); ok {
-				attempt = a
-			}
-		}
-
-		cookies := s.client.GetCookies(r.Request.URL.String())
-		fields := log.Fields{
-			"attempt":      attempt,
-			"cookie_count": len(cookies),
-			"status_code":  r.StatusCode,
-			"url":          r.Request.URL,
-		}
-
-		if strings.Contains(err.Error(), "already visited") {
-			log.WithError(err).WithFields(fields).Debug("already visited, skip retry")
-			return
-		}
-
-		// status 0 means no HTTP response was received (transport-level failure).
-		// context.Canceled means the program is shutting down — retrying is pointless
-		// and delays shutdown by burning through all MaxRetry attempts.
-		if errors.Is(err, context.Canceled) {
-			log.WithError(err).WithFields(fields).Debug("context canceled, skip retry")
-			return
-		}
-
-		switch r.StatusCode {
-		case http.StatusTooManyRequests:
-			log.WithError(err).WithFields(fields).Warn("request rate limited, skip retry")
-			return
-		case http.StatusNotFound:
-			log.WithError(err).WithFields(fields).Debug("request not found, skip retry")
-			return
-		}
-
-		shouldRetry := attempt < s.config.MaxRetry
-		if shouldRetry {
-			if err := s.client.ClearCache(r.Request); err != nil {
-				log.WithError(err).WithFields(fields).WithField("request_headers", utils.FlattenHeaders(r.Request.Headers)).Error("failed to clear cache")
-			}
-
-			backoff := time.Duration(attempt) * s.config.Delay
-			log.WithFields(fields).WithField("backoff", backoff).Debug("failed request, retrying")
-			time.Sleep(backoff)
+		s.handleError(policy, r, err)
+	}
+}
 
-			r.Ctx.Put("attempt", attempt+1)
-			r.Request.Retry()
-		} else {
-			log.WithError(err).WithFields(fields).WithField("request_headers", utils.FlattenHeaders(r.Request.Headers)).Error("failed request, max retries reached")
-		}
+// handleError applies the retry policy and stops the run once every session
+// has expired. The run then returns ErrSessionExpired, and what is left on the
+// queue is kept for the next run.
+func (s *Scraper) handleError(policy client.RetryPolicy, r *colly.Response, err error) {
+	if s.client.HandleError(policy, r, err) != client.RetryAbort {
+		return
+	}
+	if s.expired.CompareAndSwap(false, true) {
+		log.WithField("url", r.Request.URL).Error("session expired, stopping")
+		s.client.StopQueue()
 	}
 }
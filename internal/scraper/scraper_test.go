@@ -0,0 +1,152 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/archive"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/client"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/storage"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/warc"
+)
+
+func TestClientConfigRoutesThroughConfiguredProxies(t *testing.T) {
+	t.Setenv("MYM_PROXIES", "http://proxy.test:3128, socks5://proxy.test:1080")
+	t.Setenv("MYM_PROXY_MODE", client.ProxySticky)
+
+	cfg := defaultConfig()
+	cfg.CookiePath = "" // no session needed to build the client
+	cfg.StoragePath = filepath.Join(t.TempDir(), "colly.db")
+	clientCfg := clientConfig(cfg, nil, true)
+	if clientCfg.ProxyMode != client.ProxySticky {
+		t.Errorf("expected proxy mode %q, got %q", client.ProxySticky, clientCfg.ProxyMode)
+	}
+
+	cl, err := client.New(clientCfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	if stats := cl.ProxyStats(); len(stats) != 2 || stats[1].URL != "socks5://proxy.test:1080" {
+		t.Errorf("expected the client to use both proxies, got %+v", stats)
+	}
+}
+
+// newTestScraper returns a Scraper with its client, database, archive and
+// WARC output under dir, and no session to rotate to.
+func newTestScraper(t *testing.T, dir string) *Scraper {
+	t.Helper()
+	cfg := &client.Config{
+		StoragePath: filepath.Join(dir, "colly.db"),
+		ThreadCount: 1,
+	}
+	cl, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	repo, err := storage.NewSQLiteRepository(filepath.Join(dir, "michelin.db"), nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository: %v", err)
+	}
+	store, err := archive.Open(filepath.Join(dir, "archive.db"))
+	if err != nil {
+		t.Fatalf("archive.Open: %v", err)
+	}
+	w, err := warc.NewWriter(dir, "scrape", warc.DefaultMaxSize)
+	if err != nil {
+		t.Fatalf("warc.NewWriter: %v", err)
+	}
+	return &Scraper{
+		archive:    store,
+		client:     cl,
+		config:     cfg,
+		repository: repo,
+		warc:       w,
+	}
+}
+
+func TestSessionExpiryPagesAreNotArchived(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/expired" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		io.WriteString(w, "<html><body>"+r.URL.Path+"</body></html>")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	s := newTestScraper(t, dir)
+	collector := s.client.GetDetailCollector()
+	s.setupDetailHandlers(context.Background(), collector, false)
+	for _, path := range []string{"/ok", "/expired"} {
+		if err := collector.Visit(srv.URL + path); err != nil {
+			t.Fatalf("Visit(%s): %v", path, err)
+		}
+	}
+	if !s.expired.Load() {
+		t.Fatal("expected the 202 to expire the run")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store, err := archive.Open(filepath.Join(dir, "archive.db"))
+	if err != nil {
+		t.Fatalf("archive.Open: %v", err)
+	}
+	defer store.Close()
+	var archived []string
+	if err := store.Each("", func(p *archive.Page) error {
+		archived = append(archived, p.URL)
+		return nil
+	}); err != nil {
+		t.Fatalf("archive.Each: %v", err)
+	}
+	if len(archived) != 1 || archived[0] != srv.URL+"/ok" {
+		t.Errorf("expected only the 200 page to be archived, got %v", archived)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "scrape-*.warc.gz"))
+	var recorded []string
+	for _, file := range files {
+		recorded = append(recorded, warcResponses(t, file)...)
+	}
+	if len(recorded) != 1 || recorded[0] != srv.URL+"/ok" {
+		t.Errorf("expected only the 200 page in the WARC output, got %v", recorded)
+	}
+}
+
+// warcResponses returns the target URIs of the response records in a WARC
+// file, whatever their status.
+func warcResponses(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer file.Close()
+	reader, err := warc.NewReader(file)
+	if err != nil {
+		t.Fatalf("warc.NewReader: %v", err)
+	}
+
+	var uris []string
+	for {
+		rec, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return uris
+		}
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if rec.Type() == "response" {
+			uris = append(uris, rec.TargetURI())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/sitemap"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DiscoveryListing pages through the distinction listing pages.
+	DiscoveryListing = "listing"
+	// DiscoverySitemap finds detail pages through the sitemaps listed in
+	// robots.txt, falling back to DiscoveryListing if that fails.
+	DiscoverySitemap = "sitemap"
+
+	robotsURL = "https://guide.michelin.com/robots.txt"
+
+	// maxSitemapDepth bounds how far sitemap indexes are followed.
+	maxSitemapDepth = 3
+)
+
+// DiscoveryModes lists the accepted discovery modes, the default first.
+var DiscoveryModes = []string{DiscoveryListing, DiscoverySitemap}
+
+// errNoSitemapEntries is returned when the sitemaps list no restaurants, e.g.
+// because their layout changed.
+var errNoSitemapEntries = errors.New("no restaurants found in sitemaps")
+
+// discoverSitemaps enqueues the detail pages listed in the guide's sitemaps
+// that are modified since the restaurant was last saved or found unchanged.
+// The others are marked seen, so that they stay in the daily export. Only
+// English pages are taken, as from the listing pages.
+//
+// Restaurants missing from the database are returned by URL key instead of
+// enqueued: the sitemaps do not give their location, which the caller takes
+// from the listing pages.
+func (s *Scraper) discoverSitemaps(ctx context.Context) (map[string]string, error) {
+	restaurants, err := s.repository.ListRestaurants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restaurants: %w", err)
+	}
+	// A page skipped as unchanged only moves its capture's last_seen_at on,
+	// so that is compared to lastmod as well as the restaurant's updated_at.
+	lastSeen, err := s.repository.ListLastSeen(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list last seen restaurants: %w", err)
+	}
+	known := make(map[string]*models.Restaurant, len(restaurants))
+	for i := range restaurants {
+		r := &restaurants[i]
+		known[parsers.URLKey(r.URL)] = r
+		for _, alias := range r.Aliases {
+			known[parsers.URLKey(alias.URL)] = r
+		}
+	}
+
+	fetch := s.sitemapFetcher()
+	robots, err := fetch(robotsURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch robots.txt: %w", err)
+	}
+	locs := sitemap.ParseRobots(robots)
+	if len(locs) == 0 {
+		return nil, errors.New("robots.txt lists no sitemaps")
+	}
+
+	var (
+		listed, enqueued, unchanged int
+		seen                        = make(map[string]bool)
+		added                       = make(map[string]string)
+	)
+	var walk func(loc string, depth int) error
+	walk = func(loc string, depth int) error {
+		body, err := fetch(loc)
+		if err != nil {
+			return fmt.Errorf("failed to fetch sitemap %s: %w", loc, err)
+		}
+		sm, err := sitemap.Parse(body)
+		if err != nil {
+			return fmt.Errorf("failed to read sitemap %s: %w", loc, err)
+		}
+		for _, child := range sm.Sitemaps {
+			if depth >= maxSitemapDepth || !strings.Contains(strings.ToLower(child.Loc), "restaurant") {
+				continue
+			}
+			if err := walk(child.Loc, depth+1); err != nil {
+				log.WithError(err).WithField("sitemap", child.Loc).Warn("skipping sitemap")
+			}
+		}
+
+		for _, entry := range sm.URLs {
+			u, ok := englishRestaurantURL(entry.Loc)
+			key := parsers.URLKey(u)
+			if !ok || seen[key] {
+				continue
+			}
+			seen[key] = true
+			listed++
+
+			r, ok := known[key]
+			if !ok {
+				added[key] = u
+				continue
+			}
+			seenAt := r.UpdatedAt
+			if t := lastSeen[r.ID]; t.After(seenAt) {
+				seenAt = t
+			}
+			if !entry.LastMod.IsZero() && !entry.LastMod.After(seenAt) {
+				marked, err := s.repository.MarkSeen(ctx, r.URL)
+				if err != nil {
+					return fmt.Errorf("failed to mark restaurant seen: %w", err)
+				}
+				// Without a live capture to mark, the page is scraped again.
+				if marked {
+					unchanged++
+					continue
+				}
+			}
+			if err := s.client.EnqueueURLWithContext(r.URL, r.Location); err != nil {
+				return fmt.Errorf("failed to enqueue detail url: %w", err)
+			}
+			enqueued++
+		}
+		return nil
+	}
+	for _, loc := range locs {
+		if err := walk(loc, 1); err != nil {
+			log.WithError(err).WithField("sitemap", loc).Warn("skipping sitemap")
+		}
+	}
+
+	if listed == 0 {
+		return nil, errNoSitemapEntries
+	}
+	s.unchanged.Add(int64(unchanged))
+	log.WithFields(log.Fields{
+		"added":     len(added),
+		"enqueued":  enqueued,
+		"listed":    listed,
+		"unchanged": unchanged,
+	}).Info("discovered restaurants from sitemaps")
+	return added, nil
+}
+
+// discoverAdded enqueues the restaurants found in the sitemaps but not in the
+// database, by URL key, from the listing pages so that they carry the location
+// those give. Any the listing pages do not show are enqueued without one.
+func (s *Scraper) discoverAdded(collector *colly.Collector, added map[string]string) {
+	s.added = added
+	s.discoverListings(collector)
+	s.added = nil
+	if s.expired.Load() {
+		return
+	}
+
+	for _, u := range added {
+		if err := s.client.EnqueueURLWithContext(u, ""); err != nil {
+			log.WithError(err).WithField("url", u).Warn("failed to enqueue detail url")
+		}
+	}
+	log.WithField("unlisted", len(added)).Info("enqueued new restaurants from listing pages")
+}
+
+// sitemapFetcher returns a function that fetches robots.txt and sitemaps.
+// They change between runs, so they bypass the cache and the visited table.
+func (s *Scraper) sitemapFetcher() func(rawURL string) ([]byte, error) {
+	collector := s.client.GetDetailCollector()
+	collector.AllowURLRevisit = true
+
+	var body []byte
+	collector.OnRequest(func(r *colly.Request) {
+		r.Headers.Set("Cache-Control", "no-cache")
+		log.WithField("url", r.URL).Debug("requesting sitemap")
+	})
+	collector.OnResponse(func(r *colly.Response) {
+		body = r.Body
+	})
+
+	return func(rawURL string) ([]byte, error) {
+		body = nil
+		if err := collector.Visit(rawURL); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+}
+
+// englishRestaurantURL returns the canonical form of an English restaurant
+// detail page URL, e.g. /en/ or /sg/en/ followed by .../restaurant/<name>.
+func englishRestaurantURL(rawURL string) (string, bool) {
+	u := parsers.NormalizeURL(rawURL)
+	path, ok := strings.CutPrefix(u, "https://guide.michelin.com/")
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[len(parts)-2] != "restaurant" || parts[len(parts)-1] == "" {
+		return "", false
+	}
+	if parts[0] != "en" && parts[1] != "en" {
+		return "", false
+	}
+	return parsers.CanonicalURL(u), true
+}
@@ -0,0 +1,106 @@
+// Package sitemap reads the sitemaps a site lists in its robots.txt, as
+// described on https://www.sitemaps.org/protocol.html.
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// Entry is a <sitemap> of a sitemap index or a <url> of a urlset. LastMod is
+// zero when the sitemap gives none.
+type Entry struct {
+	Loc     string
+	LastMod time.Time
+}
+
+// Sitemap is a parsed sitemap file. An index lists Sitemaps, a urlset URLs.
+type Sitemap struct {
+	Sitemaps []Entry
+	URLs     []Entry
+}
+
+// lastModLayouts are the W3C Datetime forms used for lastmod.
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// ParseRobots returns the sitemap URLs declared in a robots.txt.
+func ParseRobots(body []byte) []string {
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), ":")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "sitemap") {
+			continue
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			urls = append(urls, value)
+		}
+	}
+	return urls
+}
+
+// Parse reads a sitemap index or urlset, gzipped or not.
+func Parse(body []byte) (*Sitemap, error) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzipped sitemap: %w", err)
+		}
+		body, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzipped sitemap: %w", err)
+		}
+	}
+
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap: %w", err)
+	}
+	if xmlquery.FindOne(doc, "/sitemapindex|/urlset") == nil {
+		return nil, errors.New("not a sitemap")
+	}
+	return &Sitemap{
+		Sitemaps: entries(doc, "/sitemapindex/sitemap"),
+		URLs:     entries(doc, "/urlset/url"),
+	}, nil
+}
+
+func entries(doc *xmlquery.Node, expr string) []Entry {
+	var out []Entry
+	for _, n := range xmlquery.Find(doc, expr) {
+		loc := n.SelectElement("loc")
+		if loc == nil || strings.TrimSpace(loc.InnerText()) == "" {
+			continue
+		}
+		e := Entry{Loc: strings.TrimSpace(loc.InnerText())}
+		if lastMod := n.SelectElement("lastmod"); lastMod != nil {
+			e.LastMod = parseLastMod(strings.TrimSpace(lastMod.InnerText()))
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// parseLastMod returns the time of a W3C Datetime, or zero if it is invalid.
+func parseLastMod(value string) time.Time {
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
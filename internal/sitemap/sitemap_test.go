@@ -0,0 +1,80 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := []byte(`User-agent: *
+Disallow: /search
+Sitemap: https://guide.michelin.com/sitemap.xml
+sitemap:https://guide.michelin.com/sitemap-restaurants.xml.gz
+
+# Sitemap: https://guide.michelin.com/commented.xml
+`)
+	got := ParseRobots(robots)
+	want := []string{
+		"https://guide.michelin.com/sitemap.xml",
+		"https://guide.michelin.com/sitemap-restaurants.xml.gz",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseRobots() = %v, want %v", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Run("sitemap index", func(t *testing.T) {
+		sm, err := Parse([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://guide.michelin.com/sitemap/restaurants-1.xml</loc><lastmod>2026-03-01T08:00:00+00:00</lastmod></sitemap>
+  <sitemap><loc> https://guide.michelin.com/sitemap/articles.xml </loc></sitemap>
+</sitemapindex>`))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if len(sm.URLs) != 0 || len(sm.Sitemaps) != 2 {
+			t.Fatalf("expected two child sitemaps, got %+v", sm)
+		}
+		if sm.Sitemaps[1].Loc != "https://guide.michelin.com/sitemap/articles.xml" || !sm.Sitemaps[1].LastMod.IsZero() {
+			t.Errorf("unexpected entry: %+v", sm.Sitemaps[1])
+		}
+		if want := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC); !sm.Sitemaps[0].LastMod.Equal(want) {
+			t.Errorf("expected lastmod %v, got %v", want, sm.Sitemaps[0].LastMod)
+		}
+	})
+
+	t.Run("gzipped urlset", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://guide.michelin.com/en/restaurant/a</loc><lastmod>2026-02-14</lastmod></url>
+  <url><loc>https://guide.michelin.com/en/restaurant/b</loc><lastmod>not a date</lastmod></url>
+  <url><lastmod>2026-02-14</lastmod></url>
+</urlset>`))
+		zw.Close()
+
+		sm, err := Parse(buf.Bytes())
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if len(sm.URLs) != 2 {
+			t.Fatalf("expected two urls, got %+v", sm.URLs)
+		}
+		if want := time.Date(2026, 2, 14, 0, 0, 0, 0, time.UTC); !sm.URLs[0].LastMod.Equal(want) {
+			t.Errorf("expected lastmod %v, got %v", want, sm.URLs[0].LastMod)
+		}
+		if !sm.URLs[1].LastMod.IsZero() {
+			t.Errorf("expected an invalid lastmod to be ignored, got %v", sm.URLs[1].LastMod)
+		}
+	})
+
+	t.Run("not a sitemap", func(t *testing.T) {
+		if _, err := Parse([]byte("<html><body>Access denied</body></html>")); err == nil {
+			t.Error("expected an error for an HTML page")
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
+)
+
+// MergeAction is what SaveAward does with an incoming award when one already
+// exists for the same restaurant and year.
+type MergeAction int
+
+const (
+	MergeKeep    MergeAction = iota // leave the existing award untouched
+	MergeReplace                    // replace the existing award with the incoming one
+)
+
+// MergeDecision is the outcome of a MergePolicy.
+type MergeDecision struct {
+	Action MergeAction
+	Reason string
+}
+
+// MergePolicy decides between an existing award and an incoming one for the
+// same restaurant and year. Where each came from is given by its WaybackURL:
+// empty for live scrapes, the archive URL for backfills.
+type MergePolicy interface {
+	Merge(existing, incoming *models.RestaurantAward) MergeDecision
+}
+
+const (
+	PolicyDefault           = "default"
+	PolicyLiveAlwaysWins    = "live-always-wins"
+	PolicyNewestCaptureWins = "newest-capture-wins"
+)
+
+// MergePolicies lists the selectable policies by name.
+var MergePolicies = map[string]MergePolicy{
+	PolicyDefault:           DefaultPolicy{},
+	PolicyLiveAlwaysWins:    LiveAlwaysWinsPolicy{},
+	PolicyNewestCaptureWins: NewestCaptureWinsPolicy{},
+}
+
+// MergePolicyEnv names the environment variable that selects the merge policy
+// of the commands that save awards.
+const MergePolicyEnv = "MYM_MERGE_POLICY"
+
+// MergePolicyFromEnv returns the policy named by MYM_MERGE_POLICY, or
+// DefaultPolicy when it is unset.
+func MergePolicyFromEnv() (MergePolicy, error) {
+	return MergePolicyByName(os.Getenv(MergePolicyEnv))
+}
+
+// MergePolicyByName returns the named policy, or DefaultPolicy for "".
+func MergePolicyByName(name string) (MergePolicy, error) {
+	if name == "" {
+		return DefaultPolicy{}, nil
+	}
+	policy, ok := MergePolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown merge policy %q: want one of %s", name, strings.Join([]string{PolicyDefault, PolicyLiveAlwaysWins, PolicyNewestCaptureWins}, ", "))
+	}
+	return policy, nil
+}
+
+// DefaultPolicy treats archived awards as authoritative for their year, except
+// that a live scrape may upgrade an archived Selected Restaurants award, which
+// is what ExtractDistinction falls back to when no selector matched.
+type DefaultPolicy struct{}
+
+func (DefaultPolicy) Merge(existing, incoming *models.RestaurantAward) MergeDecision {
+	switch {
+	case existing.WaybackURL == "" && incoming.WaybackURL == "":
+		if awardsEqual(existing, incoming) {
+			return MergeDecision{MergeKeep, "live award unchanged"}
+		}
+		return MergeDecision{MergeReplace, "newer live scrape"}
+	case incoming.WaybackURL != "":
+		return MergeDecision{MergeReplace, "wayback data is authoritative"}
+	case existing.Distinction == models.SelectedRestaurants && incoming.Distinction != models.SelectedRestaurants:
+		return MergeDecision{MergeReplace, "live scrape upgrades selected restaurants"}
+	default:
+		return MergeDecision{MergeKeep, "wayback priority"}
+	}
+}
+
+// LiveAlwaysWinsPolicy prefers live scrapes over archived data, and otherwise
+// behaves like DefaultPolicy.
+type LiveAlwaysWinsPolicy struct{}
+
+func (LiveAlwaysWinsPolicy) Merge(existing, incoming *models.RestaurantAward) MergeDecision {
+	switch {
+	case existing.WaybackURL != "" && incoming.WaybackURL == "":
+		if awardsEqual(existing, incoming) {
+			return MergeDecision{MergeKeep, "archived award matches live scrape"}
+		}
+		return MergeDecision{MergeReplace, "live scrape wins"}
+	case existing.WaybackURL == "" && incoming.WaybackURL != "":
+		return MergeDecision{MergeKeep, "live scrape wins"}
+	default:
+		return DefaultPolicy{}.Merge(existing, incoming)
+	}
+}
+
+// NewestCaptureWinsPolicy keeps whichever award was captured last: archived
+// awards at their snapshot time, existing live awards at their last update
+// and incoming live awards now.
+type NewestCaptureWinsPolicy struct{}
+
+func (NewestCaptureWinsPolicy) Merge(existing, incoming *models.RestaurantAward) MergeDecision {
+	if incoming.WaybackURL == "" && awardsEqual(existing, incoming) {
+		return MergeDecision{MergeKeep, "live scrape matches existing award"}
+	}
+	if !capturedAt(incoming, time.Now()).Before(capturedAt(existing, existing.UpdatedAt)) {
+		return MergeDecision{MergeReplace, "incoming capture is newer"}
+	}
+	return MergeDecision{MergeKeep, "existing capture is newer"}
+}
+
+// capturedAt returns the snapshot time of an archived award, or live for a
+// live one. Archive URLs that cannot be parsed count as oldest.
+func capturedAt(award *models.RestaurantAward, live time.Time) time.Time {
+	if award.WaybackURL == "" {
+		return live
+	}
+	t, _ := snapshotTime(award.WaybackURL)
+	return t
+}
+
+// snapshotTime returns the capture time encoded in an archive URL.
+func snapshotTime(archiveURL string) (time.Time, bool) {
+	c, ok := webarchive.Lookup(archiveURL)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(webarchive.TimestampLayout, c.Timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Rollup derives the yearly award from its captures by replaying them in
+// capture order through policy, captures taken at the same time in the order
+// they were recorded. The result does not depend on the order of captures.
+// An incoming capture without a price keeps the price rolled up so far.
+func Rollup(policy MergePolicy, captures []models.AwardCapture) *models.RestaurantAward {
+	if len(captures) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(captures)
+	slices.SortFunc(sorted, func(a, b models.AwardCapture) int {
+		if c := a.CapturedAt.Compare(b.CapturedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	award := sorted[0].Award()
+	for _, c := range sorted[1:] {
+		incoming := c.Award()
+		if policy.Merge(award, incoming).Action != MergeReplace {
+			continue
+		}
+		if incoming.Price == "" {
+			incoming.Price = award.Price
+		}
+		award = incoming
+	}
+	return award
+}
+
+// awardsEqual reports whether two awards carry the same distinction, price
+// and green star.
+func awardsEqual(a, b *models.RestaurantAward) bool {
+	return a.Distinction == b.Distinction &&
+		a.Price == b.Price &&
+		a.GreenStar == b.GreenStar
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+)
+
+func TestMergePolicies(t *testing.T) {
+	const (
+		older = "https://web.archive.org/web/20220101000000/https://guide.michelin.com/sg/en/a"
+		newer = "https://web.archive.org/web/20230101000000/https://guide.michelin.com/sg/en/a"
+	)
+	award := func(distinction, waybackURL string) *models.RestaurantAward {
+		return &models.RestaurantAward{Distinction: distinction, Price: "$$", WaybackURL: waybackURL, UpdatedAt: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)}
+	}
+
+	tests := []struct {
+		name     string
+		existing *models.RestaurantAward
+		incoming *models.RestaurantAward
+		want     map[string]MergeAction
+	}{
+		{
+			name:     "live rescrape unchanged",
+			existing: award(models.OneStar, ""),
+			incoming: award(models.OneStar, ""),
+			want:     map[string]MergeAction{PolicyDefault: MergeKeep, PolicyLiveAlwaysWins: MergeKeep, PolicyNewestCaptureWins: MergeKeep},
+		},
+		{
+			name:     "live rescrape changed",
+			existing: award(models.OneStar, ""),
+			incoming: award(models.TwoStars, ""),
+			want:     map[string]MergeAction{PolicyDefault: MergeReplace, PolicyLiveAlwaysWins: MergeReplace, PolicyNewestCaptureWins: MergeReplace},
+		},
+		{
+			name:     "older wayback over live",
+			existing: award(models.OneStar, ""),
+			incoming: award(models.TwoStars, older),
+			want:     map[string]MergeAction{PolicyDefault: MergeReplace, PolicyLiveAlwaysWins: MergeKeep, PolicyNewestCaptureWins: MergeKeep},
+		},
+		{
+			name:     "newer wayback over live",
+			existing: award(models.OneStar, ""),
+			incoming: award(models.TwoStars, newer),
+			want:     map[string]MergeAction{PolicyDefault: MergeReplace, PolicyLiveAlwaysWins: MergeKeep, PolicyNewestCaptureWins: MergeReplace},
+		},
+		{
+			name:     "live over wayback",
+			existing: award(models.OneStar, older),
+			incoming: award(models.TwoStars, ""),
+			want:     map[string]MergeAction{PolicyDefault: MergeKeep, PolicyLiveAlwaysWins: MergeReplace, PolicyNewestCaptureWins: MergeReplace},
+		},
+		{
+			name:     "live upgrades wayback selected restaurants",
+			existing: award(models.SelectedRestaurants, older),
+			incoming: award(models.BibGourmand, ""),
+			want:     map[string]MergeAction{PolicyDefault: MergeReplace, PolicyLiveAlwaysWins: MergeReplace, PolicyNewestCaptureWins: MergeReplace},
+		},
+		{
+			name:     "live matches wayback",
+			existing: award(models.OneStar, older),
+			incoming: award(models.OneStar, ""),
+			want:     map[string]MergeAction{PolicyDefault: MergeKeep, PolicyLiveAlwaysWins: MergeKeep, PolicyNewestCaptureWins: MergeKeep},
+		},
+		{
+			name:     "newer wayback over older wayback",
+			existing: award(models.OneStar, older),
+			incoming: award(models.TwoStars, newer),
+			want:     map[string]MergeAction{PolicyDefault: MergeReplace, PolicyLiveAlwaysWins: MergeReplace, PolicyNewestCaptureWins: MergeReplace},
+		},
+		{
+			name:     "older wayback over newer wayback",
+			existing: award(models.TwoStars, newer),
+			incoming: award(models.OneStar, older),
+			want:     map[string]MergeAction{PolicyDefault: MergeReplace, PolicyLiveAlwaysWins: MergeReplace, PolicyNewestCaptureWins: MergeKeep},
+		},
+	}
+
+	for name, policy := range MergePolicies {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				got := policy.Merge(tt.existing, tt.incoming)
+				if got.Action != tt.want[name] {
+					t.Errorf("Merge() = %v (%s); want %v", got.Action, got.Reason, tt.want[name])
+				}
+				if got.Reason == "" {
+					t.Errorf("Merge() should explain its decision")
+				}
+			})
+		}
+	}
+}
+
+func TestMergePolicyByName(t *testing.T) {
+	if p, err := MergePolicyByName(""); err != nil || p != (DefaultPolicy{}) {
+		t.Errorf("MergePolicyByName(\"\") = %v, %v; want default", p, err)
+	}
+	if p, err := MergePolicyByName(PolicyLiveAlwaysWins); err != nil || p != (LiveAlwaysWinsPolicy{}) {
+		t.Errorf("MergePolicyByName(%q) = %v, %v", PolicyLiveAlwaysWins, p, err)
+	}
+	if _, err := MergePolicyByName("nope"); err == nil {
+		t.Errorf("MergePolicyByName(nope) should fail")
+	}
+}
+
+func TestRollup(t *testing.T) {
+	at := func(month time.Month) time.Time { return time.Date(2023, month, 1, 0, 0, 0, 0, time.UTC) }
+	captures := []models.AwardCapture{
+		{ID: 1, Distinction: models.OneStar, Price: "$$", GreenStar: true, WaybackURL: "https://web.archive.org/web/20230101000000/a", CapturedAt: at(1)},
+		{ID: 2, Distinction: models.TwoStars, Price: "", GreenStar: true, WaybackURL: "https://web.archive.org/web/20230601000000/a", CapturedAt: at(6)},
+		{ID: 3, Distinction: models.TwoStars, Price: "$$$", GreenStar: false, WaybackURL: "https://web.archive.org/web/20231001000000/a", CapturedAt: at(10)},
+		{ID: 4, Distinction: models.OneStar, Price: "$$", GreenStar: false, CapturedAt: at(11)},
+	}
+
+	tests := []struct {
+		name     string
+		policy   MergePolicy
+		captures []models.AwardCapture
+		want     models.RestaurantAward
+	}{
+		{
+			name:     "latest archived capture carries the price forward",
+			policy:   DefaultPolicy{},
+			captures: captures[:2],
+			want:     models.RestaurantAward{Distinction: models.TwoStars, Price: "$$", GreenStar: true, WaybackURL: captures[1].WaybackURL},
+		},
+		{
+			name:     "default keeps archived data over a later live scrape",
+			policy:   DefaultPolicy{},
+			captures: captures,
+			want:     models.RestaurantAward{Distinction: models.TwoStars, Price: "$$$", WaybackURL: captures[2].WaybackURL},
+		},
+		{
+			name:     "live always wins",
+			policy:   LiveAlwaysWinsPolicy{},
+			captures: captures,
+			want:     models.RestaurantAward{Distinction: models.OneStar, Price: "$$"},
+		},
+		{
+			name:     "same capture time falls back to recording order",
+			policy:   DefaultPolicy{},
+			captures: []models.AwardCapture{{ID: 2, Distinction: models.TwoStars, Price: "$$", CapturedAt: at(1)}, {ID: 1, Distinction: models.OneStar, Price: "$$", CapturedAt: at(1)}},
+			want:     models.RestaurantAward{Distinction: models.TwoStars, Price: "$$"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reversed := slices.Clone(tt.captures)
+			slices.Reverse(reversed)
+			for _, input := range [][]models.AwardCapture{tt.captures, reversed} {
+				got := Rollup(tt.policy, input)
+				if got.Distinction != tt.want.Distinction || got.Price != tt.want.Price ||
+					got.GreenStar != tt.want.GreenStar || got.WaybackURL != tt.want.WaybackURL {
+					t.Errorf("Rollup() = %+v; want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if Rollup(DefaultPolicy{}, nil) != nil {
+		t.Errorf("Rollup() without captures should be nil")
+	}
+}
@@ -2,21 +2,43 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
 )
 
 // RestaurantRepository defines the interface for restaurant data operations.
 type RestaurantRepository interface {
+	Close() error
+	DeleteOverride(ctx context.Context, restaurantID uint, year int) (bool, error)
+	FindBackfillState(ctx context.Context, url, archive string) (*models.BackfillState, error)
 	FindRestaurantByURL(ctx context.Context, url string) (*models.Restaurant, error)
+	ListConflicts(ctx context.Context, filter ConflictFilter) ([]models.AwardConflict, error)
+	ListLastSeen(ctx context.Context) (map[uint]time.Time, error)
+	ListOverrides(ctx context.Context, url string) ([]models.AwardOverride, error)
 	ListRestaurants(ctx context.Context) ([]models.Restaurant, error)
+	MarkSeen(ctx context.Context, url string) (bool, error)
+	MergeRestaurants(ctx context.Context, canonicalID uint, duplicateIDs []uint) error
 	SaveAward(ctx context.Context, award *models.RestaurantAward) error
+	SaveBackfillState(ctx context.Context, state *models.BackfillState) error
+	SaveOverride(ctx context.Context, override *models.AwardOverride) error
 	SaveRestaurant(ctx context.Context, restaurant *models.Restaurant) error
 }
 
+// ConflictFilter narrows down the award conflicts to list. Zero values match
+// everything.
+type ConflictFilter struct {
+	Year            int
+	Decision        string
+	URL             string // restaurant URL
+	DistinctionOnly bool   // only conflicts where the distinction differs
+	Limit           int
+}
+
 // RestaurantData holds the scraped restaurant information.
 type RestaurantData struct {
 	Address               string
+	Archive               string
 	Cuisine               string
 	Description           string
 	Distinction           string
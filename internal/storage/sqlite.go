@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -15,11 +19,13 @@ import (
 
 // SQLiteRepository implements RestaurantRepository using SQLite database
 type SQLiteRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	policy MergePolicy
 }
 
-// NewSQLiteRepository creates a new SQLite repository instance
-func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
+// NewSQLiteRepository creates a new SQLite repository instance that settles
+// awards with policy, or with DefaultPolicy when policy is nil.
+func NewSQLiteRepository(dbPath string, policy MergePolicy) (*SQLiteRepository, error) {
 	dsn := fmt.Sprintf("%s?_loc=UTC", dbPath)
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		PrepareStmt: true,
@@ -51,177 +57,728 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 		}
 	}
 
-	if err := db.AutoMigrate(&models.Restaurant{}, &models.RestaurantAward{}); err != nil {
+	if err := migrateBackfillStateKey(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate backfill state: %w", err)
+	}
+	if err := db.AutoMigrate(&models.Restaurant{}, &models.RestaurantAward{}, &models.BackfillState{}, &models.AwardConflict{}, &models.AwardOverride{}, &models.AwardCapture{}, &models.RestaurantAlias{}); err != nil {
 		return nil, fmt.Errorf("failed to auto-migrate models: %w", err)
 	}
 
-	return &SQLiteRepository{db: db}, nil
+	// Awards backfilled before other archives were supported all came from
+	// the Wayback Machine.
+	if err := db.Model(&models.RestaurantAward{}).
+		Where("wayback_url != '' AND archive = ''").
+		UpdateColumn("archive", webarchive.Wayback).Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate award archives: %w", err)
+	}
+
+	if policy == nil {
+		policy = DefaultPolicy{}
+	}
+	repo := &SQLiteRepository{db: db, policy: policy}
+	if err := repo.canonicalizeURLs(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to canonicalize restaurant urls: %w", err)
+	}
+	return repo, nil
+}
+
+// canonicalizeURLs moves restaurants saved under a URL that is no longer
+// canonical, e.g. with a /sg/en/ locale prefix, to their canonical URL and
+// keeps the old one as an alias, which backfill still queries. A restaurant
+// whose canonical URL is taken is merged into the one holding it. Aliases get
+// an alias of their canonical form too, so that saves under it resolve.
+func (r *SQLiteRepository) canonicalizeURLs(ctx context.Context) error {
+	var restaurants []models.Restaurant
+	if err := r.db.WithContext(ctx).Select("id", "url").Find(&restaurants).Error; err != nil {
+		return err
+	}
+	ids := make(map[string]uint, len(restaurants))
+	for _, restaurant := range restaurants {
+		ids[restaurant.URL] = restaurant.ID
+	}
+
+	var moved, merged int
+	for _, restaurant := range restaurants {
+		canonical := parsers.CanonicalURL(restaurant.URL)
+		if canonical == restaurant.URL {
+			continue
+		}
+		if id, ok := ids[canonical]; ok {
+			if err := r.MergeRestaurants(ctx, id, []uint{restaurant.ID}); err != nil {
+				return err
+			}
+			merged++
+			continue
+		}
+		err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&models.Restaurant{}).Where("id = ?", restaurant.ID).UpdateColumn("url", canonical).Error
+			if err != nil {
+				return err
+			}
+			return saveAlias(tx, restaurant.URL, restaurant.ID)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move restaurant %d to %s: %w", restaurant.ID, canonical, err)
+		}
+		ids[canonical] = restaurant.ID
+		moved++
+	}
+
+	var aliases []models.RestaurantAlias
+	if err := r.db.WithContext(ctx).Find(&aliases).Error; err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		known[alias.URL] = true
+	}
+	for _, alias := range aliases {
+		canonical := parsers.CanonicalURL(alias.URL)
+		if known[canonical] || ids[canonical] != 0 {
+			continue
+		}
+		if err := saveAlias(r.db.WithContext(ctx), canonical, alias.RestaurantID); err != nil {
+			return err
+		}
+		known[canonical] = true
+	}
+
+	if moved > 0 || merged > 0 {
+		log.WithFields(log.Fields{
+			"merged": merged,
+			"moved":  moved,
+		}).Info("moved restaurants to their canonical url")
+	}
+	return nil
+}
+
+// migrateBackfillStateKey rebuilds a backfill_state table keyed by url alone,
+// from before states were kept per archive. AutoMigrate cannot change the
+// primary key of an existing SQLite table. Those states all came from the
+// Wayback Machine.
+func migrateBackfillStateKey(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.BackfillState{}) {
+		return nil
+	}
+	var keys int
+	if err := db.Raw("SELECT COUNT(*) FROM pragma_table_info('backfill_state') WHERE pk > 0").Scan(&keys).Error; err != nil {
+		return err
+	}
+	if keys != 1 {
+		return nil
+	}
+
+	// A table AutoMigrate already ran on has an archive column left empty.
+	archive := "?"
+	if db.Migrator().HasColumn(&models.BackfillState{}, "archive") {
+		archive = "COALESCE(NULLIF(archive, ''), ?)"
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().RenameTable("backfill_state", "backfill_state_old"); err != nil {
+			return err
+		}
+		if err := tx.Migrator().CreateTable(&models.BackfillState{}); err != nil {
+			return err
+		}
+		err := tx.Exec(`INSERT INTO backfill_state (url, archive, last_queried_at, latest_snapshot, outcome, created_at, updated_at)
+			SELECT url, `+archive+`, last_queried_at, latest_snapshot, outcome, created_at, updated_at FROM backfill_state_old`,
+			webarchive.Wayback).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropTable("backfill_state_old")
+	})
 }
 
-// SaveRestaurant saves or updates a restaurant in the database
+// SaveRestaurant saves or updates a restaurant in the database. A restaurant
+// saved under an alias URL updates the restaurant the alias belongs to. An
+// UpdatedAt set by the caller, e.g. to when a replayed page was fetched, never
+// moves updated_at back.
 func (r *SQLiteRepository) SaveRestaurant(ctx context.Context, restaurant *models.Restaurant) error {
+	var alias models.RestaurantAlias
+	if err := r.db.WithContext(ctx).Preload("Restaurant").Where("url = ?", restaurant.URL).Limit(1).Find(&alias).Error; err != nil {
+		return fmt.Errorf("failed to resolve restaurant alias: %w", err)
+	}
+	if alias.RestaurantID != 0 {
+		log.WithFields(log.Fields{
+			"alias": restaurant.URL,
+			"url":   alias.Restaurant.URL,
+		}).Debug("saving restaurant under its canonical url")
+		restaurant.URL = alias.Restaurant.URL
+	}
+
 	log.WithFields(log.Fields{
 		"url":  restaurant.URL,
 		"name": restaurant.Name,
 	}).Debug("upserting restaurant") // Added name for easier human-reading in logs
 
+	updates := clause.AssignmentColumns([]string{
+		"name", "description", "address", "location",
+		"latitude", "longitude", "cuisine",
+		"facilities_and_services", "phone_number", "website_url",
+	})
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "updated_at"},
+		Value:  gorm.Expr("MAX(restaurants.updated_at, excluded.updated_at)"),
+	})
 	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "url"}},
-		DoUpdates: clause.AssignmentColumns([]string{
-			"name", "description", "address", "location",
-			"latitude", "longitude", "cuisine",
-			"facilities_and_services", "phone_number", "website_url",
-			"updated_at",
-		}),
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: updates,
 	}).Create(restaurant).Error
 }
 
-// SaveAward saves or updates a restaurant award in the database
+// SaveAward records an award observation as an AwardCapture and re-derives the
+// yearly award from the captures of that year with the merge policy. Fields
+// pinned by an AwardOverride are applied last. When either the existing or
+// the incoming award comes from an archive and they disagree, the outcome is
+// recorded as an AwardConflict.
 func (r *SQLiteRepository) SaveAward(ctx context.Context, award *models.RestaurantAward) error {
-	awardsEqual := func(a, b *models.RestaurantAward) bool {
-		return a.Distinction == b.Distinction &&
-			a.Price == b.Price &&
-			a.GreenStar == b.GreenStar
-	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.RestaurantAward
+		err := tx.Where("restaurant_id = ? AND year = ?", award.RestaurantID, award.Year).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.ID != 0 {
+			if err := seedCapture(tx, &existing); err != nil {
+				return err
+			}
+		}
+		if err := saveCapture(tx, award); err != nil {
+			return err
+		}
 
-	var existing models.RestaurantAward
-	err := r.db.WithContext(ctx).
-		Where("restaurant_id = ? AND year = ?", award.RestaurantID, award.Year).
-		First(&existing).Error
+		rolled, override, err := r.rollupAward(tx, award.RestaurantID, award.Year)
+		if err != nil {
+			return err
+		}
+		incoming := *award
+		if override != nil {
+			override.Apply(&incoming)
+		}
+
+		if existing.ID == 0 {
+			return tx.Create(rolled).Error
+		}
+
+		// Disagreements involving archived data are recorded for review; live
+		// rescrapes simply follow the guide.
+		if incoming.Price == "" {
+			incoming.Price = existing.Price
+		}
+		diff := awardDiff(&existing, &incoming)
+		if len(diff) > 0 && (existing.WaybackURL != "" || incoming.WaybackURL != "") {
+			fields := log.Fields{
+				"restaurant_id": existing.RestaurantID,
+				"year":          existing.Year,
+				"diff":          diff,
+			}
+			outcome := models.ConflictKept
+			switch {
+			case rolled.WaybackURL != incoming.WaybackURL || !awardsEqual(rolled, &incoming):
+				log.WithFields(fields).Debug("skipping award update")
+			case incoming.WaybackURL != "":
+				outcome = models.ConflictOverwritten
+			default:
+				outcome = models.ConflictUpgraded
+			}
+			if _, ok := diff["distinction"]; ok && outcome != models.ConflictKept {
+				log.WithFields(fields).Warn("overwriting award")
+			}
+			if err := saveConflict(tx, &existing, &incoming, outcome); err != nil {
+				return err
+			}
+		}
 
+		if awardsEqual(&existing, rolled) && existing.WaybackURL == rolled.WaybackURL && existing.Archive == rolled.Archive {
+			return nil
+		}
+		return tx.Model(&existing).Updates(map[string]any{
+			"distinction": rolled.Distinction,
+			"green_star":  rolled.GreenStar,
+			"price":       rolled.Price,
+			"wayback_url": rolled.WaybackURL,
+			"archive":     rolled.Archive,
+		}).Error
+	})
+}
+
+// rollupAward derives the award of a restaurant and year from its captures
+// and applies the override for that year, which it also returns.
+func (r *SQLiteRepository) rollupAward(tx *gorm.DB, restaurantID uint, year int) (*models.RestaurantAward, *models.AwardOverride, error) {
+	var captures []models.AwardCapture
+	err := tx.Where("restaurant_id = ? AND year = ?", restaurantID, year).Find(&captures).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load award captures: %w", err)
+	}
+	rolled := Rollup(r.policy, captures)
+	rolled.UpdatedAt = time.Time{}
+
+	override, err := findOverride(tx, restaurantID, year)
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return r.db.WithContext(ctx).Create(award).Error
+		return nil, nil, err
+	}
+	if override != nil {
+		if diff := override.Apply(rolled); len(diff) > 0 {
+			log.WithFields(log.Fields{
+				"restaurant_id": restaurantID,
+				"year":          year,
+				"source":        models.AwardSource(rolled),
+				"diff":          diff,
+			}).Info("applying award override")
 		}
+	}
+	return rolled, override, nil
+}
+
+// seedCapture records an award saved before captures were kept as the first
+// capture of its year.
+func seedCapture(tx *gorm.DB, award *models.RestaurantAward) error {
+	var count int64
+	err := tx.Model(&models.AwardCapture{}).Where("restaurant_id = ? AND year = ?", award.RestaurantID, award.Year).Count(&count).Error
+	if err != nil || count > 0 {
 		return err
 	}
+	capturedAt, ok := snapshotTime(award.WaybackURL)
+	if !ok {
+		capturedAt = award.UpdatedAt
+	}
+	return createCapture(tx, award, capturedAt, award.UpdatedAt)
+}
 
-	// Scenario 1: Both live scrape
-	if existing.WaybackURL == "" && award.WaybackURL == "" {
-		if awardsEqual(&existing, award) {
+// saveCapture records an award observation, dated by award.SeenAt. Saving an
+// archived snapshot or a live page again updates its capture, as reparsing may
+// correct it; a live page that shows the same award as the latest capture
+// before it only refreshes that capture.
+func saveCapture(tx *gorm.DB, award *models.RestaurantAward) error {
+	seenAt := award.SeenAt.UTC()
+	if award.SeenAt.IsZero() {
+		seenAt = time.Now().UTC()
+	}
+	values := map[string]any{
+		"distinction":  award.Distinction,
+		"green_star":   award.GreenStar,
+		"price":        award.Price,
+		"archive":      award.Archive,
+		"last_seen_at": seenAt,
+	}
+
+	var latest models.AwardCapture
+	query := tx.Where("restaurant_id = ? AND year = ?", award.RestaurantID, award.Year)
+	if award.WaybackURL != "" {
+		query = query.Where("wayback_url = ?", award.WaybackURL)
+	} else {
+		query = query.Where("captured_at <= ?", seenAt)
+	}
+	if err := query.Order("captured_at DESC, id DESC").Limit(1).Find(&latest).Error; err != nil {
+		return fmt.Errorf("failed to find award capture: %w", err)
+	}
+
+	sameLivePage := latest.WaybackURL == "" && latest.CapturedAt.Equal(seenAt)
+	switch {
+	case latest.ID != 0 && (award.WaybackURL != "" || sameLivePage):
+		if !slices.Contains(models.Distinctions, award.Distinction) {
+			return errors.New("distinction must be a valid value")
+		}
+		if latest.LastSeenAt.After(seenAt) {
+			delete(values, "last_seen_at")
+		}
+		return tx.Model(&latest).Updates(values).Error
+	case latest.ID != 0 && latest.WaybackURL == "" && awardsEqual(latest.Award(), award):
+		if latest.LastSeenAt.After(seenAt) {
 			return nil
 		}
+		return tx.Model(&latest).Update("last_seen_at", seenAt).Error
+	}
+
+	capturedAt, ok := snapshotTime(award.WaybackURL)
+	if !ok {
+		capturedAt = seenAt
+	}
+	return createCapture(tx, award, capturedAt, seenAt)
+}
+
+// createCapture inserts a capture of award.
+func createCapture(tx *gorm.DB, award *models.RestaurantAward, capturedAt, lastSeenAt time.Time) error {
+	capture := &models.AwardCapture{
+		RestaurantID: award.RestaurantID,
+		Year:         award.Year,
+		Distinction:  award.Distinction,
+		GreenStar:    award.GreenStar,
+		Price:        award.Price,
+		WaybackURL:   award.WaybackURL,
+		Archive:      award.Archive,
+		CapturedAt:   capturedAt.UTC(),
+		LastSeenAt:   lastSeenAt.UTC(),
+	}
+	if err := tx.Omit(clause.Associations).Create(capture).Error; err != nil {
+		return fmt.Errorf("failed to record award capture: %w", err)
+	}
+	return nil
+}
+
+// awardDiff describes the fields that differ between the existing and the
+// incoming award as "old → new".
+func awardDiff(existing, award *models.RestaurantAward) map[string]string {
+	diff := map[string]string{}
+	if existing.Distinction != award.Distinction {
+		diff["distinction"] = fmt.Sprintf("%v → %v", existing.Distinction, award.Distinction)
+	}
+	if existing.Price != award.Price {
+		diff["price"] = fmt.Sprintf("%v → %v", existing.Price, award.Price)
+	}
+	if existing.GreenStar != award.GreenStar {
+		diff["green_star"] = fmt.Sprintf("%v → %v", existing.GreenStar, award.GreenStar)
+	}
+	return diff
+}
+
+// saveConflict records the decision taken between the existing and the
+// incoming award. Seeing the same disagreement again refreshes the record.
+func saveConflict(tx *gorm.DB, existing, award *models.RestaurantAward, decision string) error {
+	conflict := &models.AwardConflict{
+		RestaurantID:        existing.RestaurantID,
+		Year:                existing.Year,
+		ExistingDistinction: existing.Distinction,
+		ExistingPrice:       existing.Price,
+		ExistingGreenStar:   existing.GreenStar,
+		ExistingSource:      models.AwardSource(existing),
+		IncomingDistinction: award.Distinction,
+		IncomingPrice:       award.Price,
+		IncomingGreenStar:   award.GreenStar,
+		IncomingSource:      models.AwardSource(award),
+		Decision:            decision,
+	}
+	err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "restaurant_id"}, {Name: "year"}, {Name: "existing_source"},
+			{Name: "incoming_distinction"}, {Name: "incoming_price"}, {Name: "incoming_green_star"}, {Name: "incoming_source"},
+		},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"existing_distinction", "existing_price", "existing_green_star",
+			"decision", "updated_at",
+		}),
+	}).Create(conflict).Error
+	if err != nil {
+		return fmt.Errorf("failed to record award conflict: %w", err)
+	}
+	return nil
+}
+
+// FindRestaurantByURL retrieves the restaurant at url or with url as an alias.
+func (r *SQLiteRepository) FindRestaurantByURL(ctx context.Context, url string) (*models.Restaurant, error) {
+	var restaurant models.Restaurant
+	err := r.db.WithContext(ctx).Where("id IN (?)", r.restaurantIDs(url)).First(&restaurant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &restaurant, nil
+}
 
-		updates := map[string]any{
-			"distinction": award.Distinction,
-			"green_star":  award.GreenStar,
-			"wayback_url": award.WaybackURL,
-			"year":        award.Year,
+// MarkSeen refreshes last_seen_at on the latest live capture of the
+// restaurant at url, for a page that has not changed since it was scraped. The
+// restaurant's updated_at is refreshed too, as the daily export selects the
+// restaurants updated that day. It reports false when the restaurant has no
+// live capture to refresh.
+func (r *SQLiteRepository) MarkSeen(ctx context.Context, url string) (bool, error) {
+	var latest models.AwardCapture
+	err := r.db.WithContext(ctx).
+		Where("restaurant_id IN (?) AND wayback_url = ''", r.restaurantIDs(url)).
+		Order("captured_at DESC, id DESC").Limit(1).Find(&latest).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to find award capture: %w", err)
+	}
+	if latest.ID == 0 {
+		return false, nil
+	}
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		if err := tx.Model(&latest).Update("last_seen_at", now).Error; err != nil {
+			return err
 		}
-		if award.Price != "" {
-			updates["price"] = award.Price
+		return tx.Model(&models.Restaurant{}).Where("id = ?", latest.RestaurantID).UpdateColumn("updated_at", now).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark restaurant seen: %w", err)
+	}
+	return true, nil
+}
+
+// ListLastSeen returns, by restaurant ID, when a live scrape last observed the
+// restaurant's award, whether it saved the page or found it unchanged.
+func (r *SQLiteRepository) ListLastSeen(ctx context.Context) (map[uint]time.Time, error) {
+	var captures []models.AwardCapture
+	err := r.db.WithContext(ctx).Select("restaurant_id", "last_seen_at").Where("wayback_url = ''").Find(&captures).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list award captures: %w", err)
+	}
+
+	lastSeen := make(map[uint]time.Time)
+	for _, c := range captures {
+		if c.LastSeenAt.After(lastSeen[c.RestaurantID]) {
+			lastSeen[c.RestaurantID] = c.LastSeenAt
 		}
-		return r.db.WithContext(ctx).
-			Model(&existing).
-			Updates(updates).Error
+	}
+	return lastSeen, nil
+}
+
+// ListRestaurants retrieves all restaurants that have a non-empty URL, along
+// with their aliases and awards.
+func (r *SQLiteRepository) ListRestaurants(ctx context.Context) ([]models.Restaurant, error) {
+	var restaurants []models.Restaurant
+	err := r.db.WithContext(ctx).Preload("Aliases").Preload("Awards").Where("url != ''").Find(&restaurants).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restaurants: %w", err)
 	}
 
-	// Scenario 2: Incoming Wayback (authoritative)
-	if award.WaybackURL != "" {
-		if !awardsEqual(&existing, award) {
-			diff := map[string]string{}
-			if existing.Distinction != award.Distinction {
-				diff["distinction"] = fmt.Sprintf("%v → %v", existing.Distinction, award.Distinction)
-			}
-			if existing.Price != award.Price {
-				diff["price"] = fmt.Sprintf("%v → %v", existing.Price, award.Price)
-			}
-			if existing.GreenStar != award.GreenStar {
-				diff["green_star"] = fmt.Sprintf("%v → %v", existing.GreenStar, award.GreenStar)
-			}
+	log.WithField("count", len(restaurants)).Debug("fetched restaurants from database")
+	return restaurants, nil
+}
 
-			if _, ok := diff["distinction"]; ok {
-				log.WithFields(log.Fields{
-					"restaurant_id": existing.RestaurantID,
-					"year":          existing.Year,
-					"diff":          diff,
-				}).Warn("overwriting award with wayback data")
-			}
+// FindBackfillState retrieves the backfill state of a restaurant URL in a web
+// archive, or nil if it has never been backfilled from that archive.
+func (r *SQLiteRepository) FindBackfillState(ctx context.Context, url, archive string) (*models.BackfillState, error) {
+	var state models.BackfillState
+	err := r.db.WithContext(ctx).Where("url = ? AND archive = ?", url, archive).Limit(1).Find(&state).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find backfill state: %w", err)
+	}
+	if state.URL == "" {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// SaveBackfillState creates or replaces the backfill state of a restaurant URL
+// in a web archive.
+func (r *SQLiteRepository) SaveBackfillState(ctx context.Context, state *models.BackfillState) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}, {Name: "archive"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_queried_at", "latest_snapshot", "outcome", "updated_at"}),
+	}).Create(state).Error
+}
+
+// ListConflicts retrieves the recorded award conflicts matching filter, with
+// their restaurants, most recently seen first.
+func (r *SQLiteRepository) ListConflicts(ctx context.Context, filter ConflictFilter) ([]models.AwardConflict, error) {
+	query := r.db.WithContext(ctx).Preload("Restaurant").Order("updated_at DESC, id DESC")
+	if filter.Year != 0 {
+		query = query.Where("year = ?", filter.Year)
+	}
+	if filter.Decision != "" {
+		query = query.Where("decision = ?", filter.Decision)
+	}
+	if filter.URL != "" {
+		query = query.Where("restaurant_id IN (?)", r.restaurantIDs(filter.URL))
+	}
+	if filter.DistinctionOnly {
+		query = query.Where("existing_distinction != incoming_distinction")
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var conflicts []models.AwardConflict
+	if err := query.Find(&conflicts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list award conflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+// findOverride retrieves the award override of a restaurant and year, or nil
+// if there is none.
+func findOverride(tx *gorm.DB, restaurantID uint, year int) (*models.AwardOverride, error) {
+	var override models.AwardOverride
+	err := tx.Where("restaurant_id = ? AND year = ?", restaurantID, year).Limit(1).Find(&override).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find award override: %w", err)
+	}
+	if override.ID == 0 {
+		return nil, nil
+	}
+	return &override, nil
+}
+
+// SaveOverride creates or replaces the award override of a restaurant and
+// year, and applies it to the award already stored for that year.
+func (r *SQLiteRepository) SaveOverride(ctx context.Context, override *models.AwardOverride) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "restaurant_id"}, {Name: "year"}},
+			DoUpdates: clause.AssignmentColumns([]string{"distinction", "price", "green_star", "reason", "updated_at"}),
+		}).Create(override).Error
+		if err != nil {
+			return fmt.Errorf("failed to save award override: %w", err)
 		}
 
-		updates := map[string]any{
-			"distinction": award.Distinction,
-			"green_star":  award.GreenStar,
-			"wayback_url": award.WaybackURL,
-			"year":        award.Year,
+		var existing models.RestaurantAward
+		err = tx.Where("restaurant_id = ? AND year = ?", override.RestaurantID, override.Year).Limit(1).Find(&existing).Error
+		if err != nil || existing.ID == 0 {
+			return err
 		}
-		if award.Price != "" {
-			updates["price"] = award.Price
+		pinned := existing
+		diff := override.Apply(&pinned)
+		if len(diff) == 0 {
+			return nil
 		}
+		log.WithFields(log.Fields{
+			"restaurant_id": existing.RestaurantID,
+			"year":          existing.Year,
+			"source":        models.AwardSource(&existing),
+			"diff":          diff,
+		}).Info("applying award override")
+		return tx.Model(&existing).Updates(map[string]any{
+			"distinction": pinned.Distinction,
+			"price":       pinned.Price,
+			"green_star":  pinned.GreenStar,
+		}).Error
+	})
+}
 
-		return r.db.WithContext(ctx).
-			Model(&existing).
-			Updates(updates).Error
+// ListOverrides retrieves the award overrides, with their restaurants, of the
+// restaurant at url or of every restaurant if url is empty.
+func (r *SQLiteRepository) ListOverrides(ctx context.Context, url string) ([]models.AwardOverride, error) {
+	query := r.db.WithContext(ctx).Preload("Restaurant").Order("restaurant_id, year")
+	if url != "" {
+		query = query.Where("restaurant_id IN (?)", r.restaurantIDs(url))
 	}
 
-	// Scenario 3: Existing Wayback, incoming live scrape
-	if existing.WaybackURL != "" && award.WaybackURL == "" {
-		if !awardsEqual(&existing, award) {
-			diff := map[string]string{}
-			if existing.Distinction != award.Distinction {
-				diff["distinction"] = fmt.Sprintf("%v → %v", existing.Distinction, award.Distinction)
+	var overrides []models.AwardOverride
+	if err := query.Find(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("failed to list award overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+// DeleteOverride removes the award override of a restaurant and year and
+// reports whether there was one. The stored award keeps the pinned values
+// until the next scrape or backfill replaces them.
+func (r *SQLiteRepository) DeleteOverride(ctx context.Context, restaurantID uint, year int) (bool, error) {
+	result := r.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", restaurantID, year).Delete(&models.AwardOverride{})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete award override: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// restaurantIDs is a subquery selecting the ID of the restaurant at url or
+// with url as an alias.
+func (r *SQLiteRepository) restaurantIDs(url string) *gorm.DB {
+	aliased := r.db.Model(&models.RestaurantAlias{}).Select("restaurant_id").Where("url = ?", url)
+	return r.db.Model(&models.Restaurant{}).Select("id").Where("url = ? OR id IN (?)", url, aliased)
+}
+
+// Close closes the database connection.
+func (r *SQLiteRepository) Close() error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// MergeRestaurants folds duplicate restaurants into the canonical one: their
+// award captures, overrides and conflicts move over, their URLs and aliases
+// become aliases of the canonical restaurant, and the awards of every year
+// they held are rolled up again. Overrides and conflicts the canonical
+// restaurant already has for the same year take precedence.
+func (r *SQLiteRepository) MergeRestaurants(ctx context.Context, canonicalID uint, duplicateIDs []uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var canonical models.Restaurant
+		if err := tx.Preload("Awards").First(&canonical, canonicalID).Error; err != nil {
+			return fmt.Errorf("failed to find restaurant %d: %w", canonicalID, err)
+		}
+		for i := range canonical.Awards {
+			if err := seedCapture(tx, &canonical.Awards[i]); err != nil {
+				return err
 			}
-			if existing.Price != award.Price {
-				diff["price"] = fmt.Sprintf("%v → %v", existing.Price, award.Price)
+		}
+
+		for _, id := range duplicateIDs {
+			if id == canonicalID {
+				return fmt.Errorf("cannot merge restaurant %d into itself", id)
 			}
-			if existing.GreenStar != award.GreenStar {
-				diff["green_star"] = fmt.Sprintf("%v → %v", existing.GreenStar, award.GreenStar)
+			var duplicate models.Restaurant
+			if err := tx.Preload("Awards").First(&duplicate, id).Error; err != nil {
+				return fmt.Errorf("failed to find restaurant %d: %w", id, err)
 			}
 
-			shouldOverride := existing.Distinction == models.SelectedRestaurants && award.Distinction != models.SelectedRestaurants
-			if shouldOverride {
-				if _, ok := diff["distinction"]; ok {
-					diff["distinction"] = fmt.Sprintf("%v → %v", existing.Distinction, award.Distinction)
-					log.WithFields(log.Fields{
-						"restaurant_id": existing.RestaurantID,
-						"year":          existing.Year,
-						"diff":          diff,
-					}).Warn("upgrading award distinction from live scrape")
+			years := map[int]bool{}
+			for i := range duplicate.Awards {
+				if err := seedCapture(tx, &duplicate.Awards[i]); err != nil {
+					return err
 				}
+				years[duplicate.Awards[i].Year] = true
+			}
 
-				updates := map[string]any{
-					"distinction": award.Distinction,
-					"green_star":  award.GreenStar,
-					"wayback_url": award.WaybackURL,
-					"year":        award.Year,
+			for _, stmt := range []string{
+				"UPDATE award_captures SET restaurant_id = ? WHERE restaurant_id = ?",
+				"UPDATE OR IGNORE award_overrides SET restaurant_id = ? WHERE restaurant_id = ?",
+				"UPDATE OR IGNORE award_conflicts SET restaurant_id = ? WHERE restaurant_id = ?",
+				"UPDATE restaurant_aliases SET restaurant_id = ? WHERE restaurant_id = ?",
+			} {
+				if err := tx.Exec(stmt, canonicalID, id).Error; err != nil {
+					return fmt.Errorf("failed to move records of restaurant %d: %w", id, err)
 				}
-				if award.Price != "" {
-					updates["price"] = award.Price
+			}
+			for _, model := range []any{&models.AwardOverride{}, &models.AwardConflict{}, &models.RestaurantAward{}} {
+				if err := tx.Where("restaurant_id = ?", id).Delete(model).Error; err != nil {
+					return fmt.Errorf("failed to remove records of restaurant %d: %w", id, err)
 				}
+			}
+			if err := tx.Delete(&duplicate).Error; err != nil {
+				return fmt.Errorf("failed to remove restaurant %d: %w", id, err)
+			}
+			if err := saveAlias(tx, duplicate.URL, canonicalID); err != nil {
+				return err
+			}
 
-				return r.db.WithContext(ctx).
-					Model(&existing).
-					Updates(updates).Error
-			} else {
-				log.WithFields(log.Fields{
-					"restaurant_id": existing.RestaurantID,
-					"year":          existing.Year,
-					"diff":          diff,
-				}).Debug("skipping award update: wayback priority")
+			for year := range years {
+				if err := r.saveRollup(tx, canonicalID, year); err != nil {
+					return err
+				}
 			}
+			log.WithFields(log.Fields{
+				"alias": duplicate.URL,
+				"url":   canonical.URL,
+				"years": len(years),
+			}).Info("merged duplicate restaurant")
 		}
 		return nil
-	}
-	return nil
+	})
 }
 
-func (r *SQLiteRepository) FindRestaurantByURL(ctx context.Context, url string) (*models.Restaurant, error) {
-	var restaurant models.Restaurant
-	err := r.db.WithContext(ctx).Where("url = ?", url).First(&restaurant).Error
+// saveAlias makes url an alias of the restaurant, taking it over from any
+// other restaurant it was an alias of.
+func saveAlias(tx *gorm.DB, url string, restaurantID uint) error {
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: clause.AssignmentColumns([]string{"restaurant_id"}),
+	}).Omit(clause.Associations).Create(&models.RestaurantAlias{URL: url, RestaurantID: restaurantID}).Error
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to save alias %s: %w", url, err)
 	}
-	return &restaurant, nil
+	return nil
 }
 
-// ListRestaurants retrieves all restaurants that have a non-empty URL.
-func (r *SQLiteRepository) ListRestaurants(ctx context.Context) ([]models.Restaurant, error) {
-	var restaurants []models.Restaurant
-	err := r.db.WithContext(ctx).Where("url != ''").Find(&restaurants).Error
+// saveRollup rolls up the award of a restaurant and year and stores it.
+func (r *SQLiteRepository) saveRollup(tx *gorm.DB, restaurantID uint, year int) error {
+	rolled, _, err := r.rollupAward(tx, restaurantID, year)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list restaurants: %w", err)
+		return err
 	}
-
-	log.WithField("count", len(restaurants)).Debug("fetched restaurants from database")
-	return restaurants, nil
+	var existing models.RestaurantAward
+	if err := tx.Where("restaurant_id = ? AND year = ?", restaurantID, year).Limit(1).Find(&existing).Error; err != nil {
+		return err
+	}
+	if existing.ID == 0 {
+		return tx.Create(rolled).Error
+	}
+	return tx.Model(&existing).Updates(map[string]any{
+		"distinction": rolled.Distinction,
+		"green_star":  rolled.GreenStar,
+		"price":       rolled.Price,
+		"wayback_url": rolled.WaybackURL,
+		"archive":     rolled.Archive,
+	}).Error
 }
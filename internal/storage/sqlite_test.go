@@ -2,11 +2,17 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/ngshiheng/michelin-my-maps/v4/internal/models"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/parsers"
+	"github.com/ngshiheng/michelin-my-maps/v4/internal/webarchive"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 func newTestRepo(t *testing.T) (*SQLiteRepository, func()) {
@@ -14,7 +20,7 @@ func newTestRepo(t *testing.T) (*SQLiteRepository, func()) {
 	dir := t.TempDir()
 	dbPath := filepath.Join(dir, "test.db")
 
-	repo, err := NewSQLiteRepository(dbPath)
+	repo, err := NewSQLiteRepository(dbPath, nil)
 	if err != nil {
 		t.Fatalf("failed to create test repo: %v", err)
 	}
@@ -377,4 +383,588 @@ func TestSQLiteRepository(t *testing.T) {
 			t.Fatalf("expected error for nonexistent restaurant URL, got nil")
 		}
 	})
+
+	t.Run("SaveBackfillState upserts state per url", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+		url := "https://guide.michelin.com/test/1"
+
+		got, err := repo.FindBackfillState(ctx, url, "wayback")
+		if err != nil || got != nil {
+			t.Fatalf("expected no state, got %+v, %v", got, err)
+		}
+
+		state := &models.BackfillState{URL: url, Archive: "wayback", LastQueriedAt: time.Now(), LatestSnapshot: "20230101000000", Outcome: models.BackfillComplete}
+		if err := repo.SaveBackfillState(ctx, state); err != nil {
+			t.Fatalf("SaveBackfillState failed: %v", err)
+		}
+		state = &models.BackfillState{URL: url, Archive: "wayback", LastQueriedAt: time.Now(), LatestSnapshot: "20240101000000", Outcome: models.BackfillIncomplete}
+		if err := repo.SaveBackfillState(ctx, state); err != nil {
+			t.Fatalf("SaveBackfillState upsert failed: %v", err)
+		}
+
+		got, err = repo.FindBackfillState(ctx, url, "wayback")
+		if err != nil || got == nil {
+			t.Fatalf("FindBackfillState failed: %+v, %v", got, err)
+		}
+		if got.LatestSnapshot != "20240101000000" || got.Outcome != models.BackfillIncomplete {
+			t.Fatalf("unexpected state after upsert: %+v", got)
+		}
+		if other, err := repo.FindBackfillState(ctx, url, "arquivo"); err != nil || other != nil {
+			t.Fatalf("state should be kept per archive, got %+v, %v", other, err)
+		}
+	})
+
+	t.Run("SaveAward records conflicts with the decision taken", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		r := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		created, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		year := time.Now().Year()
+
+		live := &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$", Year: year}
+		if err := repo.SaveAward(ctx, live); err != nil {
+			t.Fatalf("SaveAward live failed: %v", err)
+		}
+		archived := &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.TwoStars, Price: "$$", Year: year, WaybackURL: "https://web.archive.org/web/2"}
+		if err := repo.SaveAward(ctx, archived); err != nil {
+			t.Fatalf("SaveAward wayback failed: %v", err)
+		}
+		// The live scrape disagrees with the archived award twice; only one
+		// record is kept for the same disagreement.
+		for range 2 {
+			if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$", Year: year}); err != nil {
+				t.Fatalf("SaveAward live failed: %v", err)
+			}
+		}
+
+		conflicts, err := repo.ListConflicts(ctx, ConflictFilter{})
+		if err != nil {
+			t.Fatalf("ListConflicts failed: %v", err)
+		}
+		if len(conflicts) != 2 {
+			t.Fatalf("expected 2 conflicts, got %+v", conflicts)
+		}
+		decisions := map[string]models.AwardConflict{}
+		for _, c := range conflicts {
+			decisions[c.Decision] = c
+		}
+		overwritten := decisions[models.ConflictOverwritten]
+		if overwritten.ExistingSource != models.SourceLive || overwritten.IncomingSource != archived.WaybackURL ||
+			overwritten.ExistingDistinction != models.OneStar || overwritten.IncomingDistinction != models.TwoStars {
+			t.Fatalf("unexpected overwritten conflict: %+v", overwritten)
+		}
+		kept := decisions[models.ConflictKept]
+		if kept.ExistingSource != archived.WaybackURL || kept.IncomingSource != models.SourceLive || kept.Restaurant.URL != r.URL {
+			t.Fatalf("unexpected kept conflict: %+v", kept)
+		}
+
+		filtered, err := repo.ListConflicts(ctx, ConflictFilter{Decision: models.ConflictKept, URL: r.URL, Year: year})
+		if err != nil || len(filtered) != 1 {
+			t.Fatalf("expected 1 kept conflict, got %+v, %v", filtered, err)
+		}
+		if none, _ := repo.ListConflicts(ctx, ConflictFilter{Year: year - 1}); len(none) != 0 {
+			t.Fatalf("expected no conflicts for another year, got %+v", none)
+		}
+	})
+
+	t.Run("SaveAward honours overrides ahead of live and wayback data", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		r := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		created, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		year := time.Now().Year()
+
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.SelectedRestaurants, Price: "$$", Year: year}); err != nil {
+			t.Fatalf("SaveAward live failed: %v", err)
+		}
+
+		if err := repo.SaveOverride(ctx, &models.AwardOverride{RestaurantID: created.ID, Year: year}); err == nil {
+			t.Fatalf("expected an override pinning nothing to be rejected")
+		}
+		greenStar := true
+		override := &models.AwardOverride{RestaurantID: created.ID, Year: year, Distinction: models.OneStar, GreenStar: &greenStar, Reason: "no selector matched"}
+		if err := repo.SaveOverride(ctx, override); err != nil {
+			t.Fatalf("SaveOverride failed: %v", err)
+		}
+
+		var got models.RestaurantAward
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).First(&got).Error; err != nil {
+			t.Fatalf("query award failed: %v", err)
+		}
+		if got.Distinction != models.OneStar || !got.GreenStar || got.Price != "$$" {
+			t.Fatalf("expected override applied to stored award, got %+v", got)
+		}
+
+		for _, incoming := range []*models.RestaurantAward{
+			{RestaurantID: created.ID, Distinction: models.SelectedRestaurants, Price: "$$$", Year: year},
+			{RestaurantID: created.ID, Distinction: models.BibGourmand, Price: "$$$", Year: year, WaybackURL: "https://web.archive.org/web/1"},
+		} {
+			if err := repo.SaveAward(ctx, incoming); err != nil {
+				t.Fatalf("SaveAward failed: %v", err)
+			}
+			if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).First(&got).Error; err != nil {
+				t.Fatalf("query award failed: %v", err)
+			}
+			if got.Distinction != models.OneStar || !got.GreenStar || got.Price != "$$$" {
+				t.Fatalf("expected pinned fields to survive %s data, got %+v", models.AwardSource(incoming), got)
+			}
+		}
+
+		overrides, err := repo.ListOverrides(ctx, r.URL)
+		if err != nil || len(overrides) != 1 || overrides[0].Restaurant.URL != r.URL || overrides[0].Reason != override.Reason {
+			t.Fatalf("unexpected overrides: %+v, %v", overrides, err)
+		}
+
+		removed, err := repo.DeleteOverride(ctx, created.ID, year)
+		if err != nil || !removed {
+			t.Fatalf("DeleteOverride = %v, %v; want true", removed, err)
+		}
+		if removed, _ := repo.DeleteOverride(ctx, created.ID, year); removed {
+			t.Fatalf("expected nothing left to remove")
+		}
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.TwoStars, Price: "$$$", Year: year, WaybackURL: "https://web.archive.org/web/2"}); err != nil {
+			t.Fatalf("SaveAward failed: %v", err)
+		}
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).First(&got).Error; err != nil {
+			t.Fatalf("query award failed: %v", err)
+		}
+		if got.Distinction != models.TwoStars {
+			t.Fatalf("expected scraped data to apply once the override is removed, got %+v", got)
+		}
+	})
+
+	t.Run("SaveAward follows the configured merge policy", func(t *testing.T) {
+		repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"), LiveAlwaysWinsPolicy{})
+		if err != nil {
+			t.Fatalf("failed to create test repo: %v", err)
+		}
+
+		r := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		created, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		year := time.Now().Year()
+
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.TwoStars, Price: "$$", Year: year, WaybackURL: "https://web.archive.org/web/1"}); err != nil {
+			t.Fatalf("SaveAward wayback failed: %v", err)
+		}
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$", Year: year}); err != nil {
+			t.Fatalf("SaveAward live failed: %v", err)
+		}
+
+		var got models.RestaurantAward
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).First(&got).Error; err != nil {
+			t.Fatalf("query award failed: %v", err)
+		}
+		if got.Distinction != models.OneStar || got.WaybackURL != "" {
+			t.Fatalf("expected live award to win, got %+v", got)
+		}
+		conflicts, err := repo.ListConflicts(ctx, ConflictFilter{Decision: models.ConflictUpgraded})
+		if err != nil || len(conflicts) != 1 {
+			t.Fatalf("expected 1 upgraded conflict, got %+v, %v", conflicts, err)
+		}
+	})
+
+	t.Run("SaveAward keeps every capture and rolls up the yearly award", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		r := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		created, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		year := time.Now().Year() - 1
+		snapshot := func(month int) string {
+			return fmt.Sprintf("https://web.archive.org/web/%d%02d01000000/%s", year, month, r.URL)
+		}
+
+		// Snapshots arrive out of order: green star removed mid-year, then a
+		// second star at a regional launch.
+		for _, award := range []*models.RestaurantAward{
+			{RestaurantID: created.ID, Distinction: models.TwoStars, Price: "$$$", Year: year, WaybackURL: snapshot(9)},
+			{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$$", GreenStar: true, Year: year, WaybackURL: snapshot(1)},
+			{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$$", Year: year, WaybackURL: snapshot(5)},
+			{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$$", Year: year, WaybackURL: snapshot(5)},
+		} {
+			if err := repo.SaveAward(ctx, award); err != nil {
+				t.Fatalf("SaveAward failed: %v", err)
+			}
+		}
+
+		var captures []models.AwardCapture
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).Order("captured_at").Find(&captures).Error; err != nil {
+			t.Fatalf("query captures failed: %v", err)
+		}
+		if len(captures) != 3 {
+			t.Fatalf("expected 3 captures, got %+v", captures)
+		}
+		if !captures[0].GreenStar || captures[1].GreenStar || captures[2].Distinction != models.TwoStars {
+			t.Fatalf("unexpected capture timeline: %+v", captures)
+		}
+		if captures[0].CapturedAt.Month() != time.January {
+			t.Fatalf("expected captures to be dated by snapshot, got %v", captures[0].CapturedAt)
+		}
+
+		var got models.RestaurantAward
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).First(&got).Error; err != nil {
+			t.Fatalf("query award failed: %v", err)
+		}
+		if got.Distinction != models.TwoStars || got.GreenStar || got.WaybackURL != snapshot(9) {
+			t.Fatalf("expected the latest snapshot to roll up, got %+v", got)
+		}
+
+		// A live scrape that sees the same award again is not a new capture.
+		for range 2 {
+			if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$", Year: year + 1}); err != nil {
+				t.Fatalf("SaveAward live failed: %v", err)
+			}
+		}
+		var count int64
+		repo.db.WithContext(ctx).Model(&models.AwardCapture{}).Where("restaurant_id = ? AND year = ?", created.ID, year+1).Count(&count)
+		if count != 1 {
+			t.Fatalf("expected repeated live scrapes to share a capture, got %d", count)
+		}
+	})
+
+	t.Run("SaveAward and SaveRestaurant date a replayed live page by when it was fetched", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		r := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		created, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		year := time.Now().Year()
+		fetchedAt := time.Now().Add(-72 * time.Hour).UTC()
+
+		// A page scraped three days ago, then a newer scrape of another award.
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$", Year: year, SeenAt: fetchedAt}); err != nil {
+			t.Fatalf("SaveAward failed: %v", err)
+		}
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.TwoStars, Price: "$$", Year: year}); err != nil {
+			t.Fatalf("SaveAward failed: %v", err)
+		}
+
+		// Reparsing the older page corrects its price.
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$$", Year: year, SeenAt: fetchedAt}); err != nil {
+			t.Fatalf("SaveAward replay failed: %v", err)
+		}
+		var captures []models.AwardCapture
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).Order("captured_at").Find(&captures).Error; err != nil {
+			t.Fatalf("query captures failed: %v", err)
+		}
+		if len(captures) != 2 || !captures[0].CapturedAt.Equal(fetchedAt) || captures[0].Price != "$$$" || captures[1].Distinction != models.TwoStars {
+			t.Fatalf("expected the replay to update its own capture, got %+v", captures)
+		}
+		var got models.RestaurantAward
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ? AND year = ?", created.ID, year).First(&got).Error; err != nil {
+			t.Fatalf("query award failed: %v", err)
+		}
+		if got.Distinction != models.TwoStars {
+			t.Fatalf("expected the newer scrape to still roll up, got %+v", got)
+		}
+
+		replayed := validRestaurant()
+		replayed.UpdatedAt = fetchedAt
+		if err := repo.SaveRestaurant(ctx, replayed); err != nil {
+			t.Fatalf("SaveRestaurant replay failed: %v", err)
+		}
+		after, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		if !after.UpdatedAt.Equal(created.UpdatedAt) {
+			t.Fatalf("expected updated_at to stay %v, got %v", created.UpdatedAt, after.UpdatedAt)
+		}
+	})
+
+	t.Run("DeleteOverride leaves award captures alone", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		r := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		created, _ := repo.FindRestaurantByURL(ctx, r.URL)
+		year := time.Now().Year() - 1
+
+		for _, award := range []*models.RestaurantAward{
+			{RestaurantID: created.ID, Distinction: models.OneStar, Price: "$$$", Year: year, WaybackURL: fmt.Sprintf("https://web.archive.org/web/%d0101000000/%s", year, r.URL)},
+			{RestaurantID: created.ID, Distinction: models.TwoStars, Price: "$$$", Year: year, WaybackURL: fmt.Sprintf("https://web.archive.org/web/%d0901000000/%s", year, r.URL)},
+		} {
+			if err := repo.SaveAward(ctx, award); err != nil {
+				t.Fatalf("SaveAward failed: %v", err)
+			}
+		}
+		if err := repo.SaveOverride(ctx, &models.AwardOverride{RestaurantID: created.ID, Year: year, Distinction: models.ThreeStars}); err != nil {
+			t.Fatalf("SaveOverride failed: %v", err)
+		}
+
+		if removed, err := repo.DeleteOverride(ctx, created.ID, year); err != nil || !removed {
+			t.Fatalf("DeleteOverride = %v, %v; want true", removed, err)
+		}
+		var count int64
+		repo.db.WithContext(ctx).Model(&models.AwardCapture{}).Where("restaurant_id = ? AND year = ?", created.ID, year).Count(&count)
+		if count != 2 {
+			t.Fatalf("expected both captures to survive removing the override, got %d", count)
+		}
+	})
+
+	t.Run("MergeRestaurants folds duplicates and keeps their urls as aliases", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		canonical := validRestaurant()
+		canonical.URL = "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette"
+		duplicate := validRestaurant()
+		duplicate.URL = "https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette"
+		for _, r := range []*models.Restaurant{canonical, duplicate} {
+			if err := repo.SaveRestaurant(ctx, r); err != nil {
+				t.Fatalf("SaveRestaurant setup failed: %v", err)
+			}
+		}
+		keep, _ := repo.FindRestaurantByURL(ctx, canonical.URL)
+		dup, _ := repo.FindRestaurantByURL(ctx, duplicate.URL)
+
+		year := time.Now().Year() - 2
+		for _, award := range []*models.RestaurantAward{
+			{RestaurantID: keep.ID, Distinction: models.TwoStars, Price: "$$$$", Year: year + 1},
+			{RestaurantID: dup.ID, Distinction: models.OneStar, Price: "$$$$", Year: year},
+			{RestaurantID: dup.ID, Distinction: models.TwoStars, Price: "$$$$", Year: year + 1},
+		} {
+			if err := repo.SaveAward(ctx, award); err != nil {
+				t.Fatalf("SaveAward setup failed: %v", err)
+			}
+		}
+
+		if err := repo.MergeRestaurants(ctx, keep.ID, []uint{dup.ID}); err != nil {
+			t.Fatalf("MergeRestaurants failed: %v", err)
+		}
+
+		found, err := repo.FindRestaurantByURL(ctx, duplicate.URL)
+		if err != nil || found.ID != keep.ID {
+			t.Fatalf("expected the old url to resolve to the canonical restaurant, got %+v, %v", found, err)
+		}
+		var awards []models.RestaurantAward
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ?", keep.ID).Order("year").Find(&awards).Error; err != nil {
+			t.Fatalf("query awards failed: %v", err)
+		}
+		if len(awards) != 2 || awards[0].Year != year || awards[0].Distinction != models.OneStar {
+			t.Fatalf("expected both years on the canonical restaurant, got %+v", awards)
+		}
+		var count int64
+		repo.db.WithContext(ctx).Model(&models.Restaurant{}).Count(&count)
+		if count != 1 {
+			t.Fatalf("expected the duplicate to be removed, got %d restaurants", count)
+		}
+
+		// Scraping the old url again updates the canonical restaurant.
+		duplicate.ID = 0
+		duplicate.Name = "Odette"
+		if err := repo.SaveRestaurant(ctx, duplicate); err != nil {
+			t.Fatalf("SaveRestaurant under alias failed: %v", err)
+		}
+		if duplicate.ID != keep.ID {
+			t.Fatalf("expected the alias to save onto restaurant %d, got %d", keep.ID, duplicate.ID)
+		}
+		repo.db.WithContext(ctx).Model(&models.Restaurant{}).Count(&count)
+		if count != 1 {
+			t.Fatalf("expected no new restaurant for an alias url, got %d", count)
+		}
+	})
+
+	t.Run("MarkSeen refreshes the latest live capture", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		restaurant := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, restaurant); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		seen, err := repo.MarkSeen(ctx, restaurant.URL)
+		if err != nil || seen {
+			t.Fatalf("expected nothing to mark before a live capture, got %v, %v", seen, err)
+		}
+
+		year := time.Now().Year()
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: restaurant.ID, Distinction: models.OneStar, Price: "$$", Year: year}); err != nil {
+			t.Fatalf("SaveAward setup failed: %v", err)
+		}
+		past := time.Now().Add(-24 * time.Hour).UTC()
+		repo.db.WithContext(ctx).Model(&models.AwardCapture{}).Where("restaurant_id = ?", restaurant.ID).Update("last_seen_at", past)
+
+		seen, err = repo.MarkSeen(ctx, restaurant.URL)
+		if err != nil || !seen {
+			t.Fatalf("MarkSeen failed: %v, %v", seen, err)
+		}
+		var capture models.AwardCapture
+		if err := repo.db.WithContext(ctx).Where("restaurant_id = ?", restaurant.ID).First(&capture).Error; err != nil {
+			t.Fatalf("query capture failed: %v", err)
+		}
+		if !capture.LastSeenAt.After(past) {
+			t.Fatalf("expected last_seen_at to move on from %v, got %v", past, capture.LastSeenAt)
+		}
+
+		lastSeen, err := repo.ListLastSeen(ctx)
+		if err != nil {
+			t.Fatalf("ListLastSeen failed: %v", err)
+		}
+		if !lastSeen[restaurant.ID].Equal(capture.LastSeenAt) {
+			t.Fatalf("expected ListLastSeen to report %v, got %v", capture.LastSeenAt, lastSeen)
+		}
+	})
+
+	t.Run("MarkSeen keeps an unchanged restaurant in the daily export", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		restaurant := validRestaurant()
+		if err := repo.SaveRestaurant(ctx, restaurant); err != nil {
+			t.Fatalf("SaveRestaurant setup failed: %v", err)
+		}
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: restaurant.ID, Distinction: models.OneStar, Price: "$$", Year: time.Now().Year()}); err != nil {
+			t.Fatalf("SaveAward setup failed: %v", err)
+		}
+		// Saved on a previous run, then skipped as not modified today.
+		past := time.Now().Add(-48 * time.Hour).UTC()
+		repo.db.WithContext(ctx).Model(&models.Restaurant{}).Where("id = ?", restaurant.ID).UpdateColumn("updated_at", past)
+
+		if seen, err := repo.MarkSeen(ctx, restaurant.URL); err != nil || !seen {
+			t.Fatalf("MarkSeen failed: %v, %v", seen, err)
+		}
+		// The query docker/scraper/docker-entrypoint.sh exports the CSV with.
+		var exported int64
+		err := repo.db.WithContext(ctx).Model(&models.Restaurant{}).
+			Where("id = ? AND DATE(updated_at) = DATE('now')", restaurant.ID).Count(&exported).Error
+		if err != nil {
+			t.Fatalf("query restaurant failed: %v", err)
+		}
+		if exported != 1 {
+			t.Fatal("expected the restaurant to be exported after MarkSeen")
+		}
+	})
+}
+
+func TestNewSQLiteRepositoryMigratesBackfillStateKey(t *testing.T) {
+	ctx := context.Background()
+	url := "https://guide.michelin.com/test/1"
+
+	// backfill_state as created before it was keyed by archive, and as left
+	// by AutoMigrate adding the archive column to it.
+	oldSchema := []string{
+		"CREATE TABLE `backfill_state` (`url` text,`last_queried_at` datetime NOT NULL,`latest_snapshot` text,`outcome` text NOT NULL,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`url`))",
+		"INSERT INTO backfill_state (url, last_queried_at, latest_snapshot, outcome, created_at, updated_at) VALUES ('" + url + "', '2025-01-01 00:00:00', '20241201000000', 'complete', '2025-01-01 00:00:00', '2025-01-01 00:00:00')",
+	}
+	tests := map[string][]string{
+		"url key":              oldSchema,
+		"url key with archive": append(slices.Clone(oldSchema), "ALTER TABLE `backfill_state` ADD `archive` text"),
+	}
+	for name, schema := range tests {
+		t.Run(name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "test.db")
+			old, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+			if err != nil {
+				t.Fatalf("open old database: %v", err)
+			}
+			for _, stmt := range schema {
+				if err := old.Exec(stmt).Error; err != nil {
+					t.Fatalf("create old schema: %v", err)
+				}
+			}
+			if db, err := old.DB(); err == nil {
+				db.Close()
+			}
+
+			for range 2 { // the migration runs once
+				repo, err := NewSQLiteRepository(dbPath, nil)
+				if err != nil {
+					t.Fatalf("NewSQLiteRepository: %v", err)
+				}
+
+				got, err := repo.FindBackfillState(ctx, url, webarchive.Wayback)
+				if err != nil || got == nil {
+					t.Fatalf("expected the old state under %s, got %+v, %v", webarchive.Wayback, got, err)
+				}
+				if got.LatestSnapshot != "20241201000000" || got.Outcome != models.BackfillComplete {
+					t.Fatalf("unexpected migrated state: %+v", got)
+				}
+				state := &models.BackfillState{URL: url, Archive: "arquivo", LastQueriedAt: time.Now(), Outcome: models.BackfillNoSnapshots}
+				if err := repo.SaveBackfillState(ctx, state); err != nil {
+					t.Fatalf("SaveBackfillState after migration failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSQLiteRepositoryCanonicalizesURLs(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	const (
+		sgOdette  = "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/odette"
+		enOdette  = "https://guide.michelin.com/en/singapore-region/singapore/restaurant/odette"
+		sgBurnt   = "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/burnt-ends"
+		enBurnt   = "https://guide.michelin.com/en/singapore-region/singapore/restaurant/burnt-ends"
+		sgOldSlug = "https://guide.michelin.com/sg/en/singapore-region/singapore/restaurant/burnt-ends-old"
+	)
+
+	// Restaurants saved before the locale prefix was canonicalized: one form
+	// of Odette each, and Burnt Ends under its locale with an old slug.
+	repo, err := NewSQLiteRepository(dbPath, nil)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository: %v", err)
+	}
+	year := time.Now().Year()
+	ids := map[string]uint{}
+	for i, url := range []string{sgOdette, enOdette, sgBurnt} {
+		r := validRestaurant()
+		r.URL = url
+		if err := repo.SaveRestaurant(ctx, r); err != nil {
+			t.Fatalf("SaveRestaurant(%s): %v", url, err)
+		}
+		if err := repo.SaveAward(ctx, &models.RestaurantAward{RestaurantID: r.ID, Distinction: models.OneStar, Price: "$$", Year: year - i}); err != nil {
+			t.Fatalf("SaveAward(%s): %v", url, err)
+		}
+		ids[url] = r.ID
+	}
+	if err := repo.db.Create(&models.RestaurantAlias{URL: sgOldSlug, RestaurantID: ids[sgBurnt]}).Error; err != nil {
+		t.Fatalf("create alias: %v", err)
+	}
+	repo.Close()
+
+	for range 2 { // reopening changes nothing more
+		repo, err := NewSQLiteRepository(dbPath, nil)
+		if err != nil {
+			t.Fatalf("NewSQLiteRepository: %v", err)
+		}
+
+		odette, err := repo.FindRestaurantByURL(ctx, enOdette)
+		if err != nil || odette.ID != ids[enOdette] {
+			t.Fatalf("expected Odette to stay restaurant %d, got %+v, %v", ids[enOdette], odette, err)
+		}
+		burnt, err := repo.FindRestaurantByURL(ctx, enBurnt)
+		if err != nil || burnt.ID != ids[sgBurnt] {
+			t.Fatalf("expected Burnt Ends to move to %s, got %+v, %v", enBurnt, burnt, err)
+		}
+		for url, want := range map[string]uint{
+			sgOdette:                        odette.ID,
+			sgBurnt:                         burnt.ID,
+			sgOldSlug:                       burnt.ID,
+			parsers.CanonicalURL(sgOldSlug): burnt.ID,
+		} {
+			var alias models.RestaurantAlias
+			if err := repo.db.Where("url = ?", url).First(&alias).Error; err != nil || alias.RestaurantID != want {
+				t.Fatalf("expected %s to be an alias of restaurant %d, got %+v, %v", url, want, alias, err)
+			}
+		}
+
+		var restaurants, awards int64
+		repo.db.Model(&models.Restaurant{}).Count(&restaurants)
+		repo.db.Model(&models.RestaurantAward{}).Where("restaurant_id = ?", odette.ID).Count(&awards)
+		if restaurants != 2 || awards != 2 {
+			t.Fatalf("expected the two Odettes merged with both awards, got %d restaurants and %d awards", restaurants, awards)
+		}
+		repo.Close()
+	}
 }
@@ -0,0 +1,149 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a single WARC record.
+type Record struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// Type returns the WARC-Type of the record.
+func (r *Record) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+// TargetURI returns the WARC-Target-URI of the record.
+func (r *Record) TargetURI() string {
+	return r.Header.Get("WARC-Target-URI")
+}
+
+// Date returns the WARC-Date of the record, or the zero time if it is missing
+// or invalid.
+func (r *Record) Date() time.Time {
+	date, _ := time.Parse(time.RFC3339, r.Header.Get("WARC-Date"))
+	return date
+}
+
+// HTTPResponse parses the block of a response record as an HTTP response and
+// returns it together with its body.
+func (r *Record) HTTPResponse() (*http.Response, []byte, error) {
+	if r.Type() != "response" {
+		return nil, nil, fmt.Errorf("record is a %q record, not a response", r.Type())
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Block)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// Reader reads records from a WARC file, compressed or not.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader over r. Gzip input, including the usual one
+// member per record layout, is detected and decompressed transparently.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(zr)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF once the input is exhausted.
+func (r *Reader) Next() (*Record, error) {
+	tp := textproto.NewReader(r.r)
+
+	line, err := tp.ReadLine()
+	for err == nil && line == "" {
+		line, err = tp.ReadLine() // tolerate stray blank lines between records
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, fmt.Errorf("invalid WARC version line %q", line)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, trailer); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return &Record{Header: header, Block: block}, nil
+}
+
+// EachResponse calls fn with the target URI, date and body of every successful
+// response record in the WARC file at path.
+func EachResponse(path string, fn func(targetURI string, date time.Time, body []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Type() != "response" {
+			continue
+		}
+		resp, body, err := rec.HTTPResponse()
+		if err != nil {
+			return fmt.Errorf("failed to parse response for %s: %w", rec.TargetURI(), err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if err := fn(rec.TargetURI(), rec.Date(), body); err != nil {
+			return err
+		}
+	}
+}
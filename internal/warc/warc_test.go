@@ -0,0 +1,137 @@
+package warc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+func newTestResponse(t *testing.T, rawURL string, status int, body string) *colly.Response {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	reqHeaders := http.Header{"Cookie": {"session=secret"}, "Accept-Language": {"en-SG,en;q=0.9"}}
+	respHeaders := http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {"gzip"}}
+	return &colly.Response{
+		StatusCode: status,
+		Body:       []byte(body),
+		Headers:    &respHeaders,
+		Request:    &colly.Request{URL: u, Method: http.MethodGet, Headers: &reqHeaders},
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "scrape", 0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	body := "<html><body>restaurant</body></html>"
+	if err := w.WriteResponse(newTestResponse(t, "https://guide.michelin.com/sg/en/a?x=1", http.StatusOK, body)); err != nil {
+		t.Fatalf("WriteResponse: %v", err)
+	}
+	if err := w.WriteResponse(newTestResponse(t, "https://guide.michelin.com/sg/en/b", http.StatusNotFound, "gone")); err != nil {
+		t.Fatalf("WriteResponse: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "scrape-*.warc.gz"))
+	if len(files) != 1 {
+		t.Fatalf("files = %v, want one", files)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	var types []string
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		types = append(types, rec.Type())
+		if rec.Type() == "request" {
+			if bytes.Contains(rec.Block, []byte("secret")) {
+				t.Errorf("request record leaks cookie: %q", rec.Block)
+			}
+			if rec.Header.Get("WARC-Concurrent-To") == "" {
+				t.Errorf("request record missing WARC-Concurrent-To")
+			}
+		}
+	}
+	if got := strings.Join(types, ","); got != "warcinfo,response,request,response,request" {
+		t.Errorf("record types = %s", got)
+	}
+
+	var got []string
+	err = EachResponse(files[0], func(targetURI string, date time.Time, b []byte) error {
+		got = append(got, targetURI)
+		if string(b) != body {
+			t.Errorf("body = %q, want %q", b, body)
+		}
+		if time.Since(date) > time.Minute {
+			t.Errorf("date = %v, want the time the response was written", date)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachResponse: %v", err)
+	}
+	if len(got) != 1 || got[0] != "https://guide.michelin.com/sg/en/a?x=1" {
+		t.Errorf("EachResponse visited %v, want only the 200 response", got)
+	}
+}
+
+func TestWriterRollsBySize(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "backfill", 1)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := w.WriteResponse(newTestResponse(t, "https://web.archive.org/web/20230101000000id_/https://guide.michelin.com/a", http.StatusOK, "<html></html>")); err != nil {
+			t.Fatalf("WriteResponse: %v", err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "backfill-*.warc.gz"))
+	if len(files) != 3 {
+		t.Fatalf("files = %v, want 3", files)
+	}
+	for _, file := range files {
+		var n int
+		if err := EachResponse(file, func(string, time.Time, []byte) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatalf("EachResponse(%s): %v", file, err)
+		}
+		if n != 1 {
+			t.Errorf("%s holds %d responses, want 1", file, n)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+const (
+	// DefaultMaxSize is the size at which a WARC file is rolled over, as
+	// recommended by the WARC specification.
+	DefaultMaxSize = 1 << 30
+
+	version = "WARC/1.1"
+)
+
+// Writer appends request/response record pairs to gzip-compressed WARC files
+// in a directory, starting a new file once the current one reaches maxSize.
+// Each record is its own gzip member, so a file stays readable even if the
+// process exits mid-crawl.
+type Writer struct {
+	dir     string
+	prefix  string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	seq  int
+}
+
+// NewWriter returns a Writer that writes <prefix>-<timestamp>-<seq>.warc.gz
+// files into dir. A non-positive maxSize uses DefaultMaxSize.
+func NewWriter(dir, prefix string, maxSize int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Writer{dir: dir, prefix: prefix, maxSize: maxSize}, nil
+}
+
+// WriteResponse records the exchange behind a colly response as a response
+// record followed by its request record.
+func (w *Writer) WriteResponse(r *colly.Response) error {
+	now := time.Now().UTC()
+	target := r.Request.URL.String()
+
+	responseID := recordID()
+	responseBlock := httpResponseBlock(r)
+	response := record{
+		header: [][2]string{
+			{"WARC-Type", "response"},
+			{"WARC-Record-ID", responseID},
+			{"WARC-Date", now.Format(time.RFC3339)},
+			{"WARC-Target-URI", target},
+			{"WARC-Payload-Digest", digest(r.Body)},
+			{"WARC-Block-Digest", digest(responseBlock)},
+			{"Content-Type", "application/http;msgtype=response"},
+		},
+		block: responseBlock,
+	}
+
+	requestBlock := httpRequestBlock(r.Request)
+	request := record{
+		header: [][2]string{
+			{"WARC-Type", "request"},
+			{"WARC-Record-ID", recordID()},
+			{"WARC-Date", now.Format(time.RFC3339)},
+			{"WARC-Target-URI", target},
+			{"WARC-Concurrent-To", responseID},
+			{"WARC-Block-Digest", digest(requestBlock)},
+			{"Content-Type", "application/http;msgtype=request"},
+		},
+		block: requestBlock,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.rotate(now); err != nil {
+		return err
+	}
+	for _, rec := range []record{response, request} {
+		if err := w.write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the current WARC file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// rotate opens a new file, starting with a warcinfo record, when there is no
+// current file or it has reached maxSize.
+func (w *Writer) rotate(now time.Time) error {
+	if w.file != nil && w.size < w.maxSize {
+		return nil
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	w.seq++
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, now.Format("20060102150405"), w.seq)
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+
+	info := []byte("software: mym\r\nformat: WARC File Format 1.1\r\n")
+	return w.write(record{
+		header: [][2]string{
+			{"WARC-Type", "warcinfo"},
+			{"WARC-Record-ID", recordID()},
+			{"WARC-Date", now.Format(time.RFC3339)},
+			{"WARC-Filename", name},
+			{"Content-Type", "application/warc-fields"},
+		},
+		block: info,
+	})
+}
+
+func (w *Writer) write(rec record) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(rec.bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	n, err := w.file.Write(buf.Bytes())
+	w.size += int64(n)
+	return err
+}
+
+type record struct {
+	header [][2]string
+	block  []byte
+}
+
+func (rec record) bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(version + "\r\n")
+	for _, h := range rec.header {
+		buf.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(rec.block)) + "\r\n\r\n")
+	buf.Write(rec.block)
+	buf.WriteString("\r\n\r\n")
+	return buf.Bytes()
+}
+
+// httpResponseBlock reconstructs the HTTP response message. colly has already
+// decoded the body, so content and transfer encodings are dropped and the
+// length is set to the decoded body.
+func httpResponseBlock(r *colly.Response) []byte {
+	header := http.Header{}
+	if r.Headers != nil {
+		header = r.Headers.Clone()
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(r.Body)))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", r.StatusCode, http.StatusText(r.StatusCode))
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(r.Body)
+	return buf.Bytes()
+}
+
+// httpRequestBlock reconstructs the HTTP request message. The Cookie header is
+// dropped so session credentials never end up in the archive.
+func httpRequestBlock(r *colly.Request) []byte {
+	header := http.Header{}
+	if r.Headers != nil {
+		header = r.Headers.Clone()
+	}
+	header.Del("Cookie")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", r.Method, r.URL.RequestURI())
+	fmt.Fprintf(&buf, "Host: %s\r\n", r.URL.Host)
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func recordID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
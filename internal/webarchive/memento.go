@@ -0,0 +1,143 @@
+package webarchive
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimestampLayout is the 14 digit timestamp used in capture URLs and by the
+// CDX API.
+const TimestampLayout = "20060102150405"
+
+// Memento is a single capture listed in a TimeMap.
+type Memento struct {
+	URI      string
+	Datetime time.Time
+}
+
+// Timestamp returns the capture time as a 14 digit timestamp.
+func (m Memento) Timestamp() string {
+	return m.Datetime.UTC().Format(TimestampLayout)
+}
+
+// TimeMap is a parsed application/link-format TimeMap (RFC 7089 section 5).
+type TimeMap struct {
+	Original string
+	TimeGate string
+	Mementos []Memento
+}
+
+// Link is a single entry of an RFC 8288 link list.
+type Link struct {
+	URI    string
+	Params map[string]string
+}
+
+// Rels returns the space-separated relation types of the link.
+func (l Link) Rels() []string {
+	return strings.Fields(l.Params["rel"])
+}
+
+// HasRel reports whether the link has the given relation type.
+func (l Link) HasRel(rel string) bool {
+	for _, r := range l.Rels() {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTimeMap parses a link-format TimeMap. Links whose rel includes
+// "memento" become Mementos, in the order they are listed.
+func ParseTimeMap(body []byte) (*TimeMap, error) {
+	links, err := ParseLinks(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	tm := &TimeMap{}
+	for _, l := range links {
+		switch {
+		case l.HasRel("memento"):
+			dt, err := http.ParseTime(l.Params["datetime"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid memento datetime %q: %w", l.Params["datetime"], err)
+			}
+			tm.Mementos = append(tm.Mementos, Memento{URI: l.URI, Datetime: dt})
+		case l.HasRel("original"):
+			tm.Original = l.URI
+		case l.HasRel("timegate"):
+			tm.TimeGate = l.URI
+		}
+	}
+	return tm, nil
+}
+
+// ParseLinks parses a comma-separated list of links as found in a Link header
+// or a link-format document. Quoted parameter values may contain commas, as
+// memento datetimes do.
+func ParseLinks(s string) ([]Link, error) {
+	var links []Link
+	i := 0
+	skip := func(chars string) {
+		for i < len(s) && strings.IndexByte(chars, s[i]) >= 0 {
+			i++
+		}
+	}
+
+	for {
+		skip(" \t\r\n,")
+		if i >= len(s) {
+			return links, nil
+		}
+		if s[i] != '<' {
+			return nil, fmt.Errorf("expected '<' at offset %d", i)
+		}
+		end := strings.IndexByte(s[i:], '>')
+		if end < 0 {
+			return nil, errors.New("unterminated link target")
+		}
+		link := Link{URI: s[i+1 : i+end], Params: map[string]string{}}
+		i += end + 1
+
+		for {
+			skip(" \t\r\n")
+			if i >= len(s) || s[i] != ';' {
+				break
+			}
+			i++
+			skip(" \t\r\n")
+
+			start := i
+			for i < len(s) && s[i] != '=' && s[i] != ';' && s[i] != ',' {
+				i++
+			}
+			name := strings.ToLower(strings.TrimSpace(s[start:i]))
+			value := ""
+			if i < len(s) && s[i] == '=' {
+				i++
+				skip(" \t")
+				if i < len(s) && s[i] == '"' {
+					closing := strings.IndexByte(s[i+1:], '"')
+					if closing < 0 {
+						return nil, errors.New("unterminated quoted parameter")
+					}
+					value = s[i+1 : i+1+closing]
+					i += closing + 2
+				} else {
+					start := i
+					for i < len(s) && s[i] != ';' && s[i] != ',' {
+						i++
+					}
+					value = strings.TrimSpace(s[start:i])
+				}
+			}
+			link.Params[name] = value
+		}
+		links = append(links, link)
+	}
+}
@@ -0,0 +1,122 @@
+// Package webarchive knows about public web archives that serve captures at
+// Wayback-style URLs (<prefix><timestamp>[modifier]/<original URL>) and speaks
+// the Memento protocol (RFC 7089) to list them.
+package webarchive
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Wayback is the name of the Internet Archive's Wayback Machine.
+const Wayback = "wayback"
+
+// Archive describes a web archive.
+type Archive struct {
+	Name string
+
+	// Prefix is what precedes the 14 digit timestamp in capture URLs.
+	Prefix string
+
+	// TimeMap is the Memento TimeMap endpoint; the original URL is appended
+	// to it.
+	TimeMap string
+
+	pattern *regexp.Regexp
+}
+
+// Archives lists the archives backfill can draw from.
+var Archives = []*Archive{
+	newArchive(Wayback, "https://web.archive.org/web/", "https://web.archive.org/web/timemap/link/"),
+	newArchive("arquivo", "https://arquivo.pt/wayback/", "https://arquivo.pt/wayback/timemap/link/"),
+	newArchive("ukwa", "https://www.webarchive.org.uk/wayback/archive/", "https://www.webarchive.org.uk/wayback/archive/timemap/link/"),
+}
+
+func newArchive(name, prefix, timeMap string) *Archive {
+	u, _ := url.Parse(prefix)
+	pattern := regexp.MustCompile(`^(https?://` + regexp.QuoteMeta(u.Host) + regexp.QuoteMeta(u.Path) + `)(\d{14})[^/]*/(.+)$`)
+	return &Archive{
+		Name:    name,
+		Prefix:  prefix,
+		TimeMap: timeMap,
+		pattern: pattern,
+	}
+}
+
+// Host returns the host capture URLs are served from.
+func (a *Archive) Host() string {
+	u, _ := url.Parse(a.Prefix)
+	return u.Host
+}
+
+// RawURL returns the URL of the unmodified capture of original at timestamp,
+// without the archive's banner or rewritten links.
+func (a *Archive) RawURL(timestamp, original string) string {
+	return a.Prefix + timestamp + "id_/" + original
+}
+
+// RawMementoURL returns the unmodified variant of a memento URI listed by
+// this archive, keeping the scheme and original URL the archive reported.
+// URIs that are not Wayback-style captures of this archive are returned as
+// they are.
+func (a *Archive) RawMementoURL(uri string) string {
+	m := a.pattern.FindStringSubmatch(uri)
+	if m == nil {
+		return uri
+	}
+	return m[1] + m[2] + "id_/" + m[3]
+}
+
+// TimeMapURL returns the URL of the TimeMap listing the captures of original.
+func (a *Archive) TimeMapURL(original string) string {
+	return a.TimeMap + original
+}
+
+// parse splits a capture URL of this archive into timestamp and original URL.
+func (a *Archive) parse(rawURL string) (timestamp, original string, ok bool) {
+	m := a.pattern.FindStringSubmatch(rawURL)
+	if m == nil {
+		return "", "", false
+	}
+	return m[2], m[3], true
+}
+
+// ByName returns the archive with the given name.
+func ByName(name string) (*Archive, bool) {
+	for _, a := range Archives {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the names of all known archives.
+func Names() []string {
+	names := make([]string, len(Archives))
+	for i, a := range Archives {
+		names[i] = a.Name
+	}
+	return names
+}
+
+// Capture is a capture URL split into its parts.
+type Capture struct {
+	Archive   *Archive
+	Timestamp string // yyyyMMddhhmmss
+	Original  string
+}
+
+// Lookup identifies rawURL as a capture served by one of the known archives.
+func Lookup(rawURL string) (*Capture, bool) {
+	if !strings.HasPrefix(rawURL, "http") {
+		return nil, false
+	}
+	for _, a := range Archives {
+		if ts, original, ok := a.parse(rawURL); ok {
+			return &Capture{Archive: a, Timestamp: ts, Original: original}, true
+		}
+	}
+	return nil, false
+}
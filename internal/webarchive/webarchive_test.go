@@ -0,0 +1,139 @@
+package webarchive
+
+import (
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		rawURL    string
+		archive   string
+		timestamp string
+		original  string
+	}{
+		{
+			rawURL:    "https://web.archive.org/web/20220101000000/https://guide.michelin.com/sg/en/a",
+			archive:   Wayback,
+			timestamp: "20220101000000",
+			original:  "https://guide.michelin.com/sg/en/a",
+		},
+		{
+			rawURL:    "http://web.archive.org/web/20220101000000id_/https://guide.michelin.com/sg/en/a",
+			archive:   Wayback,
+			timestamp: "20220101000000",
+			original:  "https://guide.michelin.com/sg/en/a",
+		},
+		{
+			rawURL:    "https://arquivo.pt/wayback/20210505120000id_/https://guide.michelin.com/pt/en/b",
+			archive:   "arquivo",
+			timestamp: "20210505120000",
+			original:  "https://guide.michelin.com/pt/en/b",
+		},
+		{
+			rawURL:    "https://www.webarchive.org.uk/wayback/archive/20200101000000mp_/https://guide.michelin.com/gb/en/c",
+			archive:   "ukwa",
+			timestamp: "20200101000000",
+			original:  "https://guide.michelin.com/gb/en/c",
+		},
+	}
+	for _, tt := range tests {
+		c, ok := Lookup(tt.rawURL)
+		if !ok {
+			t.Errorf("Lookup(%q) found no capture", tt.rawURL)
+			continue
+		}
+		if c.Archive.Name != tt.archive || c.Timestamp != tt.timestamp || c.Original != tt.original {
+			t.Errorf("Lookup(%q) = {%s %s %s}; want {%s %s %s}", tt.rawURL, c.Archive.Name, c.Timestamp, c.Original, tt.archive, tt.timestamp, tt.original)
+		}
+	}
+
+	for _, rawURL := range []string{
+		"https://guide.michelin.com/sg/en/a",
+		"https://web.archive.org/web/timemap/link/https://guide.michelin.com/sg/en/a",
+		"/web/20220101000000/https://guide.michelin.com/sg/en/a",
+	} {
+		if _, ok := Lookup(rawURL); ok {
+			t.Errorf("Lookup(%q) should not find a capture", rawURL)
+		}
+	}
+}
+
+func TestArchiveURLs(t *testing.T) {
+	a, ok := ByName("arquivo")
+	if !ok {
+		t.Fatal("ByName(arquivo) not found")
+	}
+	original := "https://guide.michelin.com/pt/en/b"
+	if got, want := a.RawURL("20210505120000", original), "https://arquivo.pt/wayback/20210505120000id_/"+original; got != want {
+		t.Errorf("RawURL() = %q; want %q", got, want)
+	}
+	if got, want := a.RawMementoURL("http://arquivo.pt/wayback/20210505120000mp_/"+original+"/"), "http://arquivo.pt/wayback/20210505120000id_/"+original+"/"; got != want {
+		t.Errorf("RawMementoURL() = %q; want %q", got, want)
+	}
+	if got, want := a.RawMementoURL("https://example.org/memento/1"), "https://example.org/memento/1"; got != want {
+		t.Errorf("RawMementoURL() = %q; want %q", got, want)
+	}
+	if got, want := a.TimeMapURL(original), "https://arquivo.pt/wayback/timemap/link/"+original; got != want {
+		t.Errorf("TimeMapURL() = %q; want %q", got, want)
+	}
+	if got := a.Host(); got != "arquivo.pt" {
+		t.Errorf("Host() = %q; want arquivo.pt", got)
+	}
+	if _, ok := ByName("nope"); ok {
+		t.Errorf("ByName(nope) should not be found")
+	}
+}
+
+func TestParseTimeMap(t *testing.T) {
+	body := `<https://guide.michelin.com/sg/en/a>; rel="original",
+<https://arquivo.pt/wayback/timemap/link/https://guide.michelin.com/sg/en/a>; rel="self"; type="application/link-format",
+<https://arquivo.pt/wayback/https://guide.michelin.com/sg/en/a>; rel="timegate",
+<https://arquivo.pt/wayback/20210505120000/https://guide.michelin.com/sg/en/a>; rel="first memento"; datetime="Wed, 05 May 2021 12:00:00 GMT",
+<https://arquivo.pt/wayback/20230101080910/https://guide.michelin.com/sg/en/a>; rel="last memento"; datetime="Sun, 01 Jan 2023 08:09:10 GMT"
+`
+	tm, err := ParseTimeMap([]byte(body))
+	if err != nil {
+		t.Fatalf("ParseTimeMap: %v", err)
+	}
+	if tm.Original != "https://guide.michelin.com/sg/en/a" {
+		t.Errorf("Original = %q", tm.Original)
+	}
+	if tm.TimeGate != "https://arquivo.pt/wayback/https://guide.michelin.com/sg/en/a" {
+		t.Errorf("TimeGate = %q", tm.TimeGate)
+	}
+	if len(tm.Mementos) != 2 {
+		t.Fatalf("got %d mementos; want 2", len(tm.Mementos))
+	}
+	if got := tm.Mementos[0].Timestamp(); got != "20210505120000" {
+		t.Errorf("first memento timestamp = %q", got)
+	}
+	if got := tm.Mementos[1].Timestamp(); got != "20230101080910" {
+		t.Errorf("last memento timestamp = %q", got)
+	}
+
+	if _, err := ParseTimeMap([]byte(`<https://a>; rel="memento"; datetime="yesterday"`)); err == nil {
+		t.Errorf("ParseTimeMap should reject an invalid memento datetime")
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	links, err := ParseLinks(`<https://a>; rel="memento"; datetime="Sun, 01 Jan 2023 08:09:10 GMT", <https://b>;rel=timegate`)
+	if err != nil {
+		t.Fatalf("ParseLinks: %v", err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("got %d links; want 2", len(links))
+	}
+	if links[0].URI != "https://a" || links[0].Params["datetime"] != "Sun, 01 Jan 2023 08:09:10 GMT" {
+		t.Errorf("links[0] = %+v", links[0])
+	}
+	if links[1].URI != "https://b" || !links[1].HasRel("timegate") {
+		t.Errorf("links[1] = %+v", links[1])
+	}
+
+	for _, s := range []string{`https://a; rel="memento"`, `<https://a`, `<https://a>; rel="memento`} {
+		if _, err := ParseLinks(s); err == nil {
+			t.Errorf("ParseLinks(%q) should fail", s)
+		}
+	}
+}